@@ -0,0 +1,310 @@
+package dagr
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Appender is implemented by fields that can append their line protocol encoding to a byte slice. All of dagr's field
+// types implement Appender and do so without allocating. Fields that don't implement Appender are appended by way of
+// their WriteTo method, which may allocate.
+type Appender interface {
+	Append(dst []byte) []byte
+}
+
+var (
+	_ = Appender((*Bool)(nil))
+	_ = Appender((*Int)(nil))
+	_ = Appender((*Float)(nil))
+	_ = Appender((*String)(nil))
+	_ = Appender(RawBool(false))
+	_ = Appender(RawInt(0))
+	_ = Appender(RawUint(0))
+	_ = Appender(RawFloat(0))
+	_ = Appender(RawString(""))
+	_ = Appender(fixedString(nil))
+)
+
+// measurementAppender is implemented by measurements that can append themselves with a set of default tags merged into
+// their own. defaultNames must hold the names of defaults in ascending order. If an error occurs, the returned slice
+// may hold a partially-appended measurement.
+type measurementAppender interface {
+	appendTagged(dst []byte, defaults Tags, defaultNames []string) ([]byte, error)
+}
+
+var (
+	_ = measurementAppender((*Point)(nil))
+	_ = measurementAppender(compiledPoint{})
+	_ = measurementAppender((*CompiledPoint)(nil))
+	_ = measurementAppender((*PointSet)(nil))
+)
+
+// AppendMeasurement appends the line protocol encoding of m to dst and returns the extended slice. It writes the same
+// output as WriteMeasurement. MeasurementSets have all of their measurements with fields appended. If m has no fields
+// or an error occurs encoding one of its fields, dst is returned unchanged.
+//
+// Points, compiled points, and raw points made of dagr's field types are appended without allocating, aside from
+// growing dst. Tags and fields of other measurements are sorted in scratch space, which only allocates for
+// measurements with a large number of tags or fields.
+func AppendMeasurement(dst []byte, m Measurement) []byte {
+	b, err := appendMeasurement(dst, m, nil, nil)
+	if err != nil {
+		return dst
+	}
+	return b
+}
+
+// appendMeasurement appends m to dst with the tags in defaults merged into its own. defaultNames must hold the names of
+// defaults in ascending order. If m has no fields, it returns ErrNoFields. If an error occurs, the returned slice may
+// hold a partially-appended measurement.
+func appendMeasurement(dst []byte, m Measurement, defaults Tags, defaultNames []string) ([]byte, error) {
+	switch m := m.(type) {
+	case measurementAppender:
+		return m.appendTagged(dst, defaults, defaultNames)
+	case MeasurementSet:
+		for _, m := range m.Measurements() {
+			b, err := appendMeasurement(dst, m, defaults, defaultNames)
+			if err == ErrNoFields {
+				continue
+			} else if err != nil {
+				return b, err
+			}
+			dst = b
+		}
+		return dst, nil
+	case io.WriterTo:
+		// Unknown measurements that write themselves are written as-is, without default tags.
+		w := appendWriter(dst)
+		_, err := m.WriteTo(&w)
+		return w, err
+	}
+
+	when := clock.Now()
+	if tm, ok := m.(TimeMeasurement); ok {
+		when = tm.GetTime()
+	}
+
+	tags := m.GetTags()
+	fields := m.GetFields()
+	if len(fields) == 0 {
+		return dst, ErrNoFields
+	}
+
+	var scratch [16]string
+
+	dst = appendEscaped(dst, m.GetKey())
+	if len(tags) > 0 || len(defaults) > 0 {
+		names := scratch[:0]
+		for name := range tags {
+			names = append(names, name)
+		}
+		sortNames(names)
+		dst = appendMergedTags(dst, tags, names, defaults, defaultNames)
+	}
+
+	names := scratch[:0]
+	for name := range fields {
+		names = append(names, name)
+	}
+	sortNames(names)
+
+	dst = append(dst, ' ')
+	dst, err := appendFields(dst, fields, names)
+	if err != nil {
+		return dst, err
+	}
+
+	dst = append(dst, ' ')
+	dst = appendTimestamp(dst, when)
+	return append(dst, '\n'), nil
+}
+
+// sortNames sorts names in ascending order. Small slices are sorted in place so that they don't escape to the heap.
+func sortNames(names []string) {
+	if len(names) > 12 {
+		sorted := append([]string(nil), names...)
+		sort.Strings(sorted)
+		copy(names, sorted)
+		return
+	}
+
+	for i := 1; i < len(names); i++ {
+		for j := i; j > 0 && names[j] < names[j-1]; j-- {
+			names[j], names[j-1] = names[j-1], names[j]
+		}
+	}
+}
+
+// appendWriter is an io.Writer that appends to a byte slice.
+type appendWriter []byte
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	*w = append(*w, p...)
+	return len(p), nil
+}
+
+func (w *appendWriter) WriteString(s string) (int, error) {
+	*w = append(*w, s...)
+	return len(s), nil
+}
+
+// appendField appends a single field's value to dst.
+func appendField(dst []byte, f Field) ([]byte, error) {
+	if a, ok := f.(Appender); ok {
+		return a.Append(dst), nil
+	}
+
+	w := appendWriter(dst)
+	_, err := f.WriteTo(&w)
+	return w, err
+}
+
+// appendFields appends the fields in names to dst, separated by commas. If names is empty, it returns ErrNoFields.
+func appendFields(dst []byte, fields Fields, names []string) ([]byte, error) {
+	if len(names) == 0 {
+		return dst, ErrNoFields
+	}
+
+	var err error
+	for i, name := range names {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendEscaped(dst, name)
+		dst = append(dst, '=')
+		if dst, err = appendField(dst, fields[name]); err != nil {
+			return dst, err
+		}
+	}
+
+	return dst, nil
+}
+
+// appendTags appends the tags in names to dst. Each tag is preceded by a comma, since tags always follow a key.
+func appendTags(dst []byte, tags Tags, names []string) []byte {
+	for _, name := range names {
+		dst = append(dst, ',')
+		dst = appendEscaped(dst, name)
+		dst = append(dst, '=')
+		dst = appendEscaped(dst, tags[name])
+	}
+	return dst
+}
+
+// appendMergedTags appends the tags in names, along with any tags in defaults that aren't in tags, in ascending order by
+// name. Both names and defaultNames must be sorted in ascending order.
+func appendMergedTags(dst []byte, tags Tags, names []string, defaults Tags, defaultNames []string) []byte {
+	if len(defaultNames) == 0 {
+		return appendTags(dst, tags, names)
+	}
+
+	for len(names) > 0 || len(defaultNames) > 0 {
+		var name, tag string
+		if len(defaultNames) == 0 || (len(names) > 0 && names[0] <= defaultNames[0]) {
+			if len(defaultNames) > 0 && names[0] == defaultNames[0] {
+				defaultNames = defaultNames[1:]
+			}
+			name, tag, names = names[0], tags[names[0]], names[1:]
+		} else {
+			name, tag, defaultNames = defaultNames[0], defaults[defaultNames[0]], defaultNames[1:]
+		}
+
+		dst = append(dst, ',')
+		dst = appendEscaped(dst, name)
+		dst = append(dst, '=')
+		dst = appendEscaped(dst, tag)
+	}
+	return dst
+}
+
+// sortedTagNames returns the names of tags in ascending order.
+func sortedTagNames(tags Tags) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// appendEscaped appends s to dst with spaces, equal signs, and commas escaped by a backslash. This is used for keys,
+// tag names and values, and field names.
+func appendEscaped(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ' ', '=', ',':
+			dst = append(dst, '\\', c)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// appendQuoted appends s to dst as a line protocol string, surrounded by double quotes and with double quotes escaped
+// by a backslash. Backslashes are written as-is.
+func appendQuoted(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			dst = append(dst, '\\', c)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, '"')
+}
+
+func appendTimestamp(dst []byte, ts time.Time) []byte {
+	return strconv.AppendInt(dst, ts.UnixNano(), 10)
+}
+
+// Scratch buffers are used to append measurements before writing them, so that writes to an io.Writer happen all at
+// once.
+
+const (
+	minBufferCapacity = 128
+	maxBufferCapacity = 65000
+)
+
+var scratchBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, minBufferCapacity)
+		return &b
+	},
+}
+
+func getScratch() *[]byte {
+	return scratchBuffers.Get().(*[]byte)
+}
+
+// putScratch returns a scratch buffer to the pool. b should be the last slice appended to the buffer, so that the
+// buffer's capacity is retained if it grew.
+func putScratch(bp *[]byte, b []byte) {
+	if cap(b) > maxBufferCapacity {
+		return
+	}
+	*bp = b[:0]
+	scratchBuffers.Put(bp)
+}
+
+// writeScratch writes b to w, unless err is not nil. It's used to finish writing a measurement appended to a scratch
+// buffer.
+func writeScratch(w io.Writer, b []byte, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	} else if len(b) == 0 {
+		return 0, nil
+	}
+
+	n, err := w.Write(b)
+	return int64(n), err
+}
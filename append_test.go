@@ -0,0 +1,175 @@
+package dagr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func newAppendTestPoint() *Point {
+	integer := new(Int)
+	boolean := new(Bool)
+	float := new(Float)
+	str := new(String)
+
+	integer.Set(123)
+	boolean.Set(true)
+	float.Set(123.456)
+	str.Set(`a "string" of sorts`)
+
+	return NewPoint(
+		"service.some_event",
+		Tags{"pid": fmt.Sprint(1234), "host": "example.local"},
+		Fields{"value": integer, "depth": float, "on": boolean, "msg": str},
+	)
+}
+
+func TestAppendMeasurement(t *testing.T) {
+	const result = `service.some_event,host=example.local,pid=1234 depth=123.456,msg="a \"string\" of sorts",on=T,value=123i 1136214245000000000` + "\n"
+
+	defer prepareLogger(t)()
+
+	p := newAppendTestPoint()
+	raw := RawPoint{Key: p.GetKey(), Tags: p.GetTags(), Fields: p.GetFields()}
+	cases := map[string]Measurement{
+		"Point":    p,
+		"Compiled": p.Compiled(),
+		"Linked":   p.Linked(),
+		"RawPoint": raw,
+	}
+
+	for name, m := range cases {
+		prefix := []byte("prefix\n")
+		got := AppendMeasurement(prefix, m)
+		if want := "prefix\n" + result; string(got) != want {
+			t.Errorf("%s: AppendMeasurement() =\n%s\nwant\n%s", name, got, want)
+		}
+	}
+
+	empty := NewPoint("empty", nil, nil)
+	if got := AppendMeasurement([]byte("prefix"), empty); string(got) != "prefix" {
+		t.Errorf("AppendMeasurement(empty) = %q; want %q", got, "prefix")
+	}
+}
+
+func TestAppendMeasurementSetDefaults(t *testing.T) {
+	const result = `service.some_event,env=prod,host=example.local,pid=1234 value=123i 1136214245000000000` + "\n"
+
+	defer prepareLogger(t)()
+
+	integer := new(Int)
+	integer.Set(123)
+
+	// Registries also implement io.WriterTo, but should still be expanded so their measurements get default tags.
+	registry := new(Registry)
+	registry.Register("point", NewPoint(
+		"service.some_event",
+		Tags{"pid": "1234", "host": "example.local"},
+		Fields{"value": integer},
+	))
+
+	defaults := Tags{"env": "prod"}
+	got, err := appendMeasurement(nil, registry, defaults, sortedTagNames(defaults))
+	if err != nil {
+		t.Fatalf("appendMeasurement() error: %v", err)
+	} else if string(got) != result {
+		t.Errorf("appendMeasurement() =\n%s\nwant\n%s", got, result)
+	}
+}
+
+func TestAppendMeasurementEscaping(t *testing.T) {
+	defer prepareLogger(t)()
+
+	str := new(String)
+	str.Set(`C:\dir\ "quoted"`)
+
+	want := RawPoint{
+		Key:  "key with,comma",
+		Tags: Tags{"tag=name": "tag value"},
+		Fields: Fields{
+			"str": RawString(`C:\dir\ "quoted"`),
+			"raw": RawString(`back\slash"`),
+		},
+		Time: testTime,
+	}
+
+	m := NewPoint(want.Key, want.Tags, Fields{"str": str, "raw": want.Fields["raw"]})
+	line := AppendMeasurement(nil, m)
+
+	got, err := ParseLine(line)
+	if err != nil {
+		t.Fatalf("ParseLine(%q) error: %v", line, err)
+	}
+
+	if !got.Time.Equal(want.Time) {
+		t.Errorf("ParseLine(%q).Time = %v; want %v", line, got.Time, want.Time)
+	}
+	got.Time = want.Time
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLine(%q) =\n%#v\nwant\n%#v", line, got, want)
+	}
+
+	if v := FieldValue(str); v != `C:\dir\ "quoted"` {
+		t.Errorf("FieldValue(str) = %q; want %q", v, `C:\dir\ "quoted"`)
+	}
+}
+
+func TestAppendMeasurementAllocs(t *testing.T) {
+	defer prepareLogger(t)()
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable with the race detector")
+	}
+
+	p := newAppendTestPoint()
+	cases := map[string]Measurement{
+		"Compiled": p.Compiled(),
+		"Linked":   p.Linked(),
+	}
+
+	for name, m := range cases {
+		buf := make([]byte, 0, 1024)
+		if n := testing.AllocsPerRun(100, func() { AppendMeasurement(buf, m) }); n != 0 {
+			t.Errorf("%s: AppendMeasurement allocs = %v; want 0", name, n)
+		}
+
+		WriteMeasurement(ioutil.Discard, m) // Warm scratch pool
+		if n := testing.AllocsPerRun(100, func() { WriteMeasurement(ioutil.Discard, m) }); n != 0 {
+			t.Errorf("%s: WriteMeasurement allocs = %v; want 0", name, n)
+		}
+	}
+}
+
+func BenchmarkAppendMeasurement(b *testing.B) {
+	defer prepareLogger(b)()
+
+	m := newAppendTestPoint()
+	buf := make([]byte, 0, 1024)
+	b.ReportAllocs()
+	for i := b.N; i > 0; i-- {
+		buf = AppendMeasurement(buf[:0], m)
+	}
+}
+
+func BenchmarkAppendMeasurement_Compiled(b *testing.B) {
+	defer prepareLogger(b)()
+
+	m := newAppendTestPoint().Compiled()
+	buf := make([]byte, 0, 1024)
+	b.ReportAllocs()
+	for i := b.N; i > 0; i-- {
+		buf = AppendMeasurement(buf[:0], m)
+	}
+}
+
+func BenchmarkAppendMeasurement_Linked(b *testing.B) {
+	defer prepareLogger(b)()
+
+	m := newAppendTestPoint().Linked()
+	buf := make([]byte, 0, 1024)
+	b.ReportAllocs()
+	for i := b.N; i > 0; i-- {
+		buf = AppendMeasurement(buf[:0], m)
+	}
+}
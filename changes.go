@@ -0,0 +1,218 @@
+package dagr
+
+import (
+	"bytes"
+	"sync"
+)
+
+// ChangeWriter is a MeasurementWriter that only writes fields whose values changed since they were last written. It
+// remembers the last value written for each field of each series, where a series is a combination of a measurement's
+// key and tags. Fields that haven't changed are left out of the measurements passed on to the underlying writer, and
+// measurements with no changed fields are left out entirely. This works with any measurement, including Points,
+// compiled points, and PointSets, since all of them are written as snapshots of their changed fields.
+//
+// Intervals are counted by calls, not by time: each call to WriteMeasurements (or WriteMeasurement) is one interval.
+// A ChangeWriter therefore expects exactly one call per collection tick, passing every series being tracked (e.g., a
+// Registry or PointSet) in that call. Writing series across several calls per tick makes Heartbeat and Forget count
+// too quickly, and a series left out of a call counts as unseen for that interval. So that gaps in a series can still
+// be detected, every series is written in full once every Heartbeat intervals, regardless of whether it changed.
+//
+// Field values are compared using FieldValue. Fields that FieldValue doesn't understand are always considered
+// changed. Values are only remembered once they've been written without error, so a failed write is retried in full
+// on the next interval.
+//
+// Since an outflux Proxy is a MeasurementWriter, a ChangeWriter can be placed in front of one to reduce how much is
+// sent to InfluxDB:
+//
+//	changes := dagr.NewChangeWriter(proxy, 10)
+//	changes.WriteMeasurements(points...)
+//
+// So that series that go away (e.g., points removed from a PointSet) don't use memory forever, a series that isn't
+// passed to WriteMeasurements for Forget intervals is forgotten, and is written in full if it comes back.
+//
+// A ChangeWriter's Heartbeat and Forget must not be modified once it's in use. It is safe to use a ChangeWriter from
+// concurrent goroutines.
+type ChangeWriter struct {
+	// Heartbeat is the number of intervals after which a series is written in full, even if it hasn't changed. If
+	// Heartbeat is <= 0, unchanged series are never rewritten.
+	Heartbeat int
+
+	// Forget is the number of intervals after which a series that hasn't been seen is forgotten. If Forget is <= 0,
+	// it's the same as Heartbeat, since a series that comes back after that long is written in full anyway. If both
+	// are <= 0, it's DefaultChangeForget.
+	Forget int
+
+	w MeasurementWriter
+
+	m        sync.Mutex // controls all following fields
+	interval int64
+	series   map[string]*changeSeries
+}
+
+var _ = MeasurementWriter((*ChangeWriter)(nil))
+
+// DefaultChangeForget is the number of intervals after which a ChangeWriter forgets a series it hasn't seen, if
+// neither its Forget nor Heartbeat is set.
+const DefaultChangeForget = 100
+
+// changeSeries is the last-written state of a single series.
+type changeSeries struct {
+	fields map[string]interface{}
+	full   int64 // The interval the series was last written in full
+	seen   int64 // The interval the series was last passed to WriteMeasurements
+}
+
+func (c *ChangeWriter) forget() int64 {
+	switch {
+	case c.Forget > 0:
+		return int64(c.Forget)
+	case c.Heartbeat > 0:
+		return int64(c.Heartbeat)
+	}
+	return DefaultChangeForget
+}
+
+// NewChangeWriter allocates a new ChangeWriter that writes changed fields to w and writes every series in full every
+// heartbeat intervals. If w is nil, NewChangeWriter panics.
+func NewChangeWriter(w MeasurementWriter, heartbeat int) *ChangeWriter {
+	if w == nil {
+		panic("dagr: NewChangeWriter: writer is nil")
+	}
+	return &ChangeWriter{Heartbeat: heartbeat, w: w}
+}
+
+// Reset clears everything the ChangeWriter remembers, causing all series to be written in full on the next interval.
+func (c *ChangeWriter) Reset() {
+	c.m.Lock()
+	c.series = nil
+	c.m.Unlock()
+}
+
+// WriteMeasurement writes a single measurement. This is the same as calling WriteMeasurements with a single
+// measurement, and counts as an interval, so it's only suitable when a single measurement (such as a Registry) holds
+// every series written per tick.
+func (c *ChangeWriter) WriteMeasurement(m Measurement) (int64, error) {
+	return c.WriteMeasurements(m)
+}
+
+// WriteMeasurements writes the changed fields of ms to the ChangeWriter's underlying writer. MeasurementSets are
+// expanded into their measurements. If nothing changed, nothing is written and WriteMeasurements returns 0 and nil.
+// Each call counts as one interval, and should be made once per collection tick.
+func (c *ChangeWriter) WriteMeasurements(ms ...Measurement) (int64, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.series == nil {
+		c.series = make(map[string]*changeSeries)
+	}
+	c.interval++
+	defer c.prune()
+
+	type update struct {
+		id     string
+		series *changeSeries
+		values map[string]interface{}
+		full   bool
+	}
+
+	var (
+		now     = clock.Now()
+		out     []Measurement
+		updates []update
+	)
+	for _, m := range Flatten(ms...) {
+		fields := m.GetFields()
+		if len(fields) == 0 {
+			continue
+		}
+
+		tags := m.GetTags()
+		id := seriesID(m.GetKey(), tags)
+		series := c.series[id]
+		if series != nil {
+			series.seen = c.interval
+		}
+		full := series == nil || (c.Heartbeat > 0 && c.interval-series.full >= int64(c.Heartbeat))
+
+		var (
+			changed Fields
+			values  = make(map[string]interface{}, len(fields))
+		)
+		for name, f := range fields {
+			f = snapshotField(f)
+			v := FieldValue(f)
+			if !full {
+				if last, ok := series.fields[name]; ok && v != nil && last == v {
+					continue
+				}
+			}
+
+			if changed == nil {
+				changed = make(Fields, len(fields))
+			}
+			changed[name] = f
+			values[name] = v
+		}
+
+		if len(changed) == 0 {
+			continue
+		}
+
+		when := now
+		if tm, ok := m.(TimeMeasurement); ok {
+			when = tm.GetTime()
+		}
+		out = append(out, RawPoint{Key: m.GetKey(), Tags: tags, Fields: changed, Time: when})
+		updates = append(updates, update{id, series, values, full})
+	}
+
+	if len(out) == 0 {
+		return 0, nil
+	}
+
+	n, err := c.w.WriteMeasurements(out...)
+	if err != nil {
+		return n, err
+	}
+
+	for _, u := range updates {
+		series := u.series
+		if series == nil {
+			series = &changeSeries{fields: u.values, full: c.interval, seen: c.interval}
+			c.series[u.id] = series
+			continue
+		}
+
+		for name, v := range u.values {
+			series.fields[name] = v
+		}
+		if u.full {
+			series.full = c.interval
+		}
+	}
+
+	return n, nil
+}
+
+// prune forgets series that haven't been seen for the ChangeWriter's Forget intervals. The ChangeWriter must be locked.
+func (c *ChangeWriter) prune() {
+	forget := c.forget()
+	for id, series := range c.series {
+		if c.interval-series.seen >= forget {
+			delete(c.series, id)
+		}
+	}
+}
+
+// seriesID returns a string identifying the series of a measurement with the given key and tags.
+func seriesID(key string, tags Tags) string {
+	var buf bytes.Buffer
+	buf.WriteString(key)
+	for _, name := range sortedTagNames(tags) {
+		buf.WriteByte(0)
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(tags[name])
+	}
+	return buf.String()
+}
@@ -0,0 +1,129 @@
+package dagr
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestChangeWriter(t *testing.T) {
+	defer prepareLogger(t)()
+
+	var (
+		buf  bytes.Buffer
+		fail error
+	)
+	cw := NewChangeWriter(WriteMeasurementsFunc(func(ms ...Measurement) (int64, error) {
+		if fail != nil {
+			return 0, fail
+		}
+		return WriteMeasurements(&buf, ms...)
+	}), 3)
+
+	up, status := new(Bool), new(String)
+	up.Set(true)
+	status.Set("ok")
+	point := NewPoint("service", Tags{"host": "example.local"}, Fields{"up": up, "status": status})
+
+	count := new(Int)
+	compiled := NewPoint("requests", nil, Fields{"count": count}).Compiled()
+
+	set := NewPointSet(StaticPointAllocator{
+		Key:           "http_request",
+		IdentifierTag: "path",
+		Fields:        Fields{"count": new(Int)},
+	})
+	set.FieldsForID("/kittens", nil)["count"].(*Int).Set(1)
+	set.FieldsForID("/puppies", nil)["count"].(*Int).Set(1)
+
+	write := func(want string) {
+		t.Helper()
+		buf.Reset()
+		if _, err := cw.WriteMeasurements(point, compiled, set); err != nil {
+			t.Fatalf("WriteMeasurements() = %v", err)
+		}
+		// PointSet order isn't stable, so check for each expected line and the total length.
+		for _, line := range bytes.SplitAfter([]byte(want), []byte("\n")) {
+			if len(line) > 0 && !bytes.Contains(buf.Bytes(), line) {
+				t.Errorf("missing line %q in output:\n%s", line, buf.Bytes())
+			}
+		}
+		if buf.Len() != len(want) {
+			t.Errorf("Expected ---\n%s---\n\nGot ---\n%s---", want, buf.String())
+		}
+	}
+
+	// Interval 1: everything is new.
+	write("service,host=example.local status=\"ok\",up=T 1136214245000000000\n" +
+		"requests count=0i 1136214245000000000\n" +
+		"http_request,path=/kittens count=1i 1136214245000000000\n" +
+		"http_request,path=/puppies count=1i 1136214245000000000\n")
+
+	// Interval 2: only changed fields and points.
+	up.Set(false)
+	set.FieldsForID("/kittens", nil)["count"].(*Int).Add(1)
+	write("service,host=example.local up=F 1136214245000000000\n" +
+		"http_request,path=/kittens count=2i 1136214245000000000\n")
+
+	// Interval 3: nothing changed.
+	write("")
+
+	// Failed writes aren't remembered.
+	count.Add(1)
+	fail = errors.New("failed")
+	if _, err := cw.WriteMeasurements(point, compiled, set); err != fail {
+		t.Fatalf("WriteMeasurements() = %v; want %v", err, fail)
+	}
+	fail = nil
+
+	// Interval 5: heartbeat, so everything is written in full.
+	write("service,host=example.local status=\"ok\",up=F 1136214245000000000\n" +
+		"requests count=1i 1136214245000000000\n" +
+		"http_request,path=/kittens count=2i 1136214245000000000\n" +
+		"http_request,path=/puppies count=1i 1136214245000000000\n")
+
+	// Interval 6: nothing changed again.
+	write("")
+}
+
+func TestChangeWriterForget(t *testing.T) {
+	defer prepareLogger(t)()
+
+	var buf bytes.Buffer
+	cw := NewChangeWriter(WriteMeasurementsFunc(func(ms ...Measurement) (int64, error) {
+		return WriteMeasurements(&buf, ms...)
+	}), 0)
+	cw.Forget = 2
+
+	set := NewPointSet(StaticPointAllocator{
+		Key:           "http_request",
+		IdentifierTag: "path",
+		Fields:        Fields{"count": new(Int)},
+	})
+	set.FieldsForID("/kittens", nil)["count"].(*Int).Set(1)
+	set.FieldsForID("/puppies", nil)["count"].(*Int).Set(1)
+
+	cw.WriteMeasurements(set)
+	if n := len(cw.series); n != 2 {
+		t.Fatalf("len(series) = %d; want 2", n)
+	}
+
+	// A removed point is forgotten after Forget intervals.
+	set.Remove("/puppies")
+	cw.WriteMeasurements(set)
+	if n := len(cw.series); n != 2 {
+		t.Fatalf("len(series) = %d after 1 interval; want 2", n)
+	}
+	cw.WriteMeasurements(set)
+	if n := len(cw.series); n != 1 {
+		t.Fatalf("len(series) = %d after 2 intervals; want 1", n)
+	}
+
+	// A forgotten series is written in full when it comes back.
+	buf.Reset()
+	set.FieldsForID("/puppies", nil)["count"].(*Int).Set(1)
+	cw.WriteMeasurements(set)
+	if want := "http_request,path=/puppies count=1i 1136214245000000000\n"; buf.String() != want {
+		t.Errorf("Expected %q\nGot %q", want, buf.String())
+	}
+}
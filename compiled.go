@@ -2,69 +2,206 @@ package dagr
 
 import (
 	"io"
+	"sync/atomic"
 	"time"
 )
 
 type compiledField struct {
 	from, to int
+	name     string
 	value    Field
 }
 
 type compiledPoint struct {
-	prefix []byte
-	lead   int
-	fields []compiledField
+	seq      *seqlock // The seqlock of the point it was compiled from
+	key      string
+	tags     Tags
+	tagOrder []string
+	prefix   []byte
+	tagEnd   int // The end of the key and tags in prefix
+	lead     int
+	fields   []compiledField
 }
 
 var _ = Measurement(compiledPoint{})
 var _ = SnapshotMeasurement(compiledPoint{})
 
 func (c compiledPoint) WriteTo(w io.Writer) (int64, error) {
-	buf := getBuffer(w)
-	defer putBuffer(buf)
+	bp := getScratch()
+	b, err := c.appendTagged(*bp, nil, nil)
+	defer putScratch(bp, b)
+	return writeScratch(w, b, err)
+}
+
+// appendTagged appends the compiled point to dst. If defaults is not empty, the point's key and tags are re-encoded
+// with the defaults merged into them, while its field names are still appended from the compiled prefix.
+func (c compiledPoint) appendTagged(dst []byte, defaults Tags, defaultOrder []string) ([]byte, error) {
+	if len(c.fields) == 0 {
+		return dst, ErrNoFields
+	}
+
+	if len(defaults) == 0 {
+		dst = append(dst, c.prefix[:c.lead]...)
+	} else {
+		dst = appendEscaped(dst, c.key)
+		dst = appendMergedTags(dst, c.tags, c.tagOrder, defaults, defaultOrder)
+		dst = append(dst, c.prefix[c.tagEnd:c.lead]...)
+	}
+
+	for head := dst; ; {
+		seq := c.seq.begin()
+		b, err := c.appendFields(head)
+		if err != nil {
+			return b, err
+		} else if c.seq.valid(seq) {
+			dst = b
+			break
+		}
+	}
+
+	dst = append(dst, ' ')
+	dst = appendTimestamp(dst, clock.Now())
+	return append(dst, '\n'), nil
+}
 
-	buf.Write(c.prefix[:c.lead])
+// appendFields appends the compiled point's field names and values to dst.
+func (c compiledPoint) appendFields(dst []byte) ([]byte, error) {
+	var err error
 	for _, f := range c.fields {
 		if f.from < f.to {
-			buf.Write(c.prefix[f.from:f.to])
+			dst = append(dst, c.prefix[f.from:f.to]...)
 		}
 
-		if _, err := f.value.WriteTo(buf); err != nil {
-			buf.Truncate(int(buf.head))
-			return 0, err
+		if dst, err = appendField(dst, f.value); err != nil {
+			return dst, err
 		}
 	}
-
-	buf.WriteByte(' ')
-	writeTimestamp(buf, clock.Now())
-	buf.WriteByte('\n')
-
-	return buf.WriteTo(w)
+	return dst, nil
 }
 
-// compiledPoints are strictly for io.WriterTo usage and don't support regular Measurement options
+// compiledPoints are primarily for io.WriterTo usage, but still report the key, tags, and fields they were compiled
+// with so that they can be validated and encoded in other formats.
 
 func (c compiledPoint) GetKey() string {
-	return ""
+	return c.key
 }
 
 func (c compiledPoint) GetFields() Fields {
-	return nil
+	fields := make(Fields, len(c.fields))
+	for _, f := range c.fields {
+		fields[f.name] = f.value
+	}
+	return fields
 }
 
 func (c compiledPoint) GetTags() Tags {
-	return nil
+	return c.tags.Dup()
+}
+
+// Snapshot returns a snapshot of the compiled point's fields along with its key and tags. If the point has no fields,
+// Snapshot returns nil.
+func (c compiledPoint) Snapshot() TimeMeasurement {
+	if len(c.fields) == 0 {
+		return nil
+	}
+	return c.snapshotAt(clock.Now())
 }
 
-type fixedCompiledPoint struct {
+// snapshotAt returns a snapshot of the compiled point with the given time.
+func (c compiledPoint) snapshotAt(when time.Time) timePoint {
+	fields := make(Fields, len(c.fields))
+	for seq := c.seq.begin(); ; seq = c.seq.begin() {
+		for _, f := range c.fields {
+			fields[f.name] = snapshotField(f.value)
+		}
+		if c.seq.valid(seq) {
+			break
+		}
+	}
+	return timePoint{c.key, when, c.tags, fields}
+}
+
+// CompiledPoint is a compiled form of a Point that stays linked to it. It's written using the compiled form of the
+// point, like the result of Point.Compiled, but checks the point's version before each use. If the point's key, tags,
+// or fields have changed since it was last compiled, the point is recompiled first. This keeps the write speed of
+// a compiled point without the risk of writing stale keys or tags.
+//
+// A CompiledPoint is safe for use from concurrent goroutines.
+type CompiledPoint struct {
+	point    *Point
+	compiled atomic.Value // *versionedPoint
+}
+
+// versionedPoint is a compiledPoint and the version of the Point it was compiled from.
+type versionedPoint struct {
+	version uint64
 	compiledPoint
-	when time.Time
 }
 
-func (f fixedCompiledPoint) GetTime() time.Time {
-	return f.when
+var _ = SnapshotMeasurement((*CompiledPoint)(nil))
+var _ = io.WriterTo((*CompiledPoint)(nil))
+
+// Linked returns a CompiledPoint linked to the point. The point is not compiled until the CompiledPoint is first used.
+func (p *Point) Linked() *CompiledPoint {
+	return &CompiledPoint{point: p}
 }
 
-func (c compiledPoint) Snapshot() TimeMeasurement {
-	return fixedCompiledPoint{c, clock.Now()}
+// Point returns the Point the CompiledPoint is linked to.
+func (c *CompiledPoint) Point() *Point {
+	return c.point
+}
+
+// Version returns the version of the Point the CompiledPoint was last compiled from. Until the CompiledPoint is used,
+// this is 0.
+func (c *CompiledPoint) Version() uint64 {
+	if vp, ok := c.compiled.Load().(*versionedPoint); ok {
+		return vp.version
+	}
+	return 0
+}
+
+// current returns the compiled form of the linked point, recompiling it if the point has changed.
+func (c *CompiledPoint) current() *versionedPoint {
+	vp, ok := c.compiled.Load().(*versionedPoint)
+	if ok && vp.version == c.point.Version() {
+		return vp
+	}
+
+	p := c.point
+	p.m.RLock()
+	vp = &versionedPoint{p.Version(), p.compile()}
+	p.m.RUnlock()
+
+	c.compiled.Store(vp)
+	return vp
+}
+
+// WriteTo writes the linked point to w. If the point has no fields, it returns the error ErrNoFields.
+func (c *CompiledPoint) WriteTo(w io.Writer) (int64, error) {
+	return c.current().WriteTo(w)
+}
+
+func (c *CompiledPoint) appendTagged(dst []byte, defaults Tags, defaultOrder []string) ([]byte, error) {
+	return c.current().appendTagged(dst, defaults, defaultOrder)
+}
+
+// GetKey returns the linked point's key.
+func (c *CompiledPoint) GetKey() string {
+	return c.current().GetKey()
+}
+
+// GetTags returns a copy of the linked point's tags.
+func (c *CompiledPoint) GetTags() Tags {
+	return c.current().GetTags()
+}
+
+// GetFields returns the linked point's fields.
+func (c *CompiledPoint) GetFields() Fields {
+	return c.current().GetFields()
+}
+
+// Snapshot returns a snapshot of the linked point's fields along with its key and tags. If the point has no fields,
+// Snapshot returns nil.
+func (c *CompiledPoint) Snapshot() TimeMeasurement {
+	return c.current().Snapshot()
 }
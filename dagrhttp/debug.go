@@ -0,0 +1,296 @@
+package dagrhttp
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.spiff.io/dagr"
+	"go.spiff.io/dagr/outflux"
+)
+
+// DefaultRefresh is how often a DebugHandler's page refreshes itself if its Refresh is 0.
+const DefaultRefresh = 5 * time.Second
+
+// DebugHandler is an http.Handler that renders a Registry's measurements as an HTML page for humans, similar to
+// /debug/vars and /debug/pprof. Every field of every measurement is a row of a table giving its key, tags, value, and
+// type, sorted by key, tags, and field name. The page refreshes itself and can be searched: the q query parameter (or
+// the search box, without reloading) keeps only rows whose key, tags, or field name contain every word of the search.
+// The page also shows the status of any outflux Proxies given to the DebugHandler and links to the line protocol and
+// JSON forms of the measurements, which are served by a Handler when the request has a format query parameter.
+//
+// The page has no external assets, so it works without internet access. A DebugHandler is usually mounted at
+// /debug/dagr:
+//
+//	http.Handle("/debug/dagr", &dagrhttp.DebugHandler{
+//		Proxies: map[string]*outflux.Proxy{"influxdb": proxy},
+//	})
+type DebugHandler struct {
+	// Registry is the Registry whose measurements are shown. If nil, dagr.DefaultRegistry is used.
+	Registry *dagr.Registry
+
+	// Proxies are outflux Proxies whose status is shown, by name.
+	Proxies map[string]*outflux.Proxy
+
+	// Refresh is how often the page refreshes itself. If Refresh is 0, it's DefaultRefresh. If Refresh is < 0, the
+	// page doesn't refresh.
+	Refresh time.Duration
+}
+
+var _ = http.Handler((*DebugHandler)(nil))
+
+type debugRow struct {
+	Key   string
+	Tags  string
+	Field string
+	Value string
+	Type  string
+}
+
+type debugProxy struct {
+	Name string
+	outflux.Status
+}
+
+type debugPage struct {
+	Query   string
+	Refresh int // Seconds
+	Time    time.Time
+	Rows    []debugRow
+	Total   int
+	Proxies []debugProxy
+}
+
+func (h *DebugHandler) registry() *dagr.Registry {
+	if h.Registry == nil {
+		return dagr.DefaultRegistry
+	}
+	return h.Registry
+}
+
+func (h *DebugHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if _, ok := query["format"]; ok {
+		(&Handler{Registry: h.registry()}).ServeHTTP(w, req)
+		return
+	}
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page := debugPage{
+		Query: query.Get("q"),
+		Time:  time.Now(),
+	}
+
+	switch refresh := h.Refresh; {
+	case refresh == 0:
+		page.Refresh = int(DefaultRefresh / time.Second)
+	case refresh > 0:
+		if page.Refresh = int(refresh / time.Second); page.Refresh < 1 {
+			page.Refresh = 1
+		}
+	}
+
+	rows := debugRows(dagr.Flatten(h.registry().Measurements()...))
+	page.Total = len(rows)
+	page.Rows = filterRows(rows, page.Query)
+
+	for name, proxy := range h.Proxies {
+		page.Proxies = append(page.Proxies, debugProxy{name, proxy.Status()})
+	}
+	sort.Slice(page.Proxies, func(i, j int) bool { return page.Proxies[i].Name < page.Proxies[j].Name })
+
+	var buf bytes.Buffer
+	if err := debugTemplate.Execute(&buf, &page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if req.Method != "HEAD" {
+		buf.WriteTo(w)
+	}
+}
+
+// debugRows returns a row for each field of ms, sorted by key, tags, and field name.
+func debugRows(ms []dagr.Measurement) []debugRow {
+	var rows []debugRow
+	for _, m := range ms {
+		snap := dagr.Snapshot(m)
+		if snap == nil {
+			continue
+		}
+
+		tags := snap.GetTags()
+		names := make([]string, 0, len(tags))
+		for name := range tags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			names[i] = name + "=" + tags[name]
+		}
+		tagString := strings.Join(names, ",")
+
+		// Types are taken from the original fields, since snapshots may be of a different type.
+		orig := m.GetFields()
+		for name, f := range snap.GetFields() {
+			typ := f
+			if of, ok := orig[name]; ok {
+				typ = of
+			}
+			rows = append(rows, debugRow{
+				Key:   snap.GetKey(),
+				Tags:  tagString,
+				Field: name,
+				Value: debugValue(f),
+				Type:  fmt.Sprintf("%T", typ),
+			})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := &rows[i], &rows[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		} else if a.Tags != b.Tags {
+			return a.Tags < b.Tags
+		}
+		return a.Field < b.Field
+	})
+	return rows
+}
+
+func debugValue(f dagr.Field) string {
+	switch v := dagr.FieldValue(f).(type) {
+	case nil:
+		return fmt.Sprint(f)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// filterRows returns the rows whose key, tags, or field name contain every word of q. rows is modified in place.
+func filterRows(rows []debugRow, q string) []debugRow {
+	words := strings.Fields(strings.ToLower(q))
+	if len(words) == 0 {
+		return rows
+	}
+
+	matched := rows[:0]
+	for _, row := range rows {
+		text := strings.ToLower(row.Key + " " + row.Tags + " " + row.Field)
+		ok := true
+		for _, word := range words {
+			if ok = strings.Contains(text, word); !ok {
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, row)
+		}
+	}
+	return matched
+}
+
+var debugTemplate = template.Must(template.New("debug").Funcs(template.FuncMap{
+	"since": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return time.Since(t).Round(time.Millisecond).String() + " ago"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{- if .Refresh}}
+<meta http-equiv="refresh" content="{{.Refresh}}">
+{{- end}}
+<title>dagr</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 1em 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { text-align: left; padding: 2px 10px; border-bottom: 1px solid #ddd; }
+th { background: #eee; }
+td.value { font-family: monospace; text-align: right; }
+td.type { color: #777; }
+tr.hidden { display: none; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>dagr</h1>
+<p>
+{{.Time.Format "2006-01-02 15:04:05 MST"}}
+{{- if .Refresh}} &middot; refreshes every {{.Refresh}}s{{end}}
+&middot; <a href="?format=line">line protocol</a>
+&middot; <a href="?format=json">JSON</a>
+</p>
+{{- if .Proxies}}
+<h2>Proxies</h2>
+<table>
+<tr><th>Name</th><th>URL</th><th>Buffered</th><th>Sending</th><th>Sent</th><th>Sent bytes</th><th>Failed</th><th>Last sent</th><th>Last error</th></tr>
+{{- range .Proxies}}
+<tr>
+<td>{{.Name}}</td>
+<td>{{.URL}}</td>
+<td class="value">{{.Buffered}}</td>
+<td class="value">{{.Sending}}</td>
+<td class="value">{{.Sent}}</td>
+<td class="value">{{.SentBytes}}</td>
+<td class="value">{{.Failed}}</td>
+<td>{{since .LastSent}}</td>
+<td>{{if .LastError}}<span class="error">{{.LastError}}</span> ({{since .LastErrorTime}}){{end}}</td>
+</tr>
+{{- end}}
+</table>
+{{- end}}
+<h2>Measurements</h2>
+<form method="get">
+<input type="search" id="q" name="q" value="{{.Query}}" placeholder="Search keys, tags, and fields" size="40" autofocus>
+<span id="count">{{len .Rows}} of {{.Total}} fields</span>
+</form>
+<table id="measurements">
+<tr><th>Key</th><th>Tags</th><th>Field</th><th>Value</th><th>Type</th></tr>
+{{- range .Rows}}
+<tr><td>{{.Key}}</td><td>{{.Tags}}</td><td>{{.Field}}</td><td class="value">{{.Value}}</td><td class="type">{{.Type}}</td></tr>
+{{- end}}
+</table>
+<script>
+(function() {
+	var q = document.getElementById("q"), count = document.getElementById("count");
+	var rows = document.getElementById("measurements").rows;
+	var total = {{.Total}};
+	function filter() {
+		var words = q.value.toLowerCase().split(/\s+/).filter(Boolean), shown = 0;
+		for (var i = 1; i < rows.length; i++) {
+			var c = rows[i].cells, text = (c[0].textContent + " " + c[1].textContent + " " + c[2].textContent).toLowerCase();
+			var match = words.every(function(w) { return text.indexOf(w) !== -1; });
+			rows[i].className = match ? "" : "hidden";
+			if (match) shown++;
+		}
+		count.textContent = shown + " of " + total + " fields";
+		var url = new URL(window.location.href);
+		if (q.value) { url.searchParams.set("q", q.value); } else { url.searchParams.delete("q"); }
+		history.replaceState(null, "", url);
+	}
+	q.addEventListener("input", filter);
+	q.setSelectionRange(q.value.length, q.value.length);
+})();
+</script>
+</body>
+</html>
+`))
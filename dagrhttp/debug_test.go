@@ -0,0 +1,79 @@
+package dagrhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.spiff.io/dagr"
+	"go.spiff.io/dagr/outflux"
+)
+
+func TestDebugHandler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	proxy := outflux.New(nil, srv.URL)
+	proxy.Start(ctx, 0)
+	proxy.WriteMeasurement(dagr.RawPoint{Key: "event", Fields: dagr.Fields{"value": dagr.RawInt(1)}})
+	if err := proxy.Flush(ctx); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+
+	reg := testRegistry()
+	count := new(dagr.Int)
+	count.Set(42)
+	reg.Register("jobs", dagr.NewPoint("jobs", dagr.Tags{"queue": "<main>"}, dagr.Fields{"count": count}))
+
+	h := &DebugHandler{Registry: reg, Proxies: map[string]*outflux.Proxy{"influxdb": proxy}}
+
+	rec := serve(t, h, "/debug/dagr", "")
+	body := rec.Body.String()
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	for _, want := range []string{
+		`<meta http-equiv="refresh" content="5">`,
+		`<tr><td>jobs</td><td>queue=&lt;main&gt;</td><td>count</td><td class="value">42</td><td class="type">*dagr.Int</td></tr>`,
+		`<tr><td>http_request</td><td>host=example.local,path=/api/v1/kittens</td><td>time_taken</td><td class="value">1.7</td><td class="type">dagr.RawFloat</td></tr>`,
+		`<td>process</td><td>host=other.local</td><td>stage</td><td class="value">listening &#34;now&#34;</td>`,
+		`<td>influxdb</td>`,
+		`<td>` + srv.URL + `</td>`,
+		`<a href="?format=json">JSON</a>`,
+		`5 of 5 fields`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in page:\n%s", want, body)
+		}
+	}
+
+	// Rows are sorted by key.
+	if i, j := strings.Index(body, "<td>http_request</td>"), strings.Index(body, "<td>jobs</td>"); i == -1 || i > j {
+		t.Errorf("rows aren't sorted by key")
+	}
+
+	// Searching keeps rows matching every word.
+	body = serve(t, h, "/debug/dagr?q=HOST+count", "").Body.String()
+	if !strings.Contains(body, "1 of 5 fields") || strings.Contains(body, "<td>jobs</td>") ||
+		!strings.Contains(body, `value="HOST count"`) {
+		t.Errorf("unexpected search results:\n%s", body)
+	}
+
+	// Raw views are served by Handler.
+	rec = serve(t, h, "/debug/dagr?format=line&prefix=jobs", "")
+	if got, want := rec.Body.String(), "jobs,queue=<main> count=42i"; !strings.HasPrefix(got, want) {
+		t.Errorf("line protocol = %q; want prefix %q", got, want)
+	}
+
+	h.Refresh = -1
+	if body := serve(t, h, "/debug/dagr", "").Body.String(); strings.Contains(body, "refresh") {
+		t.Errorf("page refreshes with Refresh < 0")
+	}
+}
@@ -0,0 +1,227 @@
+// Package dagrhttp provides HTTP handlers for inspecting and scraping dagr measurements.
+package dagrhttp // import "go.spiff.io/dagr/dagrhttp"
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.spiff.io/dagr"
+)
+
+// Formats understood by Handler. These are the values accepted by the format query parameter.
+const (
+	FormatLine       = "line"
+	FormatJSON       = "json"
+	FormatPrometheus = "prometheus"
+)
+
+var contentTypes = map[string]string{
+	FormatLine:       "text/plain; charset=utf-8",
+	FormatJSON:       "application/json",
+	FormatPrometheus: "text/plain; version=0.0.4; charset=utf-8",
+}
+
+// Handler is an http.Handler that serves the current state of a Registry's measurements. Measurements can be served in
+// three formats:
+//
+//   - line: InfluxDB line protocol, as written by dagr.WriteMeasurements. This is the default format.
+//   - json: A JSON array of objects with Key, Timestamp, Tags, and Fields members, as written by
+//     dagr.WriteMeasurementsJSON. Each field's value is an object giving its Type and Value.
+//   - prometheus: Prometheus text exposition format (version 0.0.4). Each numeric or boolean field is exposed as an
+//     untyped metric named after its measurement key and field name (e.g., a field "count" of "http_request" is
+//     exposed as "http_request_count"), with the measurement's tags as labels. String fields are omitted.
+//
+// The format is chosen by the format query parameter, if given, or the request's Accept header. The Accept header
+// "application/json" selects JSON and "text/plain; version=0.0.4" selects the Prometheus format, so Prometheus can
+// scrape a Handler without any configuration.
+//
+// Measurements may be filtered using the prefix and tag query parameters. If any prefix parameters are given, only
+// measurements whose keys begin with one of the prefixes are served. Each tag parameter has the form "name=value" or
+// "name" and requires that served measurements have that tag with that value, or just that tag, respectively. For
+// example:
+//
+//	/metrics?format=json&prefix=http_&tag=host=example.local
+type Handler struct {
+	// Registry is the Registry whose measurements are served. If nil, dagr.DefaultRegistry is used.
+	Registry *dagr.Registry
+}
+
+var _ = http.Handler((*Handler)(nil))
+
+func (h *Handler) registry() *dagr.Registry {
+	if h.Registry == nil {
+		return dagr.DefaultRegistry
+	}
+	return h.Registry
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = negotiate(req.Header.Get("Accept"))
+	}
+
+	contentType, ok := contentTypes[format]
+	if !ok {
+		http.Error(w, "unsupported format: "+strconv.Quote(format), http.StatusBadRequest)
+		return
+	}
+
+	ms := parseFilter(query).apply(dagr.Flatten(h.registry().Measurements()...))
+
+	var (
+		buf bytes.Buffer
+		err error
+	)
+	switch format {
+	case FormatLine:
+		_, err = dagr.WriteMeasurements(&buf, ms...)
+	case FormatJSON:
+		err = writeJSON(&buf, ms)
+	case FormatPrometheus:
+		writePrometheus(&buf, ms)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if req.Method != "HEAD" {
+		buf.WriteTo(w)
+	}
+}
+
+// negotiate returns the format most preferred by an Accept header. If the header doesn't accept any format in
+// particular, it returns FormatLine.
+func negotiate(accept string) string {
+	var (
+		best  = FormatLine
+		bestQ = -1.0
+	)
+
+	for _, part := range strings.Split(accept, ",") {
+		mediatype, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+
+		var format string
+		switch mediatype {
+		case "application/json":
+			format = FormatJSON
+		case "text/plain":
+			format = FormatLine
+			if params["version"] == "0.0.4" {
+				format = FormatPrometheus
+			}
+		case "text/*", "*/*":
+			format = FormatLine
+		default:
+			continue
+		}
+
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+
+	return best
+}
+
+type tagFilter struct {
+	name, value string
+	any         bool // Match any value
+}
+
+type filter struct {
+	prefixes []string
+	tags     []tagFilter
+}
+
+func parseFilter(query map[string][]string) (f filter) {
+	f.prefixes = query["prefix"]
+	for _, tag := range query["tag"] {
+		if i := strings.IndexByte(tag, '='); i != -1 {
+			f.tags = append(f.tags, tagFilter{name: tag[:i], value: tag[i+1:]})
+		} else {
+			f.tags = append(f.tags, tagFilter{name: tag, any: true})
+		}
+	}
+	return f
+}
+
+func (f filter) match(m dagr.Measurement) bool {
+	if len(f.prefixes) > 0 {
+		key, matched := m.GetKey(), false
+		for _, prefix := range f.prefixes {
+			if matched = strings.HasPrefix(key, prefix); matched {
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if len(f.tags) == 0 {
+		return true
+	}
+
+	tags := m.GetTags()
+	for _, tf := range f.tags {
+		if value, ok := tags[tf.name]; !ok || (!tf.any && value != tf.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns the measurements in ms that match the filter. ms is modified in place.
+func (f filter) apply(ms []dagr.Measurement) []dagr.Measurement {
+	if len(f.prefixes) == 0 && len(f.tags) == 0 {
+		return ms
+	}
+
+	matched := ms[:0]
+	for _, m := range ms {
+		if f.match(m) {
+			matched = append(matched, m)
+		}
+	}
+	return matched
+}
+
+// writeJSON writes ms to buf as a JSON array of the objects written by dagr.WriteMeasurementsJSON.
+func writeJSON(buf *bytes.Buffer, ms []dagr.Measurement) error {
+	var lines bytes.Buffer
+	if _, err := dagr.WriteMeasurementsJSON(&lines, ms...); err != nil {
+		return err
+	}
+
+	// Each object is on its own line, and JSON strings can't contain newlines, so newlines only separate objects.
+	buf.WriteByte('[')
+	buf.Write(bytes.Replace(bytes.TrimSuffix(lines.Bytes(), []byte("\n")), []byte("\n"), []byte(","), -1))
+	buf.WriteString("]\n")
+	return nil
+}
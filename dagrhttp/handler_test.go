@@ -0,0 +1,164 @@
+package dagrhttp
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.spiff.io/dagr"
+)
+
+var testTime = time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+func testRegistry() *dagr.Registry {
+	r := new(dagr.Registry)
+	r.Register("requests", dagr.RawPoint{
+		Key:    "http_request",
+		Tags:   dagr.Tags{"host": "example.local", "path": "/api/v1/kittens"},
+		Fields: dagr.Fields{"count": dagr.RawInt(2), "time_taken": dagr.RawFloat(1.7)},
+		Time:   testTime,
+	})
+	r.Register("process", dagr.RawPoint{
+		Key:    "process",
+		Tags:   dagr.Tags{"host": "other.local"},
+		Fields: dagr.Fields{"running": dagr.RawBool(true), "stage": dagr.RawString(`listening "now"`)},
+		Time:   testTime,
+	})
+	return r
+}
+
+func serve(t *testing.T, h http.Handler, target, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status = %d; want %d\n%s", target, rec.Code, http.StatusOK, rec.Body.String())
+	}
+	return rec
+}
+
+func TestHandlerLine(t *testing.T) {
+	const required = `process,host=other.local running=T,stage="listening \"now\"" 1136214245000000000` + "\n" +
+		`http_request,host=example.local,path=/api/v1/kittens count=2i,time_taken=1.7 1136214245000000000` + "\n"
+
+	rec := serve(t, &Handler{Registry: testRegistry()}, "/", "")
+	if got := rec.Body.String(); got != required {
+		t.Errorf("Expected ---\n%s\n---\n\nGot ---\n%s\n---", required, got)
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != contentTypes[FormatLine] {
+		t.Errorf("Content-Type = %q; want %q", ct, contentTypes[FormatLine])
+	}
+}
+
+func TestHandlerJSON(t *testing.T) {
+	h := &Handler{Registry: testRegistry()}
+	for _, target := range []string{"/?format=json", "/?prefix=http_"} {
+		rec := serve(t, h, target, "text/html, application/json;q=0.9, */*;q=0.1")
+
+		var got []map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("GET %s: %v\n%s", target, err, rec.Body.String())
+		}
+
+		want := 2
+		if strings.Contains(target, "prefix") {
+			want = 1
+		}
+
+		if len(got) != want {
+			t.Fatalf("GET %s: got %d measurements; want %d", target, len(got), want)
+		}
+
+		last := got[len(got)-1]
+		if last["Key"] != "http_request" || last["Timestamp"] != "1136214245000000000" {
+			t.Errorf("GET %s: got %v", target, last)
+		}
+
+		fields, _ := last["Fields"].(map[string]interface{})
+		count, _ := fields["count"].(map[string]interface{})
+		taken, _ := fields["time_taken"].(map[string]interface{})
+		if count["Type"] != "int" || count["Value"] != 2.0 || taken["Type"] != "float" || taken["Value"] != 1.7 {
+			t.Errorf("GET %s: Fields = %v", target, fields)
+		}
+	}
+}
+
+func TestHandlerJSONNaN(t *testing.T) {
+	r := new(dagr.Registry)
+	r.Register("nan", dagr.RawPoint{Key: "nan", Fields: dagr.Fields{"value": dagr.RawFloat(math.NaN())}, Time: testTime})
+
+	rec := serve(t, &Handler{Registry: r}, "/?format=json", "")
+	want := `[{"Key":"nan","Timestamp":"1136214245000000000","Tags":{},"Fields":{"value":{"Type":"float","Value":"NaN"}}}]` + "\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("Expected ---\n%s---\n\nGot ---\n%s---", want, got)
+	}
+}
+
+func TestHandlerPrometheus(t *testing.T) {
+	const required = "# TYPE http_request_count untyped\n" +
+		`http_request_count{host="example.local",path="/api/v1/kittens"} 2` + "\n" +
+		"# TYPE http_request_time_taken untyped\n" +
+		`http_request_time_taken{host="example.local",path="/api/v1/kittens"} 1.7` + "\n"
+
+	h := &Handler{Registry: testRegistry()}
+	rec := serve(t, h, "/?tag=path&tag=host=example.local", "application/openmetrics-text; version=0.0.1,text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+	if got := rec.Body.String(); got != required {
+		t.Errorf("Expected ---\n%s\n---\n\nGot ---\n%s\n---", required, got)
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != contentTypes[FormatPrometheus] {
+		t.Errorf("Content-Type = %q; want %q", ct, contentTypes[FormatPrometheus])
+	}
+
+	const bools = "# TYPE process_running untyped\n" +
+		`process_running{host="other.local"} 1` + "\n"
+	rec = serve(t, h, "/?format=prometheus&tag=host=other.local", "")
+	if got := rec.Body.String(); got != bools {
+		t.Errorf("Expected ---\n%s\n---\n\nGot ---\n%s\n---", bools, got)
+	}
+}
+
+func TestHandlerBadFormat(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&Handler{Registry: testRegistry()}).ServeHTTP(rec, httptest.NewRequest("GET", "/?format=xml", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d; want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandlerPrometheusSorted(t *testing.T) {
+	const required = "# TYPE http_request_count untyped\n" +
+		`http_request_count{path="/a"} 1` + "\n" +
+		`http_request_count{path="/b"} 1` + "\n" +
+		`http_request_count{path="/c"} 1` + "\n" +
+		`http_request_count{path="/d"} 1` + "\n"
+
+	set := dagr.NewPointSet(dagr.StaticPointAllocator{
+		Key:           "http_request",
+		IdentifierTag: "path",
+		Fields:        dagr.Fields{"count": new(dagr.Int)},
+	})
+	for _, path := range []string{"/d", "/b", "/a", "/c"} {
+		set.FieldsForID(path, nil)["count"].(*dagr.Int).Add(1)
+	}
+
+	r := new(dagr.Registry)
+	r.Register("requests", set)
+
+	// PointSets are unordered, so write enough times that unsorted output would show.
+	for i := 0; i < 10; i++ {
+		rec := serve(t, &Handler{Registry: r}, "/?format=prometheus", "")
+		if got := rec.Body.String(); got != required {
+			t.Fatalf("Expected ---\n%s\n---\n\nGot ---\n%s\n---", required, got)
+		}
+	}
+}
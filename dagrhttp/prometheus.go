@@ -0,0 +1,133 @@
+package dagrhttp
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.spiff.io/dagr"
+)
+
+type promSample struct {
+	labels string
+	value  float64
+}
+
+// writePrometheus writes ms to buf in the Prometheus text exposition format. Samples are grouped by metric name, since
+// each metric may only be described once, and metrics are written in ascending order by name. Samples of a metric are
+// written in ascending order by label set, so that output doesn't change between scrapes because of the order of a
+// PointSet's points.
+func writePrometheus(buf *bytes.Buffer, ms []dagr.Measurement) {
+	families := make(map[string][]promSample)
+	for _, m := range ms {
+		key := m.GetKey()
+		labels := promLabels(m.GetTags())
+		for name, field := range m.GetFields() {
+			value, ok := promValue(field)
+			if !ok {
+				continue
+			}
+
+			metric := promName(key + "_" + name)
+			families[metric] = append(families[metric], promSample{labels, value})
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		buf.WriteString("# TYPE ")
+		buf.WriteString(name)
+		buf.WriteString(" untyped\n")
+
+		samples := families[name]
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].labels < samples[j].labels })
+		for _, sample := range samples {
+			buf.WriteString(name)
+			buf.WriteString(sample.labels)
+			buf.WriteByte(' ')
+			buf.WriteString(promFloat(sample.value))
+			buf.WriteByte('\n')
+		}
+	}
+}
+
+// promValue returns the value of a field as a float64. Booleans are 1 for true and 0 for false. Strings and unknown
+// field types have no value.
+func promValue(f dagr.Field) (float64, bool) {
+	switch v := dagr.FieldValue(f).(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func promFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// promName converts name to a valid Prometheus metric or label name by replacing invalid characters with underscores.
+func promName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels returns the label set for tags, including braces. If tags is empty, it returns an empty string.
+func promLabels(tags dagr.Tags) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(strings.Replace(promName(name), ":", "_", -1))
+		buf.WriteString(`="`)
+		buf.WriteString(promEscaper.Replace(tags[name]))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
@@ -0,0 +1,205 @@
+package dagr
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Encoder writes measurements in line protocol format with optional behavior not provided by WriteMeasurement and
+// WriteMeasurements. The zero value of an Encoder writes measurements exactly as WriteMeasurement and
+// WriteMeasurements would. An Encoder must not be modified while in use.
+type Encoder struct {
+	// DefaultTags are tags written with every measurement. If a measurement has a tag with the same name as one of
+	// the DefaultTags, the measurement's tag is written instead. DefaultTags are merged into Points, RawPoints,
+	// compiled points, and the points of a PointSet as they're written, so none of them need to be recompiled or
+	// modified. Other measurements implementing io.WriterTo are written as-is, without default tags.
+	DefaultTags Tags
+
+	// Validate, if true, causes each measurement to be checked before it's written. MeasurementSets are expanded so
+	// that each of their measurements is checked individually. Measurements that fail validation are not written.
+	// DefaultTags are checked along with each measurement's own tags. See Validate for the rules measurements are
+	// checked against.
+	Validate bool
+
+	// MaxLineLength is the maximum length of a line when Validate is true. If MaxLineLength is <= 0,
+	// DefaultMaxLineLength is used.
+	MaxLineLength int
+
+	// Invalid, if not nil, is called with each measurement that fails validation. Invalid measurements are then
+	// skipped and the remaining measurements are written. If Invalid is nil, an invalid measurement causes nothing
+	// to be written and its *ValidationError is returned.
+	Invalid func(Measurement, *ValidationError)
+}
+
+// plain returns whether the Encoder would write measurements the same as WriteMeasurement(s).
+func (e *Encoder) plain() bool {
+	return e == nil || (!e.Validate && len(e.DefaultTags) == 0)
+}
+
+func (e *Encoder) maxLineLength() int {
+	if e.MaxLineLength <= 0 {
+		return DefaultMaxLineLength
+	}
+	return e.MaxLineLength
+}
+
+// WriteMeasurement writes a single measurement, m, to w. If the Encoder has no default tags and is not validating
+// measurements, this is the same as WriteMeasurement. If m is a MeasurementSet, its measurements without fields are
+// silently ignored, as with WriteMeasurements.
+func (e *Encoder) WriteMeasurement(w io.Writer, m Measurement) (int64, error) {
+	if e.plain() {
+		return WriteMeasurement(w, m)
+	}
+
+	_, isSet := m.(MeasurementSet)
+	return e.write(w, isSet, m)
+}
+
+// WriteMeasurements writes all measurements with fields to w. If the Encoder has no default tags and is not validating
+// measurements, this is the same as WriteMeasurements.
+func (e *Encoder) WriteMeasurements(w io.Writer, ms ...Measurement) (int64, error) {
+	if e.plain() {
+		return WriteMeasurements(w, ms...)
+	} else if len(ms) == 0 {
+		return 0, nil
+	}
+
+	return e.write(w, true, ms...)
+}
+
+// AppendMeasurement appends m to dst in line protocol with the Encoder's default tags and returns the extended slice.
+// If m is invalid or has no fields, or an error occurs, dst is returned unchanged along with the error. If m is a
+// MeasurementSet, its measurements without fields are silently ignored.
+func (e *Encoder) AppendMeasurement(dst []byte, m Measurement) ([]byte, error) {
+	_, isSet := m.(MeasurementSet)
+	return e.append(dst, isSet, m)
+}
+
+// AppendMeasurements appends all measurements with fields to dst in line protocol with the Encoder's default tags and
+// returns the extended slice. If an error occurs, dst is returned unchanged along with the error.
+func (e *Encoder) AppendMeasurements(dst []byte, ms ...Measurement) ([]byte, error) {
+	return e.append(dst, true, ms...)
+}
+
+// write encodes each of ms to w. If skipEmpty is true, measurements without fields are ignored.
+func (e *Encoder) write(w io.Writer, skipEmpty bool, ms ...Measurement) (int64, error) {
+	bp := getScratch()
+	b, err := e.append(*bp, skipEmpty, ms...)
+	defer putScratch(bp, b)
+	return writeScratch(w, b, err)
+}
+
+// append encodes each of ms to dst. If skipEmpty is true, measurements without fields are ignored. If an error occurs,
+// dst is returned unchanged.
+func (e *Encoder) append(dst []byte, skipEmpty bool, ms ...Measurement) ([]byte, error) {
+	if e.plain() {
+		b := dst
+		for _, m := range ms {
+			next, err := appendMeasurement(b, m, nil, nil)
+			if skipEmpty && err == ErrNoFields {
+				continue
+			} else if err != nil {
+				return dst, err
+			}
+			b = next
+		}
+		return b, nil
+	}
+
+	b := dst
+	defaultNames := sortedTagNames(e.DefaultTags)
+	for _, m := range Flatten(ms...) {
+		next, err := e.encode(b, m, defaultNames)
+		if err == nil {
+			b = next
+			continue
+		} else if skipEmpty && err == ErrNoFields {
+			continue
+		} else if verr, ok := err.(*ValidationError); ok {
+			if skipEmpty && verr.Code == ErrNoFields {
+				continue
+			} else if e.Invalid != nil {
+				e.Invalid(m, verr)
+				continue
+			}
+		}
+
+		return dst, err
+	}
+
+	return b, nil
+}
+
+// encode validates m, if necessary, and appends it to dst with the Encoder's default tags. If m is invalid or an error
+// occurs, dst is returned as it was along with the error.
+func (e *Encoder) encode(dst []byte, m Measurement, defaultNames []string) ([]byte, error) {
+	if e.Validate {
+		if err := validate(m, e.DefaultTags); err != nil {
+			return dst, err
+		}
+	}
+
+	b, err := appendMeasurement(dst, m, e.DefaultTags, defaultNames)
+	if err != nil {
+		return dst, err
+	}
+
+	if n := len(b) - len(dst) - 1; e.Validate && n > e.maxLineLength() {
+		return dst, &ValidationError{Code: ErrLineTooLong, Key: m.GetKey()}
+	}
+
+	return b, nil
+}
+
+// WriteMeasurementsJSON writes all measurements with fields to w as JSON Lines, with the Encoder's default tags merged
+// into each measurement's tags. If Validate is true, measurements are validated the same as for line protocol, except
+// that MaxLineLength does not apply. See WriteMeasurementsJSON for the format written.
+func (e *Encoder) WriteMeasurementsJSON(w io.Writer, ms ...Measurement) (int64, error) {
+	if len(ms) == 0 {
+		return 0, nil
+	}
+
+	var defaults Tags
+	if e != nil {
+		defaults = e.DefaultTags
+	}
+
+	buf := getBuffer(w)
+	defer putBuffer(buf)
+
+	now := clock.Now()
+	enc := json.NewEncoder(buf)
+	for _, m := range Flatten(ms...) {
+		if len(m.GetFields()) == 0 {
+			continue
+		}
+
+		if e != nil && e.Validate {
+			if err := validate(m, defaults); err == nil {
+				// Valid
+			} else if e.Invalid != nil {
+				e.Invalid(m, err)
+				continue
+			} else {
+				buf.Truncate(int(buf.head))
+				return 0, err
+			}
+		}
+
+		jm := newJSONMeasurement(m, now, defaults)
+		if len(jm.Fields) == 0 {
+			continue
+		}
+
+		if err := enc.Encode(jm); err != nil {
+			buf.Truncate(int(buf.head))
+			return 0, err
+		}
+	}
+
+	if buf.Len() == int(buf.head) {
+		return 0, nil
+	}
+
+	return buf.WriteTo(w)
+}
@@ -0,0 +1,65 @@
+package dagr
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncoderDefaultTags(t *testing.T) {
+	const required = `service.some_event,env=prod,host=example.local,pid=1234,version=1.2.3 value=123i 1136214245000000000` + "\n"
+
+	defer prepareLogger(t)()
+
+	integer := new(Int)
+	integer.Set(123)
+
+	point := NewPoint(
+		"service.some_event",
+		Tags{"pid": "1234", "host": "example.local"},
+		Fields{"value": integer},
+	)
+
+	set := NewPointSet(StaticPointAllocator{
+		Key:           "service.some_event",
+		Tags:          Tags{"host": "example.local"},
+		IdentifierTag: "pid",
+		Fields:        Fields{"value": new(Int)},
+	})
+	set.FieldsForID("1234", nil)["value"].(*Int).Set(123)
+
+	enc := Encoder{
+		DefaultTags: Tags{"env": "prod", "host": "default.local", "version": "1.2.3"},
+	}
+
+	ms := map[string]Measurement{
+		"Point":    point,
+		"Compiled": point.Compiled(),
+		"PointSet": set,
+		"RawPoint": RawPoint{
+			Key:    "service.some_event",
+			Tags:   Tags{"pid": "1234", "host": "example.local"},
+			Fields: Fields{"value": RawInt(123)},
+			Time:   testTime,
+		},
+	}
+
+	for name, m := range ms {
+		var buf bytes.Buffer
+		if _, err := enc.WriteMeasurement(&buf, m); err != nil {
+			t.Errorf("%s: WriteMeasurement() = %v", name, err)
+		} else if got := buf.String(); got != required {
+			t.Errorf("%s: Expected %q\nGot %q", name, required, got)
+		}
+	}
+
+	// Ensure none of the measurements were modified by writing them with default tags.
+	var buf bytes.Buffer
+	if _, err := WriteMeasurement(&buf, point.Compiled()); err != nil {
+		t.Fatal(err)
+	}
+
+	const plain = `service.some_event,host=example.local,pid=1234 value=123i 1136214245000000000` + "\n"
+	if got := buf.String(); got != plain {
+		t.Errorf("Expected %q\nGot %q", plain, got)
+	}
+}
@@ -7,6 +7,17 @@ const (
 	ErrNoFields    = Error(1 + iota) // Returned by WriteMeasurement(s) when a measurement has no fields
 	ErrEmptyKey                      // Used to panic when attempting to allocate a point with an empty key
 	ErrNoAllocator                   // Used to panic when attempting to allocate a PointSet with a nil allocator
+
+	// Validation errors -- these are returned as the Code of a *ValidationError by Validate and validating Encoders.
+
+	ErrEmptyFieldName // A field has an empty name
+	ErrReservedField  // A field is named "time"
+	ErrEmptyTagName   // A tag has an empty name
+	ErrReservedTag    // A tag name begins with an underscore
+	ErrEmptyTagValue  // A tag has an empty value
+	ErrLineTooLong    // The encoded measurement exceeds the maximum line length
+
+	ErrDuplicateName // Returned by Registry.Register when a name is already registered
 )
 
 func (e Error) Error() string {
@@ -20,4 +31,13 @@ var errDescs = map[Error]string{
 	ErrNoFields:    "measurement has no fields",
 	ErrEmptyKey:    "NewPoint: key is empty",
 	ErrNoAllocator: "allocator is nil",
+
+	ErrEmptyFieldName: "field name is empty",
+	ErrReservedField:  "field name is reserved",
+	ErrEmptyTagName:   "tag name is empty",
+	ErrReservedTag:    "tag name is reserved",
+	ErrEmptyTagValue:  "tag value is empty",
+	ErrLineTooLong:    "line is too long",
+
+	ErrDuplicateName: "name is already registered",
 }
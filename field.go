@@ -59,6 +59,10 @@ func (b *Bool) WriteTo(w io.Writer) (int64, error) {
 	return RawBool(b.sample()).WriteTo(w)
 }
 
+func (b *Bool) Append(dst []byte) []byte {
+	return RawBool(b.sample()).Append(dst)
+}
+
 func (b *Bool) MarshalJSON() ([]byte, error) {
 	if b.sample() {
 		return []byte{'t', 'r', 'u', 'e'}, nil
@@ -69,7 +73,7 @@ func (b *Bool) MarshalJSON() ([]byte, error) {
 
 func (b *Bool) UnmarshalJSON(js []byte) error {
 	var new bool
-	if err := json.Unmarshal(js, new); err != nil {
+	if err := json.Unmarshal(js, &new); err != nil {
 		return err
 	}
 	b.Set(new)
@@ -116,6 +120,10 @@ func (n *Int) WriteTo(w io.Writer) (int64, error) {
 	return RawInt(n.sample()).WriteTo(w)
 }
 
+func (n *Int) Append(dst []byte) []byte {
+	return RawInt(n.sample()).Append(dst)
+}
+
 func (n *Int) MarshalJSON() ([]byte, error) {
 	return json.Marshal(n.sample())
 }
@@ -167,7 +175,7 @@ func (n *Int) UnmarshalJSON(in []byte) error {
 		n.Set(next)
 	}
 
-	return nil
+	return err
 }
 
 // Float is a Field that stores an InfluxDB float value. When written, it's encoded as a float64 using as few digits as
@@ -219,6 +227,10 @@ func (f *Float) WriteTo(w io.Writer) (int64, error) {
 	return RawFloat(f.sample()).WriteTo(w)
 }
 
+func (f *Float) Append(dst []byte) []byte {
+	return RawFloat(f.sample()).Append(dst)
+}
+
 func (f *Float) MarshalJSON() ([]byte, error) {
 	return json.Marshal(f.sample())
 }
@@ -261,7 +273,6 @@ type String struct {
 
 var (
 	stringUnescaper = strings.NewReplacer(`\"`, `"`)
-	stringEscaper   = strings.NewReplacer(`"`, `\"`)
 
 	_ = Field((*String)(nil))
 	_ = json.Marshaler((*String)(nil))
@@ -274,7 +285,7 @@ func (s *String) Set(new string) {
 	if len(new) > 64000 {
 		new = new[:64000]
 	}
-	s.value.Store([]byte(`"` + stringEscaper.Replace(new) + `"`))
+	s.value.Store(appendQuoted(make([]byte, 0, len(new)+2), new))
 }
 
 func (s *String) sample() []byte {
@@ -303,6 +314,10 @@ func (s *String) WriteTo(w io.Writer) (int64, error) {
 	return int64(n), err
 }
 
+func (s *String) Append(dst []byte) []byte {
+	return append(dst, s.sample()...)
+}
+
 func (s *String) MarshalJSON() ([]byte, error) {
 	b := s.sample()
 	b = b[1 : len(b)-1]
@@ -318,6 +333,34 @@ func (s *String) UnmarshalJSON(in []byte) error {
 	return nil
 }
 
+// FieldValue returns the current value of a field as an int64, uint64, float64, bool, or string. If the field
+// implements SnapshotField, its snapshot is used to get its value. If the field's type is not one of the dagr field
+// types and its snapshot isn't either, FieldValue returns nil.
+func FieldValue(f Field) interface{} {
+	if sf, ok := f.(SnapshotField); ok {
+		f = sf.Snapshot()
+	}
+
+	switch f := f.(type) {
+	case RawInt:
+		return int64(f)
+	case RawUint:
+		return uint64(f)
+	case RawFloat:
+		return float64(f)
+	case RawBool:
+		return bool(f)
+	case RawString:
+		return string(f)
+	case fixedString:
+		if len(f) < 2 {
+			return ""
+		}
+		return stringUnescaper.Replace(string(f[1 : len(f)-1]))
+	}
+	return nil
+}
+
 // Fixed types
 // These are used primarily for snapshotting and as raw types for values that don't change on a point. (E.g., when using
 // RawPoint).
@@ -326,6 +369,7 @@ type (
 	RawBool     bool
 	RawFloat    float64
 	RawInt      int64
+	RawUint     uint64
 	fixedString []byte
 )
 
@@ -339,17 +383,17 @@ func (f RawBool) MarshalJSON() ([]byte, error) {
 	}
 }
 
-func (f RawBool) WriteTo(w io.Writer) (n int64, err error) {
-	var c byte = 'F'
-	if f {
-		c = 'T'
-	}
+func (f RawBool) WriteTo(w io.Writer) (int64, error) {
+	var buf [1]byte
+	n, err := w.Write(f.Append(buf[:0]))
+	return int64(n), err
+}
 
-	err = writeByte(w, c)
-	if err == nil {
-		n = 1
+func (f RawBool) Append(dst []byte) []byte {
+	if f {
+		return append(dst, 'T')
 	}
-	return n, err
+	return append(dst, 'F')
 }
 
 func (f RawInt) Dup() Field { return f }
@@ -359,12 +403,33 @@ func (f RawInt) MarshalJSON() ([]byte, error) {
 }
 
 func (f RawInt) WriteTo(w io.Writer) (int64, error) {
-	var buf [20]byte
-	b := append(strconv.AppendInt(buf[0:0], int64(f), 10), 'i')
-	wn, err := w.Write(b)
+	var buf [21]byte
+	wn, err := w.Write(f.Append(buf[0:0]))
+	return int64(wn), err
+}
+
+func (f RawInt) Append(dst []byte) []byte {
+	return append(strconv.AppendInt(dst, int64(f), 10), 'i')
+}
+
+func (f RawUint) Dup() Field { return f }
+
+func (f RawUint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(uint64(f))
+}
+
+// WriteTo writes the RawUint as an unsigned integer with the 'u' suffix (e.g., "123u" sans quotes). Unsigned integers
+// are only understood by InfluxDB 1.4 and later.
+func (f RawUint) WriteTo(w io.Writer) (int64, error) {
+	var buf [21]byte
+	wn, err := w.Write(f.Append(buf[0:0]))
 	return int64(wn), err
 }
 
+func (f RawUint) Append(dst []byte) []byte {
+	return append(strconv.AppendUint(dst, uint64(f), 10), 'u')
+}
+
 func (f RawFloat) Dup() Field { return f }
 
 func (f RawFloat) MarshalJSON() ([]byte, error) {
@@ -373,11 +438,14 @@ func (f RawFloat) MarshalJSON() ([]byte, error) {
 
 func (f RawFloat) WriteTo(w io.Writer) (int64, error) {
 	var buf [32]byte
-	b := strconv.AppendFloat(buf[0:0], float64(f), 'f', -1, 64)
-	n, err := w.Write(b)
+	n, err := w.Write(f.Append(buf[0:0]))
 	return int64(n), err
 }
 
+func (f RawFloat) Append(dst []byte) []byte {
+	return strconv.AppendFloat(dst, float64(f), 'f', -1, 64)
+}
+
 func (f fixedString) Dup() Field { return f }
 
 func (s fixedString) MarshalJSON() ([]byte, error) {
@@ -388,3 +456,7 @@ func (s fixedString) WriteTo(w io.Writer) (int64, error) {
 	n, err := w.Write([]byte(s))
 	return int64(n), err
 }
+
+func (s fixedString) Append(dst []byte) []byte {
+	return append(dst, s...)
+}
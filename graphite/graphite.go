@@ -0,0 +1,216 @@
+// Package graphite encodes dagr measurements using the Graphite plaintext protocol and sends them to Carbon.
+package graphite // import "go.spiff.io/dagr/graphite"
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.spiff.io/dagr"
+)
+
+// DefaultTemplate is the template used by an Encoder with no Template.
+const DefaultTemplate = "{key}.{field}"
+
+// ErrBadTemplate is returned when encoding with an Encoder whose Template has an unterminated or empty placeholder.
+var ErrBadTemplate = errors.New("graphite: malformed template")
+
+// Encoder writes measurements as Graphite plaintext protocol lines of the form "path value timestamp". Each numeric
+// field of a measurement is written as its own line. Bool fields are written as 1 for true and 0 for false, and String
+// fields are skipped, since Graphite only stores numbers. Timestamps are written in seconds, using the time of
+// a TimeMeasurement or the current time for other measurements.
+//
+// The path of each line is produced by the Encoder's Template. The placeholders {key} and {field} are replaced by the
+// measurement's key and the field's name, and any other placeholder, such as {host}, is replaced by the value of the
+// measurement's tag with that name. Path components left empty by a missing tag are dropped, so the template
+// "{host}.{key}.{field}" produces "requests.count" for a measurement without a host tag. Periods are only preserved in
+// keys: periods and whitespace in tag values and field names are replaced with underscores.
+//
+// If Tagged is true, the measurement's tags are also appended to each path as Graphite 1.1 tags (e.g.,
+// "requests.count;host=example.local;path=/").
+//
+// The zero value of an Encoder uses DefaultTemplate. An Encoder must not be modified while in use.
+type Encoder struct {
+	Template string
+	Tagged   bool
+}
+
+type segment struct {
+	text        string
+	placeholder bool
+}
+
+func parseTemplate(tmpl string) ([]segment, error) {
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+
+	var segs []segment
+	for tmpl != "" {
+		open := strings.IndexByte(tmpl, '{')
+		if open == -1 {
+			segs = append(segs, segment{text: tmpl})
+			break
+		} else if open > 0 {
+			segs = append(segs, segment{text: tmpl[:open]})
+		}
+
+		end := strings.IndexByte(tmpl[open:], '}')
+		if end <= 1 {
+			return nil, ErrBadTemplate
+		}
+		segs = append(segs, segment{text: tmpl[open+1 : open+end], placeholder: true})
+		tmpl = tmpl[open+end+1:]
+	}
+	return segs, nil
+}
+
+var (
+	keyReplacer  = strings.NewReplacer(" ", "_", "\t", "_", "\n", "_", ";", "_")
+	nameReplacer = strings.NewReplacer(".", "_", " ", "_", "\t", "_", "\n", "_", ";", "_")
+	tagReplacer  = strings.NewReplacer(" ", "_", "\t", "_", "\n", "_", ";", "_", "=", "_", "~", "_")
+)
+
+// path expands segs for a single field of a measurement. Empty path components are removed.
+func path(segs []segment, key, field string, tags dagr.Tags) string {
+	var buf bytes.Buffer
+	for _, seg := range segs {
+		switch {
+		case !seg.placeholder:
+			buf.WriteString(seg.text)
+		case seg.text == "key":
+			buf.WriteString(keyReplacer.Replace(key))
+		case seg.text == "field":
+			buf.WriteString(nameReplacer.Replace(field))
+		default:
+			buf.WriteString(nameReplacer.Replace(tags[seg.text]))
+		}
+	}
+
+	parts := strings.Split(buf.String(), ".")
+	kept := parts[:0]
+	for _, part := range parts {
+		if part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, ".")
+}
+
+// value returns the Graphite representation of a field's value. If the field can't be represented, it returns false.
+func value(f dagr.Field) (string, bool) {
+	switch v := dagr.FieldValue(f).(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "", false
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		if v {
+			return "1", true
+		}
+		return "0", true
+	}
+	return "", false
+}
+
+// WriteMeasurement writes a single measurement to w. This is the same as calling WriteMeasurements with a single
+// measurement.
+func (e *Encoder) WriteMeasurement(w io.Writer, m dagr.Measurement) (int64, error) {
+	return e.WriteMeasurements(w, m)
+}
+
+// WriteMeasurements writes all measurements in ms to w in a single write. MeasurementSets, such as a PointSet, are
+// expanded into their measurements. Fields are written in ascending order by name.
+func (e *Encoder) WriteMeasurements(w io.Writer, ms ...dagr.Measurement) (int64, error) {
+	segs, err := parseTemplate(e.Template)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		buf   bytes.Buffer
+		now   = clock.Now()
+		names []string
+	)
+	for _, m := range dagr.Flatten(ms...) {
+		fields := m.GetFields()
+		if len(fields) == 0 {
+			continue
+		}
+
+		when := now
+		if tm, ok := m.(dagr.TimeMeasurement); ok {
+			when = tm.GetTime()
+		}
+		ts := strconv.FormatInt(when.Unix(), 10)
+
+		key, tags := m.GetKey(), m.GetTags()
+		var suffix string
+		if e.Tagged && len(tags) > 0 {
+			suffix = tagSuffix(tags)
+		}
+
+		names = names[:0]
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			v, ok := value(fields[name])
+			if !ok {
+				continue
+			}
+
+			p := path(segs, key, name, tags)
+			if p == "" {
+				continue
+			}
+
+			buf.WriteString(p)
+			buf.WriteString(suffix)
+			buf.WriteByte(' ')
+			buf.WriteString(v)
+			buf.WriteByte(' ')
+			buf.WriteString(ts)
+			buf.WriteByte('\n')
+		}
+	}
+
+	if buf.Len() == 0 {
+		return 0, nil
+	}
+	return buf.WriteTo(w)
+}
+
+// tagSuffix returns tags formatted as Graphite 1.1 tags, in ascending order by name. Empty tags are skipped, since
+// Graphite does not allow empty tag values.
+func tagSuffix(tags dagr.Tags) string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		v := tags[name]
+		if name == "" || v == "" {
+			continue
+		}
+		buf.WriteByte(';')
+		buf.WriteString(tagReplacer.Replace(name))
+		buf.WriteByte('=')
+		buf.WriteString(tagReplacer.Replace(v))
+	}
+	return buf.String()
+}
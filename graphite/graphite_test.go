@@ -0,0 +1,178 @@
+package graphite
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go.spiff.io/dagr"
+)
+
+var testTime = time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+type testClock time.Time
+
+func (t testClock) Now() time.Time { return time.Time(t) }
+
+func testPoint() dagr.RawPoint {
+	return dagr.RawPoint{
+		Key:  "http.request",
+		Tags: dagr.Tags{"host": "example.local", "path": "/api/v1/kittens"},
+		Fields: dagr.Fields{
+			"count":      dagr.RawInt(2),
+			"time_taken": dagr.RawFloat(1.7),
+			"ok":         dagr.RawBool(true),
+			"msg":        dagr.RawString("skipped"),
+		},
+		Time: testTime,
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	cases := []struct {
+		enc  Encoder
+		want string
+	}{
+		{
+			Encoder{},
+			"http.request.count 2 1136214245\n" +
+				"http.request.ok 1 1136214245\n" +
+				"http.request.time_taken 1.7 1136214245\n",
+		},
+		{
+			Encoder{Template: "servers.{host}.{region}.{key}.{field}"},
+			"servers.example_local.http.request.count 2 1136214245\n" +
+				"servers.example_local.http.request.ok 1 1136214245\n" +
+				"servers.example_local.http.request.time_taken 1.7 1136214245\n",
+		},
+		{
+			Encoder{Tagged: true},
+			"http.request.count;host=example.local;path=/api/v1/kittens 2 1136214245\n" +
+				"http.request.ok;host=example.local;path=/api/v1/kittens 1 1136214245\n" +
+				"http.request.time_taken;host=example.local;path=/api/v1/kittens 1.7 1136214245\n",
+		},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if _, err := c.enc.WriteMeasurement(&buf, testPoint()); err != nil {
+			t.Errorf("%+v: WriteMeasurement() = %v", c.enc, err)
+		} else if got := buf.String(); got != c.want {
+			t.Errorf("%+v: Expected ---\n%s\n---\n\nGot ---\n%s\n---", c.enc, c.want, got)
+		}
+	}
+
+	if _, err := (&Encoder{Template: "{key"}).WriteMeasurement(new(bytes.Buffer), testPoint()); err != ErrBadTemplate {
+		t.Errorf("WriteMeasurement() = %v; want %v", err, ErrBadTemplate)
+	}
+}
+
+func TestEncoderClock(t *testing.T) {
+	defer func(c timeSource) { clock = c }(clock)
+	clock = testClock(testTime)
+
+	var buf bytes.Buffer
+	if _, err := (&Encoder{}).WriteMeasurement(&buf, dagr.NewPoint("untimed", nil, dagr.Fields{"value": dagr.RawInt(1)})); err != nil {
+		t.Fatalf("WriteMeasurement() = %v", err)
+	} else if got, want := buf.String(), "untimed.value 1 1136214245\n"; got != want {
+		t.Errorf("WriteMeasurement() = %q; want %q", got, want)
+	}
+}
+
+func TestSinkStartOnDemand(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		lines <- line
+	}()
+
+	// With no interval, the Sink is only flushed on demand and when it's stopped.
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := &Sink{Addr: ln.Addr().String(), Timeout: time.Second}
+	sink.Start(ctx, 0)
+	sink.WriteMeasurement(dagr.RawPoint{Key: "last", Fields: dagr.Fields{"value": dagr.RawInt(1)}, Time: testTime})
+	cancel()
+
+	select {
+	case line := <-lines:
+		if line != "last.value 1 1136214245\n" {
+			t.Errorf("line = %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the final flush")
+	}
+}
+
+func TestSinkReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	lines := make(chan string)
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+
+			go func() {
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					lines <- line
+				}
+			}()
+		}
+	}()
+
+	ctx := context.Background()
+	sink := &Sink{Addr: ln.Addr().String(), Timeout: time.Second}
+
+	sink.WriteMeasurement(dagr.RawPoint{Key: "first", Fields: dagr.Fields{"value": dagr.RawInt(1)}, Time: testTime})
+	if err := sink.Flush(ctx); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+
+	conn := <-accepted
+	if line := <-lines; line != "first.value 1 1136214245\n" {
+		t.Errorf("line = %q", line)
+	}
+
+	// Reset the connection and make sure the next flush reconnects and resends its lines.
+	conn.(*net.TCPConn).SetLinger(0)
+	conn.Close()
+	time.Sleep(50 * time.Millisecond) // Let the reset arrive
+
+	sink.WriteMeasurement(dagr.RawPoint{Key: "second", Fields: dagr.Fields{"value": dagr.RawInt(2)}, Time: testTime})
+	if err := sink.Flush(ctx); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+
+	conn = <-accepted
+	defer conn.Close()
+	if line := <-lines; line != "second.value 2 1136214245\n" {
+		t.Errorf("line = %q", line)
+	}
+}
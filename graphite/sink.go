@@ -0,0 +1,225 @@
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"go.spiff.io/dagr"
+)
+
+// DefaultBackoff returns the default delay before retrying a send, which is one second per retry up to ten seconds.
+func DefaultBackoff(retry, maxRetries int) time.Duration {
+	const max = time.Second * 10
+	if next := time.Duration(retry) * time.Second; next < max {
+		return next
+	}
+	return max
+}
+
+// Sink buffers Graphite plaintext lines and sends them to a Carbon server over TCP. It is used the same way as an
+// outflux Proxy: measurements written to it are buffered until the Sink is flushed, either by calling Flush or by
+// a goroutine created by Start.
+//
+// The Sink keeps a single connection open between sends. If a send fails, the connection is closed and the Sink
+// reconnects to retry the remaining lines, up to Retries times. Lines that can't be sent after all retries are
+// discarded. If a send on a connection kept from an earlier send fails, the connection was most likely closed by the
+// server in the meantime, so the Sink reconnects and retries once right away, without counting it against Retries.
+// Since Carbon never replies, a closed connection is only noticed when writing to it fails: as with any Carbon
+// plaintext client, the first send after the server closes a connection may appear to succeed and its lines be lost.
+//
+// A Sink's exported fields must not be modified once it's in use. It is safe to write to a Sink from concurrent
+// goroutines.
+type Sink struct {
+	// Addr is the host:port address of the Carbon plaintext listener.
+	Addr string
+
+	// Encoder is used to encode measurements written to the Sink.
+	Encoder Encoder
+
+	// Timeout, if > 0, limits the time taken to connect and to send each batch of lines.
+	Timeout time.Duration
+
+	// Retries is the number of times to retry a failed send.
+	Retries int
+
+	// Backoff returns the delay before each retry. If nil, DefaultBackoff is used.
+	Backoff func(retry, maxRetries int) time.Duration
+
+	m   sync.Mutex // controls buf
+	buf bytes.Buffer
+
+	sendlock sync.Mutex // controls conn
+	conn     net.Conn
+
+	startOnce sync.Once
+}
+
+var _ = dagr.MeasurementWriter((*Sink)(nil))
+
+// WriteMeasurements encodes ms and adds them to the Sink's buffer.
+func (s *Sink) WriteMeasurements(ms ...dagr.Measurement) (int64, error) {
+	if len(ms) == 0 {
+		return 0, nil
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.Encoder.WriteMeasurements(&s.buf, ms...)
+}
+
+// WriteMeasurement encodes m and adds it to the Sink's buffer.
+func (s *Sink) WriteMeasurement(m dagr.Measurement) (int64, error) {
+	return s.WriteMeasurements(m)
+}
+
+// Write adds b to the Sink's buffer as-is. b should contain complete lines in the Graphite plaintext format.
+func (s *Sink) Write(b []byte) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.buf.Write(b)
+}
+
+// Start creates a goroutine that flushes the Sink at the given interval. If interval is not a positive duration, the
+// Sink is only flushed when you call Flush, as with an outflux Proxy. When the context is done, the Sink is flushed one
+// last time and its connection is closed. Start only has an effect the first time it's called.
+//
+// The context may not be nil.
+func (s *Sink) Start(ctx context.Context, interval time.Duration) {
+	if ctx == nil {
+		panic("graphite: context is nil")
+	}
+
+	s.startOnce.Do(func() {
+		go s.flushEveryInterval(ctx, interval)
+	})
+}
+
+func (s *Sink) flushEveryInterval(ctx context.Context, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		tick = ticker.C
+		defer ticker.Stop()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Flush on close -- use a different context, though.
+			ctx := context.Background()
+			if s.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+				defer cancel()
+			}
+
+			if err := s.Flush(ctx); err != nil {
+				dagr.Log.Printf("graphite: final flush to %s failed: %v", s.Addr, err)
+			}
+
+			s.sendlock.Lock()
+			s.closeConn()
+			s.sendlock.Unlock()
+			return
+		case <-tick: // Never ready if interval <= 0
+			if err := s.Flush(ctx); err != nil {
+				dagr.Log.Printf("graphite: flush to %s failed: %v", s.Addr, err)
+			}
+		}
+	}
+}
+
+// Flush sends all buffered lines to the Sink's address and returns any error that occurred. Concurrent flushes are
+// sent one at a time, in the order the buffer was taken.
+//
+// The context may not be nil.
+func (s *Sink) Flush(ctx context.Context) error {
+	s.sendlock.Lock()
+	defer s.sendlock.Unlock()
+
+	s.m.Lock()
+	data := append([]byte(nil), s.buf.Bytes()...)
+	s.buf.Reset()
+	s.m.Unlock()
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	return s.send(ctx, data)
+}
+
+// send writes data to the Sink's connection, reconnecting and retrying as needed. The send lock must be held.
+func (s *Sink) send(ctx context.Context, data []byte) error {
+	backoff := s.Backoff
+	if backoff == nil {
+		backoff = DefaultBackoff
+	}
+
+	reconnected := false
+	for retry := 0; ; retry++ {
+		reused := s.conn != nil
+		n, err := s.sendOnce(ctx, data)
+		if err == nil {
+			return nil
+		}
+		s.closeConn()
+
+		// Only resend lines that weren't completely written.
+		if i := bytes.LastIndexByte(data[:n], '\n'); i != -1 {
+			data = data[i+1:]
+		}
+
+		if ctx.Err() != nil {
+			return err
+		} else if reused && !reconnected {
+			// The server probably closed the connection since the last send, so reconnect and retry right away.
+			reconnected = true
+			retry--
+			continue
+		} else if retry >= s.Retries {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff(retry+1, s.Retries)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Sink) sendOnce(ctx context.Context, data []byte) (int, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	if s.conn == nil {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+		if err != nil {
+			return 0, err
+		}
+		s.conn = conn
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := s.conn.SetWriteDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	return s.conn.Write(data)
+}
+
+func (s *Sink) closeConn() {
+	if s.conn == nil {
+		return
+	}
+	s.conn.Close()
+	s.conn = nil
+}
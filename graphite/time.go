@@ -0,0 +1,15 @@
+package graphite
+
+import "time"
+
+// timeSource is here as a test facility, as in dagr, so that measurements without a time of their own are written with
+// a consistent time in tests.
+type timeSource interface {
+	Now() time.Time
+}
+
+type defaultClock struct{}
+
+func (defaultClock) Now() time.Time { return time.Now() }
+
+var clock timeSource = defaultClock{}
@@ -0,0 +1,301 @@
+package dagr
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// History keeps the recent values of a Registry's measurements in memory, so that the last few minutes of each series
+// can be inspected in a live process (such as from a debug endpoint) without querying a time-series database. Each time
+// History records, it takes a Snapshot of every measurement in the Registry and appends it to a fixed-size ring buffer
+// for its series, where a series is a combination of a measurement's key and tags. Measurements may be Points,
+// PointSets, or any other Measurement; MeasurementSets are expanded, and each of their measurements is its own series.
+//
+// A series that isn't seen for as many recordings as the History's size is removed, so that series that go away (e.g.,
+// points expired from a PointSet or measurements unregistered from the Registry) don't keep using memory.
+//
+// It is safe to use a History from concurrent goroutines.
+type History struct {
+	size     int
+	registry *Registry
+
+	m      sync.RWMutex // controls all following fields
+	tick   int64
+	series map[string]*historySeries
+}
+
+var _ = json.Marshaler((*History)(nil))
+
+// historySeries is the ring buffer of a single series.
+type historySeries struct {
+	key    string
+	tags   Tags
+	points []TimeMeasurement // Ring buffer; points[next] is the oldest once full
+	next   int
+	full   bool
+	tick   int64 // The last tick the series was recorded in
+}
+
+func (s *historySeries) add(m TimeMeasurement) {
+	s.points[s.next] = m
+	if s.next++; s.next == len(s.points) {
+		s.next, s.full = 0, true
+	}
+}
+
+// ordered returns the series' points from oldest to newest.
+func (s *historySeries) ordered() []TimeMeasurement {
+	if !s.full {
+		return append([]TimeMeasurement(nil), s.points[:s.next]...)
+	}
+	out := make([]TimeMeasurement, 0, len(s.points))
+	out = append(out, s.points[s.next:]...)
+	return append(out, s.points[:s.next]...)
+}
+
+// NewHistory allocates a new History that keeps up to size points for each series of registry's measurements. If
+// registry is nil, DefaultRegistry is used. If size is <= 0, NewHistory panics.
+func NewHistory(size int, registry *Registry) *History {
+	if size <= 0 {
+		panic("dagr.NewHistory: size must be > 0")
+	}
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return &History{
+		size:     size,
+		registry: registry,
+		series:   make(map[string]*historySeries),
+	}
+}
+
+// Size returns the number of points kept for each series.
+func (h *History) Size() int {
+	return h.size
+}
+
+// Registry returns the Registry whose measurements the History records.
+func (h *History) Registry() *Registry {
+	return h.registry
+}
+
+// Record takes a snapshot of each measurement in the History's Registry and adds it to the history of its series.
+func (h *History) Record() {
+	ms := Flatten(h.registry.Measurements()...)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.tick++
+	for _, m := range ms {
+		snap := Snapshot(m)
+		if snap == nil {
+			continue
+		}
+
+		key, tags := snap.GetKey(), snap.GetTags()
+		id := seriesID(key, tags)
+		s := h.series[id]
+		if s == nil {
+			s = &historySeries{key: key, tags: tags, points: make([]TimeMeasurement, h.size)}
+			h.series[id] = s
+		}
+		s.add(snap)
+		s.tick = h.tick
+	}
+
+	for id, s := range h.series {
+		if h.tick-s.tick >= int64(h.size) {
+			delete(h.series, id)
+		}
+	}
+}
+
+// Start creates a goroutine that records the History at the given interval until the context is done. The interval is
+// timed using the package clock, the same as PointSet.StartExpiring.
+//
+// The context may not be nil.
+func (h *History) Start(ctx context.Context, interval time.Duration) {
+	if ctx == nil {
+		panic("dagr: context is nil")
+	} else if interval <= 0 {
+		panic("dagr: interval must be > 0")
+	}
+
+	ticker := newTicker(interval)
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.Chan():
+				h.Record()
+			}
+		}
+	}()
+}
+
+// HistorySeries identifies a series kept by a History.
+type HistorySeries struct {
+	Key  string
+	Tags Tags
+}
+
+// Series returns all series in the History, sorted by key and tags.
+func (h *History) Series() []HistorySeries {
+	h.m.RLock()
+	defer h.m.RUnlock()
+
+	ids := h.ids()
+	out := make([]HistorySeries, len(ids))
+	for i, id := range ids {
+		s := h.series[id]
+		out[i] = HistorySeries{s.key, s.tags}
+	}
+	return out
+}
+
+// ids returns the History's series IDs in sorted order. The History must be locked.
+func (h *History) ids() []string {
+	ids := make([]string, 0, len(h.series))
+	for id := range h.series {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Points returns all points kept for the series with the given key and tags, from oldest to newest. If there is no
+// such series, it returns nil.
+func (h *History) Points(key string, tags Tags) []TimeMeasurement {
+	h.m.RLock()
+	defer h.m.RUnlock()
+
+	if s := h.series[seriesID(key, tags)]; s != nil {
+		return s.ordered()
+	}
+	return nil
+}
+
+// Last returns up to the last n points of the series with the given key and tags, from oldest to newest.
+func (h *History) Last(key string, tags Tags, n int) []TimeMeasurement {
+	points := h.Points(key, tags)
+	if n < 0 {
+		n = 0
+	}
+	if len(points) > n {
+		points = points[len(points)-n:]
+	}
+	return points
+}
+
+// Range returns the points of the series with the given key and tags whose times are in the range [from, to), from
+// oldest to newest. A zero from or to leaves that end of the range open.
+func (h *History) Range(key string, tags Tags, from, to time.Time) []TimeMeasurement {
+	points := h.Points(key, tags)
+	out := points[:0]
+	for _, m := range points {
+		when := m.GetTime()
+		if (!from.IsZero() && when.Before(from)) || (!to.IsZero() && !when.Before(to)) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// FieldSummary is a summary of the numeric values of a field across a set of points.
+type FieldSummary struct {
+	Count         int // The number of points with a numeric value for the field
+	Min, Max, Avg float64
+}
+
+// SummarizeField returns the minimum, maximum, and average values of the named field across ms, such as the result of
+// History's Last or Range. Points where the field is missing or isn't numeric are skipped. If no points have the field,
+// the summary's Count is 0 and its other values are NaN.
+func SummarizeField(ms []TimeMeasurement, field string) FieldSummary {
+	sum := FieldSummary{Min: math.NaN(), Max: math.NaN(), Avg: math.NaN()}
+	var total float64
+	for _, m := range ms {
+		v, ok := numericValue(m.GetFields()[field])
+		if !ok {
+			continue
+		}
+
+		if sum.Count == 0 || v < sum.Min {
+			sum.Min = v
+		}
+		if sum.Count == 0 || v > sum.Max {
+			sum.Max = v
+		}
+		total += v
+		sum.Count++
+	}
+
+	if sum.Count > 0 {
+		sum.Avg = total / float64(sum.Count)
+	}
+	return sum
+}
+
+// JSON form of a History
+
+type jsonHistory struct {
+	Size   int
+	Series []jsonHistorySeries
+}
+
+type jsonHistorySeries struct {
+	Key    string
+	Tags   jsonFields
+	Points []jsonHistoryPoint
+}
+
+type jsonHistoryPoint struct {
+	Timestamp int64 `json:",string"`
+	Fields    jsonFields
+}
+
+// MarshalJSON returns the History and all of its series as JSON. The result is an object with the following members:
+//
+//	Size   - The number of points kept for each series.
+//	Series - An array of series, sorted by key and tags, each with a Key, Tags, and Points. Points is an array of
+//	         objects, from oldest to newest, each with a Timestamp and Fields, as written by WriteMeasurementsJSON.
+func (h *History) MarshalJSON() ([]byte, error) {
+	h.m.RLock()
+	jh := jsonHistory{Size: h.size, Series: make([]jsonHistorySeries, 0, len(h.series))}
+	for _, id := range h.ids() {
+		s := h.series[id]
+		points := s.ordered()
+		js := jsonHistorySeries{
+			Key:    s.key,
+			Tags:   makeJSONTags(s.tags, sortedTagNames(s.tags)),
+			Points: make([]jsonHistoryPoint, len(points)),
+		}
+		for i, m := range points {
+			jm := newJSONMeasurement(m, m.GetTime(), nil)
+			js.Points[i] = jsonHistoryPoint{jm.Timestamp, jm.Fields}
+		}
+		jh.Series = append(jh.Series, js)
+	}
+	h.m.RUnlock()
+
+	return json.Marshal(jh)
+}
+
+// WriteJSON writes the History's JSON form, as returned by MarshalJSON, to w.
+func (h *History) WriteJSON(w io.Writer) (int64, error) {
+	b, err := h.MarshalJSON()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
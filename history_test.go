@@ -0,0 +1,163 @@
+package dagr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	defer prepareLogger(t)()
+	defer func(c timeSource) { clock = c }(clock)
+
+	reg := new(Registry)
+	h := NewHistory(3, reg)
+
+	depth := new(Int)
+	reg.Register("queue", NewPoint("queue", Tags{"name": "jobs"}, Fields{"depth": depth}))
+
+	set := NewPointSet(StaticPointAllocator{Key: "http", IdentifierTag: "path", Fields: Fields{"count": new(Int)}})
+	set.FieldsForID("/a", nil)["count"].(*Int).Set(1)
+	reg.Register("http", set)
+
+	at := func(i int) time.Time { return testTime.Add(time.Duration(i) * time.Second) }
+	for i, v := range []int64{10, 40, 20, 30} {
+		clock = testClock(at(i))
+		depth.Set(v)
+		h.Record()
+	}
+
+	series := h.Series()
+	if len(series) != 2 || series[0].Key != "http" || series[1].Key != "queue" {
+		t.Fatalf("Series() = %+v; want http and queue", series)
+	}
+
+	tags := Tags{"name": "jobs"}
+	values := func(ms []TimeMeasurement) []int64 {
+		out := make([]int64, len(ms))
+		for i, m := range ms {
+			out[i] = FieldValue(m.GetFields()["depth"]).(int64)
+		}
+		return out
+	}
+	equal := func(name string, got []int64, want ...int64) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Errorf("%s = %v; want %v", name, got, want)
+			return
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s = %v; want %v", name, got, want)
+				return
+			}
+		}
+	}
+
+	// The oldest value was overwritten.
+	equal("Points()", values(h.Points("queue", tags)), 40, 20, 30)
+	equal("Last(2)", values(h.Last("queue", tags, 2)), 20, 30)
+	equal("Last(10)", values(h.Last("queue", tags, 10)), 40, 20, 30)
+	equal("Range()", values(h.Range("queue", tags, at(1), at(3))), 40, 20)
+	equal("Range(open)", values(h.Range("queue", tags, time.Time{}, at(2))), 40)
+	if pts := h.Points("queue", nil); pts != nil {
+		t.Errorf("Points(no tags) = %v; want nil", pts)
+	}
+
+	sum := SummarizeField(h.Points("queue", tags), "depth")
+	if want := (FieldSummary{Count: 3, Min: 20, Max: 40, Avg: 30}); sum != want {
+		t.Errorf("SummarizeField() = %+v; want %+v", sum, want)
+	}
+	if sum := SummarizeField(h.Points("queue", tags), "missing"); sum.Count != 0 || !math.IsNaN(sum.Avg) {
+		t.Errorf("SummarizeField(missing) = %+v; want NaN", sum)
+	}
+
+	var buf bytes.Buffer
+	if _, err := h.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON() = %v", err)
+	}
+	var dump struct {
+		Size   int
+		Series []struct {
+			Key    string
+			Tags   map[string]string
+			Points []struct {
+				Timestamp string
+				Fields    map[string]struct {
+					Type  string
+					Value interface{}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &dump); err != nil {
+		t.Fatalf("invalid JSON %s: %v", buf.Bytes(), err)
+	}
+	if dump.Size != 3 || len(dump.Series) != 2 || dump.Series[1].Tags["name"] != "jobs" ||
+		len(dump.Series[1].Points) != 3 || dump.Series[1].Points[2].Fields["depth"].Value != 30.0 ||
+		dump.Series[1].Points[2].Fields["depth"].Type != "int" ||
+		dump.Series[1].Points[2].Timestamp != "1136214248000000000" {
+		t.Errorf("unexpected JSON: %s", buf.Bytes())
+	}
+
+	// Series that stop being recorded age out.
+	reg.Unregister("http")
+	for i := 0; i < 3; i++ {
+		h.Record()
+	}
+	if series := h.Series(); len(series) != 1 || series[0].Key != "queue" {
+		t.Errorf("Series() = %+v; want only queue", series)
+	}
+}
+
+func TestHistoryStart(t *testing.T) {
+	defer prepareLogger(t)()
+	defer func(c timeSource) { clock = c }(clock)
+
+	mc := &manualClock{now: testTime, c: make(chan time.Time), stopped: make(chan struct{})}
+	clock = mc
+
+	reg := new(Registry)
+	reg.Register("queue", NewPoint("queue", nil, Fields{"depth": RawInt(1)}))
+	h := NewHistory(3, reg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.Start(ctx, time.Second)
+	mc.tick(time.Second)
+	mc.tick(time.Second)
+	cancel()
+	<-mc.stopped
+
+	points := h.Points("queue", nil)
+	if len(points) != 2 {
+		t.Fatalf("len(Points()) = %d; want 2", len(points))
+	}
+	for i, m := range points {
+		if want := testTime.Add(time.Duration(i+1) * time.Second); !m.GetTime().Equal(want) {
+			t.Errorf("Points()[%d] time = %v; want %v", i, m.GetTime(), want)
+		}
+	}
+}
+
+func TestHistoryMarshalJSONNaN(t *testing.T) {
+	defer prepareLogger(t)()
+
+	value := new(Float)
+	value.Set(math.NaN())
+	reg := new(Registry)
+	reg.Register("ratio", NewPoint("ratio", nil, Fields{"value": value}))
+
+	h := NewHistory(2, reg)
+	h.Record()
+
+	b, err := h.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON() error: %v", err)
+	}
+	if !bytes.Contains(b, []byte(`{"Type":"float","Value":"NaN"}`)) {
+		t.Errorf("MarshalJSON() = %s; want a NaN float value", b)
+	}
+}
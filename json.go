@@ -3,8 +3,126 @@ package dagr
 import (
 	"bytes"
 	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"time"
 )
 
+// jsonMeasurement is the JSON form of a measurement, as written by WriteMeasurementsJSON and Point.MarshalJSON. Tags
+// and fields are written in ascending order by name, and each field is written as a jsonValue.
+type jsonMeasurement struct {
+	Key       string
+	Timestamp int64 `json:",string"`
+	Tags      jsonFields
+	Fields    jsonFields
+}
+
+// newJSONMeasurement returns the JSON form of m, with defaults merged into its tags. If m isn't a TimeMeasurement, its
+// timestamp is now.
+func newJSONMeasurement(m Measurement, now time.Time, defaults Tags) jsonMeasurement {
+	if tm, ok := m.(TimeMeasurement); ok {
+		now = tm.GetTime()
+	}
+
+	tags := m.GetTags()
+	if len(defaults) > 0 {
+		merged := defaults.Dup()
+		for name, value := range tags {
+			merged[name] = value
+		}
+		tags = merged
+	}
+
+	fields := m.GetFields()
+	fieldNames := make([]string, 0, len(fields))
+	for name := range fields {
+		fieldNames = append(fieldNames, name)
+	}
+	sort.Strings(fieldNames)
+
+	jm := jsonMeasurement{
+		Key:       m.GetKey(),
+		Timestamp: now.UnixNano(),
+		Tags:      makeJSONTags(tags, sortedTagNames(tags)),
+		Fields:    makeJSONFields(fields, fieldNames),
+	}
+	return jm
+}
+
+// Types of field values in JSON, as written by WriteMeasurementsJSON.
+const (
+	jsonTypeInt    = "int"
+	jsonTypeUint   = "uint"
+	jsonTypeFloat  = "float"
+	jsonTypeBool   = "bool"
+	jsonTypeString = "string"
+	jsonTypeJSON   = "json"
+)
+
+// jsonValue is the JSON form of a field's value along with its type, so that, for example, Int(2) and Float(2) are
+// still distinguishable once encoded.
+type jsonValue struct {
+	Type  string
+	Value interface{}
+}
+
+// newJSONValue returns the typed JSON form of f. Floats that aren't finite are written as the strings "NaN", "+Inf",
+// and "-Inf", since JSON has no numbers for them. Fields of other types are written as they marshal themselves, with
+// the type "json". If f can't be marshaled, it returns false.
+func newJSONValue(f Field) (jsonValue, bool) {
+	switch v := FieldValue(f).(type) {
+	case int64:
+		return jsonValue{jsonTypeInt, v}, true
+	case uint64:
+		return jsonValue{jsonTypeUint, v}, true
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return jsonValue{jsonTypeFloat, "NaN"}, true
+		case math.IsInf(v, 1):
+			return jsonValue{jsonTypeFloat, "+Inf"}, true
+		case math.IsInf(v, -1):
+			return jsonValue{jsonTypeFloat, "-Inf"}, true
+		}
+		return jsonValue{jsonTypeFloat, v}, true
+	case bool:
+		return jsonValue{jsonTypeBool, v}, true
+	case string:
+		return jsonValue{jsonTypeString, v}, true
+	}
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return jsonValue{}, false
+	}
+	return jsonValue{jsonTypeJSON, json.RawMessage(b)}, true
+}
+
+// WriteMeasurementsJSON writes all measurements with fields to w as JSON Lines: one JSON object per measurement, each
+// followed by a newline. MeasurementSets are expanded into their measurements. Each object has the following members:
+//
+//	Key       - The measurement's key.
+//	Timestamp - The measurement's time in nanoseconds since the Unix epoch, as a string. If the measurement
+//	            isn't a TimeMeasurement, the time WriteMeasurementsJSON was called is used.
+//	Tags      - An object of tag names to values. Always present, even if empty.
+//	Fields    - An object of field names to typed values. Each value is an object with two members:
+//	            Type  - One of "int", "uint", "float", "bool", "string", or "json".
+//	            Value - The field's value. Integers, floats, booleans, and strings are written as JSON numbers,
+//	                    booleans, and strings, except that floats that aren't finite are written as the strings
+//	                    "NaN", "+Inf", and "-Inf". Fields of type json are written as they marshal themselves.
+//
+// For example:
+//
+//	{"Key":"http_request","Timestamp":"1136214245000000000","Tags":{"path":"/"},"Fields":{"count":{"Type":"int","Value":2}}}
+//
+// Tags and fields are written in ascending order by name. Fields that can't be marshaled are left out, and measurements
+// without fields are silently ignored. As with WriteMeasurements, the output is buffered and written to w in its
+// entirety.
+func WriteMeasurementsJSON(w io.Writer, ms ...Measurement) (int64, error) {
+	return (*Encoder)(nil).WriteMeasurementsJSON(w, ms...)
+}
+
 type jsonField struct {
 	name  string
 	value interface{}
@@ -12,10 +130,13 @@ type jsonField struct {
 
 type jsonFields []jsonField
 
+// makeJSONFields returns fields in the given order as jsonValues. Fields that can't be marshaled are omitted.
 func makeJSONFields(fields map[string]Field, order []string) jsonFields {
-	fs := make([]jsonField, len(order))
-	for i, name := range order {
-		fs[i] = jsonField{name, fields[name]}
+	fs := make([]jsonField, 0, len(order))
+	for _, name := range order {
+		if value, ok := newJSONValue(fields[name]); ok {
+			fs = append(fs, jsonField{name, value})
+		}
 	}
 	return fs
 }
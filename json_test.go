@@ -1,10 +1,15 @@
 package dagr
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"testing"
+	"time"
 )
 
 func ExamplePoint_MarshalJSON() {
@@ -35,14 +40,26 @@ func ExamplePoint_MarshalJSON() {
 	//   "Key": "service.some_event",
 	//   "Timestamp": "1136214245000000000",
 	//   "Tags": {
-	//     "depth": 123.456,
-	//     "msg": "a \"string\" of sorts",
-	//     "on": true,
-	//     "value": 123
-	//   },
-	//   "Fields": {
 	//     "host": "example.local",
 	//     "pid": "1234"
+	//   },
+	//   "Fields": {
+	//     "depth": {
+	//       "Type": "float",
+	//       "Value": 123.456
+	//     },
+	//     "msg": {
+	//       "Type": "string",
+	//       "Value": "a \"string\" of sorts"
+	//     },
+	//     "on": {
+	//       "Type": "bool",
+	//       "Value": true
+	//     },
+	//     "value": {
+	//       "Type": "int",
+	//       "Value": 123
+	//     }
 	//   }
 	// }
 }
@@ -71,3 +88,118 @@ func BenchmarkJSONMeasurement(b *testing.B) {
 		}
 	}
 }
+
+func TestWriteMeasurementsJSON(t *testing.T) {
+	defer prepareLogger(t)()
+
+	count := new(Int)
+	count.Set(2)
+	point := NewPoint("http_request", Tags{"path": "/kittens"}, Fields{"count": count, "ok": RawBool(true)})
+
+	set := NewPointSet(StaticPointAllocator{
+		Key:           "http_request",
+		IdentifierTag: "path",
+		Fields:        Fields{"count": new(Int)},
+	})
+	set.FieldsForID("/puppies", nil)["count"].(*Int).Set(3)
+
+	raw := RawPoint{
+		Key:    "event",
+		Fields: Fields{"msg": RawString("hello"), "u": RawUint(7), "f": RawFloat(0.5)},
+		Time:   time.Unix(1, 0),
+	}
+
+	var buf bytes.Buffer
+	_, err := WriteMeasurementsJSON(&buf,
+		point,
+		point.Compiled(),
+		set,
+		Snapshot(point),
+		raw,
+		RawPoint{Key: "empty"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		pointLine = `{"Key":"http_request","Timestamp":"1136214245000000000","Tags":{"path":"/kittens"},"Fields":{"count":{"Type":"int","Value":2},"ok":{"Type":"bool","Value":true}}}` + "\n"
+		setLine   = `{"Key":"http_request","Timestamp":"1136214245000000000","Tags":{"path":"/puppies"},"Fields":{"count":{"Type":"int","Value":3}}}` + "\n"
+		rawLine   = `{"Key":"event","Timestamp":"1000000000","Tags":{},"Fields":{"f":{"Type":"float","Value":0.5},"msg":{"Type":"string","Value":"hello"},"u":{"Type":"uint","Value":7}}}` + "\n"
+	)
+	want := pointLine + pointLine + setLine + pointLine + rawLine
+	if got := buf.String(); got != want {
+		t.Errorf("Expected ---\n%s---\n\nGot ---\n%s---", want, got)
+	}
+
+	// Default tags are merged into each measurement's tags.
+	buf.Reset()
+	enc := Encoder{DefaultTags: Tags{"host": "example.local", "path": "/"}}
+	if _, err := enc.WriteMeasurementsJSON(&buf, point); err != nil {
+		t.Fatal(err)
+	}
+	want = `{"Key":"http_request","Timestamp":"1136214245000000000","Tags":{"host":"example.local","path":"/kittens"},"Fields":{"count":{"Type":"int","Value":2},"ok":{"Type":"bool","Value":true}}}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Expected ---\n%s---\n\nGot ---\n%s---", want, got)
+	}
+}
+
+func TestWriteMeasurementsJSONTypes(t *testing.T) {
+	defer prepareLogger(t)()
+
+	nan := new(Float)
+	nan.Set(math.NaN())
+	ms := []Measurement{
+		RawPoint{Key: "int", Fields: Fields{"value": RawInt(2)}, Time: testTime},
+		RawPoint{Key: "float", Fields: Fields{"value": RawFloat(2)}, Time: testTime},
+		RawPoint{Key: "nonfinite", Fields: Fields{"nan": nan, "inf": RawFloat(math.Inf(1)), "-inf": RawFloat(math.Inf(-1))}, Time: testTime},
+		RawPoint{Key: "other", Fields: Fields{"json": namedField{"raw"}, "bad": badJSONField{}}, Time: testTime},
+		RawPoint{Key: "unencodable", Fields: Fields{"bad": badJSONField{}}, Time: testTime},
+	}
+
+	var buf bytes.Buffer
+	if _, err := WriteMeasurementsJSON(&buf, ms...); err != nil {
+		t.Fatalf("WriteMeasurementsJSON() = %v", err)
+	}
+
+	want := `{"Key":"int","Timestamp":"1136214245000000000","Tags":{},"Fields":{"value":{"Type":"int","Value":2}}}` + "\n" +
+		`{"Key":"float","Timestamp":"1136214245000000000","Tags":{},"Fields":{"value":{"Type":"float","Value":2}}}` + "\n" +
+		`{"Key":"nonfinite","Timestamp":"1136214245000000000","Tags":{},"Fields":{` +
+		`"-inf":{"Type":"float","Value":"-Inf"},"inf":{"Type":"float","Value":"+Inf"},"nan":{"Type":"float","Value":"NaN"}}}` + "\n" +
+		`{"Key":"other","Timestamp":"1136214245000000000","Tags":{},"Fields":{"json":{"Type":"json","Value":{"Name":"raw"}}}}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Expected ---\n%s---\n\nGot ---\n%s---", want, got)
+	}
+}
+
+// namedField is a field that FieldValue doesn't understand, and so is marshaled as itself.
+type namedField struct{ Name string }
+
+func (namedField) WriteTo(w io.Writer) (int64, error) { return 0, nil }
+func (f namedField) Dup() Field                       { return f }
+
+// badJSONField is a field that always fails to marshal.
+type badJSONField struct{}
+
+func (badJSONField) WriteTo(w io.Writer) (int64, error) { return 0, nil }
+func (badJSONField) Dup() Field                         { return badJSONField{} }
+func (badJSONField) MarshalJSON() ([]byte, error)       { return nil, errors.New("can't marshal") }
+
+func TestBoolUnmarshalJSON(t *testing.T) {
+	b := new(Bool)
+	if err := json.Unmarshal([]byte("true"), b); err != nil {
+		t.Fatalf("Unmarshal(true) = %v", err)
+	} else if !b.sample() {
+		t.Errorf("Bool = false; want true")
+	}
+
+	if err := json.Unmarshal([]byte("false"), b); err != nil {
+		t.Fatalf("Unmarshal(false) = %v", err)
+	} else if b.sample() {
+		t.Errorf("Bool = true; want false")
+	}
+
+	if err := json.Unmarshal([]byte(`"yes"`), b); err == nil {
+		t.Errorf("Unmarshal(\"yes\") = nil; want error")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -61,6 +62,55 @@ type TimeMeasurement interface {
 	Measurement
 }
 
+// MeasurementSet is a measurement that is a collection of other measurements, such as a PointSet. A MeasurementSet is
+// written by writing each of its measurements, and typically does not have a key, tags, or fields of its own.
+// Functions that need to inspect measurements, such as Validate, use Measurements to expand sets into their members.
+type MeasurementSet interface {
+	Measurements() []Measurement
+
+	Measurement
+}
+
+// MeasurementWriter is anything measurements can be written to, such as an outflux Proxy. Writers that filter or
+// modify measurements, such as ChangeWriter, typically implement MeasurementWriter and wrap another MeasurementWriter.
+type MeasurementWriter interface {
+	WriteMeasurements(ms ...Measurement) (int64, error)
+}
+
+// WriteMeasurementsFunc is a function that implements MeasurementWriter. For example, to write measurements as line
+// protocol to an io.Writer, w:
+//
+//	WriteMeasurementsFunc(func(ms ...Measurement) (int64, error) {
+//		return WriteMeasurements(w, ms...)
+//	})
+type WriteMeasurementsFunc func(ms ...Measurement) (int64, error)
+
+// WriteMeasurements calls fn(ms...).
+func (fn WriteMeasurementsFunc) WriteMeasurements(ms ...Measurement) (int64, error) {
+	return fn(ms...)
+}
+
+// Flatten expands any MeasurementSets in ms into their members and returns the resulting measurements. If ms contains
+// no MeasurementSets, it is returned as-is.
+func Flatten(ms ...Measurement) []Measurement {
+	for i, m := range ms {
+		if _, ok := m.(MeasurementSet); !ok {
+			continue
+		}
+
+		out := append(make([]Measurement, 0, len(ms)), ms[:i]...)
+		for _, m := range ms[i:] {
+			if set, ok := m.(MeasurementSet); ok {
+				out = append(out, Flatten(set.Measurements()...)...)
+			} else {
+				out = append(out, m)
+			}
+		}
+		return out
+	}
+	return ms
+}
+
 type fixedField struct {
 	prefix []byte
 	fields []fixedField
@@ -73,10 +123,13 @@ type Point struct {
 	fieldOrder []string
 	tags       map[string]string
 	fields     map[string]Field
+	version    uint64 // Accessed atomically; only modified while m is locked
 	m          sync.RWMutex
+	seq        seqlock // Held by Update
 }
 
 var _ = Measurement((*Point)(nil))
+var _ = SnapshotMeasurement((*Point)(nil))
 
 // NewPoint allocates a new Point with the given key, tags, and fields. If key is empty, NewPoint panics. If fields is
 // empty, the point cannot be written until it has at least one field.
@@ -107,33 +160,94 @@ func (p *Point) SetKey(key string) {
 	p.m.Lock()
 	defer p.m.Unlock()
 
-	p.key = key
+	if p.key != key {
+		p.key = key
+		p.changed()
+	}
+}
+
+// changed increments the point's version. The point must be locked for writing.
+func (p *Point) changed() {
+	atomic.AddUint64(&p.version, 1)
+}
+
+// Version returns the point's version, which increases each time its key, tags, or set of fields is modified. Changes
+// to field values do not affect the version. It is safe to call from concurrent goroutines.
+func (p *Point) Version() uint64 {
+	return atomic.LoadUint64(&p.version)
 }
 
 // WriteTo writes the point to the given writer, w. If an error occurs while building the point, it writes nothing and
 // return an error. If the point has no fields, it returns the error ErrNoFields.
 func (p *Point) WriteTo(w io.Writer) (int64, error) {
-	buf := getBuffer(w)
+	bp := getScratch()
+	b, err := p.appendTagged(*bp, nil, nil)
+	defer putScratch(bp, b)
+	return writeScratch(w, b, err)
+}
+
+// Append appends the point to dst in line protocol and returns the extended slice. If the point has no fields, it
+// returns dst and ErrNoFields. This is the same as AppendMeasurement, but reports errors.
+func (p *Point) Append(dst []byte) ([]byte, error) {
+	b, err := p.appendTagged(dst, nil, nil)
+	if err != nil {
+		return dst, err
+	}
+	return b, nil
+}
+
+// appendTagged appends the point to dst with the tags in defaults merged into its own. The point's tags take precedence
+// over defaults. defaultOrder must hold the names of defaults in ascending order.
+func (p *Point) appendTagged(dst []byte, defaults Tags, defaultOrder []string) ([]byte, error) {
 	p.m.RLock()
-	defer func() {
-		p.m.RUnlock()
-		putBuffer(buf)
-	}()
-
-	buf.WriteString(tagEscaper.Replace(p.key))
-	writeTags(buf, p.tags, p.tagOrder)
-
-	buf.WriteByte(' ')
-	if err := writeFields(buf, p.fields, p.fieldOrder); err != nil {
-		buf.Truncate(int(buf.head))
-		return 0, err
+	defer p.m.RUnlock()
+
+	if len(p.fieldOrder) == 0 {
+		return dst, ErrNoFields
 	}
 
-	buf.WriteByte(' ')
-	writeTimestamp(buf, clock.Now())
-	buf.WriteByte('\n')
+	dst = appendEscaped(dst, p.key)
+	dst = appendMergedTags(dst, p.tags, p.tagOrder, defaults, defaultOrder)
+
+	dst = append(dst, ' ')
+	for head := dst; ; {
+		seq := p.seq.begin()
+		b, err := appendFields(head, p.fields, p.fieldOrder)
+		if err != nil {
+			return b, err
+		} else if p.seq.valid(seq) {
+			dst = b
+			break
+		}
+	}
 
-	return buf.WriteTo(w)
+	dst = append(dst, ' ')
+	dst = appendTimestamp(dst, clock.Now())
+	return append(dst, '\n'), nil
+}
+
+// Snapshot returns a snapshot of the point's key, tags, and fields. Fields updated together by Update are always seen
+// from the same moment.
+func (p *Point) Snapshot() TimeMeasurement {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	if len(p.fieldOrder) == 0 {
+		return nil
+	}
+
+	when := clock.Now()
+	fields := make(Fields, len(p.fields))
+	for seq := p.seq.begin(); ; seq = p.seq.begin() {
+		for name, field := range p.fields {
+			fields[name] = snapshotField(field)
+		}
+		if p.seq.valid(seq) {
+			break
+		}
+	}
+
+	return timePoint{p.key, when, Tags(p.tags).Dup(), fields}
 }
 
 // GetKey returns the point's key.
@@ -144,12 +258,14 @@ func (p *Point) GetKey() string {
 }
 
 // Compiled returns a compiled form of the point. The resulting Measurement is immutable except for its field values.
-// New fields may not be added and it will not return a key, tags, or values. The compiled form of a point is only
-// useful to improve write times on points when necessary. If the point has no fields, it returns nil, as the point is
-// not valid to write.
+// New fields may not be added, and its key, tags, and fields are those the point had when it was compiled. The compiled
+// form of a point is only useful to improve write times on points when necessary. If the point has no fields, it
+// returns nil, as the point is not valid to write.
+//
+// To get a compiled form of the point that reflects later changes to it, use Linked.
 func (p *Point) Compiled() Measurement {
 	p.m.RLock()
-	p.m.RUnlock()
+	defer p.m.RUnlock()
 
 	if len(p.fieldOrder) == 0 {
 		return nil
@@ -158,37 +274,40 @@ func (p *Point) Compiled() Measurement {
 }
 
 func (p *Point) compile() compiledPoint {
-	var c compiledPoint
-	buf := getBuffer(nil)
-	defer putBuffer(buf)
-
+	c := compiledPoint{
+		seq:      &p.seq,
+		key:      p.key,
+		tags:     Tags(p.tags).Dup(),
+		tagOrder: append([]string(nil), p.tagOrder...),
+	}
 	// Write key
-	buf.WriteString(tagEscaper.Replace(p.key))
+	buf := appendEscaped(nil, p.key)
 	// Write tags
-	writeTags(buf, p.tags, p.tagOrder)
-	c.lead = buf.Len()
+	buf = appendTags(buf, p.tags, p.tagOrder)
+	c.tagEnd = len(buf)
+	c.lead = c.tagEnd
 	// Write field names
 	var pre byte = ' '
 	fields := make([]compiledField, len(p.fieldOrder))
 	for i, name := range p.fieldOrder {
 		field := p.fields[name]
 
-		from := buf.Len()
-		buf.WriteByte(pre)
+		from := len(buf)
+		buf = append(buf, pre)
 		pre = ','
 
-		buf.WriteString(tagEscaper.Replace(name))
-		buf.WriteByte('=')
+		buf = appendEscaped(buf, name)
+		buf = append(buf, '=')
 
-		to := buf.Len()
+		to := len(buf)
 		if i == 0 {
 			from = to
 			c.lead = to
 		}
-		fields[i] = compiledField{from, to, field}
+		fields[i] = compiledField{from, to, name, field}
 	}
 
-	c.prefix = append([]byte(nil), buf.Bytes()...)
+	c.prefix = buf
 	c.fields = fields
 
 	return c
@@ -197,8 +316,12 @@ func (p *Point) compile() compiledPoint {
 // Tags
 
 func (p *Point) addTag(name, value string) {
-	_, exists := p.tags[name]
+	old, exists := p.tags[name]
+	if exists && old == value {
+		return
+	}
 	p.tags[name] = value
+	p.changed()
 	if exists {
 		return
 	}
@@ -236,6 +359,7 @@ func (p *Point) RemoveTag(name string) {
 	}
 
 	delete(p.tags, name)
+	p.changed()
 	for i, tname := range p.tagOrder {
 		if name == tname {
 			copy(p.tagOrder[i:], p.tagOrder[i+1:])
@@ -273,6 +397,7 @@ func insertOrderedString(slice []string, str string) []string {
 func (p *Point) addField(name string, value Field) {
 	_, exists := p.fields[name]
 	p.fields[name] = value
+	p.changed()
 	if exists {
 		return
 	}
@@ -310,6 +435,7 @@ func (p *Point) RemoveField(name string) {
 	}
 
 	delete(p.fields, name)
+	p.changed()
 	for i, fname := range p.fieldOrder {
 		if name == fname {
 			copy(p.fieldOrder[i:], p.fieldOrder[i+1:])
@@ -328,19 +454,16 @@ func (p *Point) GetFields() Fields {
 	return Fields(p.fields).Dup(false)
 }
 
+// MarshalJSON returns the point as a JSON object in the same form as WriteMeasurementsJSON, with the current time as
+// its timestamp.
 func (p *Point) MarshalJSON() ([]byte, error) {
 	p.m.RLock()
 	defer p.m.RUnlock()
-	jsonPoint := struct {
-		Key       string
-		Timestamp int64 `json:",string"`
-		Tags      jsonFields
-		Fields    jsonFields
-	}{
-		p.key,
-		clock.Now().UnixNano(),
-		makeJSONFields(p.fields, p.fieldOrder),
-		makeJSONTags(p.tags, p.tagOrder),
+	jsonPoint := jsonMeasurement{
+		Key:       p.key,
+		Timestamp: clock.Now().UnixNano(),
+		Tags:      makeJSONTags(p.tags, p.tagOrder),
+		Fields:    makeJSONFields(p.fields, p.fieldOrder),
 	}
 
 	return json.Marshal(jsonPoint)
@@ -144,6 +144,65 @@ func TestCompiledPoint(t *testing.T) {
 	}
 }
 
+func TestLinkedCompiledPoint(t *testing.T) {
+	defer prepareLogger(t)()
+
+	count := new(Int)
+	count.Set(1)
+	p := NewPoint("requests", Tags{"host": "example.local"}, Fields{"count": count})
+	c := p.Linked()
+
+	write := func(want string) {
+		t.Helper()
+		var buf bytes.Buffer
+		if _, err := WriteMeasurement(&buf, c); err != nil {
+			t.Fatal(err)
+		} else if got := buf.String(); got != want {
+			t.Errorf("Expected %q\nGot %q", want, got)
+		}
+	}
+
+	write("requests,host=example.local count=1i 1136214245000000000\n")
+	version := c.Version()
+	if version != p.Version() {
+		t.Errorf("Version() = %d; want %d", version, p.Version())
+	}
+
+	// Field values don't require recompiling.
+	count.Add(1)
+	write("requests,host=example.local count=2i 1136214245000000000\n")
+	if c.Version() != version {
+		t.Errorf("Version() = %d; want %d", c.Version(), version)
+	}
+
+	// Setting a tag to its current value isn't a change.
+	p.SetTag("host", "example.local")
+	if p.Version() != version {
+		t.Errorf("Version() = %d after no-op SetTag; want %d", p.Version(), version)
+	}
+
+	p.SetKey("http_requests")
+	p.SetTag("path", "/kittens")
+	p.RemoveTag("host")
+	p.SetField("ok", RawBool(true))
+	write("http_requests,path=/kittens count=2i,ok=T 1136214245000000000\n")
+	if c.Version() != p.Version() || c.Version() <= version {
+		t.Errorf("Version() = %d; want %d", c.Version(), p.Version())
+	}
+
+	p.RemoveField("count")
+	p.RemoveField("ok")
+	if _, err := WriteMeasurement(ioutil.Discard, c); err != ErrNoFields {
+		t.Errorf("WriteMeasurement() = %v; want %v", err, ErrNoFields)
+	}
+	if snap := Snapshot(c); snap != nil {
+		t.Errorf("Snapshot() = %#v; want nil", snap)
+	}
+	if snap := p.compile().Snapshot(); snap != nil {
+		t.Errorf("compile().Snapshot() = %#v; want nil", snap)
+	}
+}
+
 func BenchmarkWriteMeasurement(b *testing.B) {
 	const result = `service.some_event,host=example.local,pid=1234 depth=123.456,msg="a \"string\" of sorts",on=T,value=123i 1136214245000000000` + "\n"
 
@@ -196,6 +255,30 @@ func BenchmarkWriteMeasurement_Compiled(b *testing.B) {
 	}
 }
 
+func BenchmarkWriteMeasurement_Linked(b *testing.B) {
+	defer prepareLogger(b)()
+
+	integer := new(Int)
+	boolean := new(Bool)
+	float := new(Float)
+	str := new(String)
+
+	integer.Set(123)
+	boolean.Set(true)
+	float.Set(123.456)
+	str.Set(`a "string" of sorts`)
+
+	m := NewPoint(
+		"service.some_event",
+		Tags{"pid": fmt.Sprint(1234), "host": "example.local"},
+		Fields{"value": integer, "depth": float, "on": boolean, "msg": str},
+	).Linked()
+
+	for i := b.N; i > 0; i-- {
+		WriteMeasurement(ioutil.Discard, m)
+	}
+}
+
 func BenchmarkWriteMeasurement_Parallel(b *testing.B) {
 	const result = `service.some_event,host=example.local,pid=1234 depth=123.456,msg="a \"string\" of sorts",on=T,value=123i 1136214245000000000` + "\n"
 
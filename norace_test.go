@@ -0,0 +1,5 @@
+//+build !race
+
+package dagr
+
+const raceEnabled = false
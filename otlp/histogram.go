@@ -0,0 +1,170 @@
+package otlp
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"go.spiff.io/dagr"
+)
+
+// HistogramData is the state of a histogram at a point in time. Counts holds one count per bucket, where bucket i
+// holds values <= Bounds[i] and greater than Bounds[i-1], and the final bucket holds values greater than the last
+// bound. Counts always has one more element than Bounds.
+type HistogramData struct {
+	Count    uint64
+	Sum      float64
+	Min, Max float64
+	Bounds   []float64
+	Counts   []uint64
+}
+
+// HistogramField is any dagr.Field that holds a histogram. The Exporter sends fields implementing HistogramField as
+// OTLP histograms.
+type HistogramField interface {
+	dagr.Field
+	HistogramData() HistogramData
+}
+
+// Histogram is a HistogramField with fixed bucket bounds. Values are recorded with Observe. The zero value is a
+// histogram with a single bucket.
+//
+// When written as line protocol, a Histogram is written as its count, since InfluxDB has no histogram type.
+type Histogram struct {
+	bounds []float64
+
+	m        sync.Mutex
+	counts   []uint64
+	count    uint64
+	sum      float64
+	min, max float64
+}
+
+var _ = HistogramField((*Histogram)(nil))
+var _ = dagr.MergeField((*Histogram)(nil))
+
+// NewHistogram allocates a new Histogram with the given bucket bounds. Bounds are sorted, and duplicate and NaN bounds
+// are discarded.
+func NewHistogram(bounds ...float64) *Histogram {
+	sorted := make([]float64, 0, len(bounds))
+	for _, b := range bounds {
+		if !math.IsNaN(b) {
+			sorted = append(sorted, b)
+		}
+	}
+	sort.Float64s(sorted)
+
+	uniq := sorted[:0]
+	for i, b := range sorted {
+		if i == 0 || b != sorted[i-1] {
+			uniq = append(uniq, b)
+		}
+	}
+
+	return &Histogram{bounds: uniq}
+}
+
+// Observe records a value in the histogram. NaN values are ignored.
+func (h *Histogram) Observe(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.counts == nil {
+		h.counts = make([]uint64, len(h.bounds)+1)
+	}
+	h.counts[sort.SearchFloat64s(h.bounds, v)]++
+
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+}
+
+// HistogramData returns a copy of the histogram's current state.
+func (h *Histogram) HistogramData() HistogramData {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	counts := make([]uint64, len(h.bounds)+1)
+	copy(counts, h.counts)
+	return HistogramData{
+		Count:  h.count,
+		Sum:    h.sum,
+		Min:    h.min,
+		Max:    h.max,
+		Bounds: h.bounds,
+		Counts: counts,
+	}
+}
+
+// Dup returns a copy of the histogram.
+func (h *Histogram) Dup() dagr.Field {
+	data := h.HistogramData()
+	return &Histogram{
+		bounds: data.Bounds,
+		counts: data.Counts,
+		count:  data.Count,
+		sum:    data.Sum,
+		min:    data.Min,
+		max:    data.Max,
+	}
+}
+
+// Merge returns a new Histogram combining the histogram with other, which allows a PointSet rollup to sum histograms.
+// It returns false if other is not a HistogramField with the same bounds.
+func (h *Histogram) Merge(other dagr.Field) (dagr.Field, bool) {
+	hf, ok := other.(HistogramField)
+	if !ok {
+		return nil, false
+	}
+
+	a, b := h.HistogramData(), hf.HistogramData()
+	if len(a.Bounds) != len(b.Bounds) {
+		return nil, false
+	}
+	for i := range a.Bounds {
+		if a.Bounds[i] != b.Bounds[i] {
+			return nil, false
+		}
+	}
+
+	merged := &Histogram{
+		bounds: a.Bounds,
+		counts: a.Counts,
+		count:  a.Count + b.Count,
+		sum:    a.Sum + b.Sum,
+		min:    a.Min,
+		max:    a.Max,
+	}
+	for i, n := range b.Counts {
+		merged.counts[i] += n
+	}
+	if a.Count == 0 || (b.Count > 0 && b.Min < a.Min) {
+		merged.min = b.Min
+	}
+	if a.Count == 0 || (b.Count > 0 && b.Max > a.Max) {
+		merged.max = b.Max
+	}
+	return merged, true
+}
+
+// WriteTo writes the histogram's count to w as an integer.
+func (h *Histogram) WriteTo(w io.Writer) (int64, error) {
+	h.m.Lock()
+	count := h.count
+	h.m.Unlock()
+
+	var buf [21]byte
+	n, err := w.Write(append(strconv.AppendUint(buf[:0], count, 10), 'i'))
+	return int64(n), err
+}
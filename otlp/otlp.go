@@ -0,0 +1,436 @@
+// Package otlp exports dagr measurements to an OpenTelemetry collector as OTLP/HTTP JSON ExportMetricsServiceRequest
+// payloads. Exporter is built on an outflux.Proxy, so it buffers, retries, and backs off the same way.
+package otlp // import "go.spiff.io/dagr/otlp"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.spiff.io/dagr"
+	"go.spiff.io/dagr/outflux"
+)
+
+// Kind is the kind of OTLP metric a field is sent as.
+type Kind int
+
+const (
+	// Skip causes a field to not be sent.
+	Skip Kind = iota
+	// Gauge sends a field as a gauge.
+	Gauge
+	// Sum sends a field as a monotonic, cumulative sum (i.e., a counter).
+	Sum
+	// UpDownSum sends a field as a non-monotonic, cumulative sum.
+	UpDownSum
+)
+
+// KindFunc returns the Kind to send a field as. It is not consulted for fields implementing HistogramField, which are
+// always sent as histograms.
+type KindFunc func(key, field string, value dagr.Field) Kind
+
+// DefaultKind sends Int fields as Sums and all other numeric and boolean fields as Gauges. String fields are skipped.
+// Booleans are sent as 1 for true and 0 for false.
+func DefaultKind(key, field string, value dagr.Field) Kind {
+	switch dagr.FieldValue(value).(type) {
+	case int64:
+		return Sum
+	case uint64, float64, bool:
+		return Gauge
+	}
+	return Skip
+}
+
+// ScopeName is the instrumentation scope name sent with all metrics.
+const ScopeName = "go.spiff.io/dagr"
+
+// Exporter buffers dagr measurements as OTLP metrics and POSTs them to an OTLP/HTTP endpoint (typically a collector's
+// /v1/metrics URL) as JSON. Each field of a measurement is sent as a metric named after the measurement's key and the
+// field's name, separated by a period, and tags are sent as data point attributes.
+//
+// Sums and histograms are sent with cumulative temporality, starting from the time the Exporter was allocated, since
+// dagr fields accumulate over the life of a process.
+//
+// An Exporter's exported fields must not be modified once it's in use. It is safe to use an Exporter from concurrent
+// goroutines.
+type Exporter struct {
+	// Kind decides how each field is sent. If nil, DefaultKind is used.
+	Kind KindFunc
+
+	// Resource holds the resource attributes sent with all metrics (e.g., service.name).
+	Resource dagr.Tags
+
+	proxy *outflux.Proxy
+	start time.Time
+}
+
+var _ = dagr.MeasurementWriter((*Exporter)(nil))
+
+// NewURL allocates a new Exporter that sends metrics to destURL. If destURL is nil, NewURL panics. Options are passed
+// to the Exporter's outflux.Proxy and control its flush size, timeouts, retries, and so on.
+//
+// Since the Exporter writes encoded requests to its Proxy directly, outflux.DefaultTags and outflux.Format options
+// would have no effect, and NewURL panics if given either. An outflux.Encoding option is kept, but any of its fields
+// left empty are set to the Exporter's defaults: a Content-Type of application/json, a Body that wraps buffered data
+// in an ExportMetricsServiceRequest, and a Status of 200 (OK). A Body given by the caller receives the buffered
+// resourceMetrics as comma-terminated JSON objects.
+//
+// If the HTTP client given is nil, NewURL will use http.DefaultClient.
+func NewURL(client *http.Client, destURL *url.URL, opts ...outflux.Option) *Exporter {
+	if destURL == nil {
+		panic("otlp: destination url is nil")
+	}
+
+	var encoding outflux.Encoding
+	proxyOpts := make([]outflux.Option, 0, len(opts)+1)
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case outflux.Encoding:
+			encoding = opt
+			continue
+		case outflux.DefaultTags, *outflux.DefaultTags:
+			panic("otlp: outflux.DefaultTags does not apply to an Exporter")
+		case outflux.Format, *outflux.Format:
+			panic("otlp: outflux.Format does not apply to an Exporter")
+		}
+		proxyOpts = append(proxyOpts, opt)
+	}
+
+	if encoding.ContentType == "" {
+		encoding.ContentType = "application/json"
+	}
+	if encoding.Body == nil {
+		encoding.Body = requestBody
+	}
+	if encoding.Status == 0 {
+		encoding.Status = http.StatusOK
+	}
+	proxyOpts = append(proxyOpts, encoding)
+
+	return &Exporter{
+		proxy: outflux.NewURL(client, destURL, proxyOpts...),
+		start: time.Now(),
+	}
+}
+
+// New allocates a new Exporter that sends metrics to destURL. Unlike NewURL, this will parse the URL first. If the URL
+// is empty or invalid, New panics. See NewURL for further information.
+func New(client *http.Client, destURL string, opts ...outflux.Option) *Exporter {
+	if destURL == "" {
+		panic("otlp: destination url is empty")
+	}
+
+	du, err := url.Parse(destURL)
+	if err != nil {
+		panic("otlp: error parsing url: " + err.Error())
+	}
+
+	return NewURL(client, du, opts...)
+}
+
+// Start creates a goroutine that sends buffered metrics at the given interval. See outflux.Proxy's Start method.
+func (e *Exporter) Start(ctx context.Context, interval time.Duration) {
+	e.proxy.Start(ctx, interval)
+}
+
+// Flush forces the Exporter to send all buffered metrics and returns any error that occurred sending them. See
+// outflux.Proxy's Flush method.
+func (e *Exporter) Flush(ctx context.Context) error {
+	return e.proxy.Flush(ctx)
+}
+
+// WriteMeasurement buffers a single measurement.
+func (e *Exporter) WriteMeasurement(m dagr.Measurement) (int64, error) {
+	return e.WriteMeasurements(m)
+}
+
+// WriteMeasurements buffers all measurements in ms to be sent on the next flush. MeasurementSets are expanded into
+// their measurements. Field values are read when WriteMeasurements is called, not when they're sent.
+func (e *Exporter) WriteMeasurements(ms ...dagr.Measurement) (n int64, err error) {
+	rm := e.resourceMetrics(ms)
+	if rm == nil {
+		return 0, nil
+	}
+
+	b, err := json.Marshal(rm)
+	if err != nil {
+		return 0, err
+	}
+	// Each write is a single element of the request's resourceMetrics. requestBody removes the trailing comma.
+	b = append(b, ',')
+
+	err = e.proxy.Transaction(func(w io.Writer) error {
+		wn, err := w.Write(b)
+		n = int64(wn)
+		return err
+	})
+	return n, err
+}
+
+// requestBody converts a buffer of comma-terminated resourceMetrics into an ExportMetricsServiceRequest.
+func requestBody(data []byte) ([]byte, error) {
+	data = bytes.TrimRight(data, ",")
+	body := make([]byte, 0, len(data)+len(`{"resourceMetrics":[]}`))
+	body = append(body, `{"resourceMetrics":[`...)
+	body = append(body, data...)
+	body = append(body, `]}`...)
+	return body, nil
+}
+
+func (e *Exporter) kind(key, field string, value dagr.Field) Kind {
+	if e.Kind == nil {
+		return DefaultKind(key, field, value)
+	}
+	return e.Kind(key, field, value)
+}
+
+// resourceMetrics converts measurements to a resourceMetrics. It returns nil if there are no metrics to send.
+func (e *Exporter) resourceMetrics(ms []dagr.Measurement) *resourceMetrics {
+	var (
+		start   = uint64String(e.start.UnixNano())
+		metrics []*metric
+		byName  = make(map[string]*metric)
+		names   []string
+	)
+
+	for _, m := range dagr.Flatten(ms...) {
+		key, fields := m.GetKey(), m.GetFields()
+		if key == "" || len(fields) == 0 {
+			continue
+		}
+
+		when := time.Now()
+		if tm, ok := m.(dagr.TimeMeasurement); ok {
+			when = tm.GetTime()
+		}
+		now := uint64String(when.UnixNano())
+		attrs := attributes(m.GetTags())
+
+		names = names[:0]
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			field := fields[name]
+			kind := Skip
+			if _, ok := field.(HistogramField); !ok {
+				if kind = e.kind(key, name, field); kind == Skip {
+					continue
+				}
+			}
+
+			metricName := key + "." + name
+			mt := byName[metricName]
+			if mt == nil {
+				mt = &metric{Name: metricName}
+			}
+
+			if !mt.add(field, kind, attrs, start, now) {
+				continue
+			}
+
+			if byName[metricName] == nil {
+				byName[metricName] = mt
+				metrics = append(metrics, mt)
+			}
+		}
+	}
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	return &resourceMetrics{
+		Resource: resource{Attributes: attributes(e.Resource)},
+		ScopeMetrics: []scopeMetrics{{
+			Scope:   scope{Name: ScopeName},
+			Metrics: metrics,
+		}},
+	}
+}
+
+// add adds a data point for field to the metric. It returns false if the field can't be sent as the given kind or
+// doesn't match the metric's existing data.
+func (m *metric) add(field dagr.Field, kind Kind, attrs []attribute, start, now uint64String) bool {
+	if hf, ok := field.(HistogramField); ok {
+		if m.Gauge != nil || m.Sum != nil {
+			return false
+		}
+		if m.Histogram == nil {
+			m.Histogram = &histogram{AggregationTemporality: temporalityCumulative}
+		}
+
+		data := hf.HistogramData()
+		dp := histogramDataPoint{
+			Attributes:        attrs,
+			StartTimeUnixNano: start,
+			TimeUnixNano:      now,
+			Count:             uint64String(data.Count),
+			ExplicitBounds:    data.Bounds,
+			BucketCounts:      make([]uint64String, len(data.Counts)),
+		}
+		for i, c := range data.Counts {
+			dp.BucketCounts[i] = uint64String(c)
+		}
+		if data.Count > 0 && finite(data.Sum) && finite(data.Min) && finite(data.Max) {
+			dp.Sum, dp.Min, dp.Max = &data.Sum, &data.Min, &data.Max
+		}
+		m.Histogram.DataPoints = append(m.Histogram.DataPoints, dp)
+		return true
+	}
+
+	dp := numberDataPoint{Attributes: attrs, TimeUnixNano: now}
+	switch v := dagr.FieldValue(field).(type) {
+	case int64:
+		dp.AsInt = strconv.FormatInt(v, 10)
+	case uint64:
+		if v > math.MaxInt64 {
+			f := float64(v)
+			dp.AsDouble = &f
+		} else {
+			dp.AsInt = strconv.FormatUint(v, 10)
+		}
+	case float64:
+		if !finite(v) {
+			return false
+		}
+		dp.AsDouble = &v
+	case bool:
+		dp.AsInt = "0"
+		if v {
+			dp.AsInt = "1"
+		}
+	default:
+		return false
+	}
+
+	switch kind {
+	case Gauge:
+		if m.Sum != nil || m.Histogram != nil {
+			return false
+		}
+		if m.Gauge == nil {
+			m.Gauge = &gauge{}
+		}
+		m.Gauge.DataPoints = append(m.Gauge.DataPoints, dp)
+	case Sum, UpDownSum:
+		monotonic := kind == Sum
+		if m.Gauge != nil || m.Histogram != nil || (m.Sum != nil && m.Sum.IsMonotonic != monotonic) {
+			return false
+		}
+		if m.Sum == nil {
+			m.Sum = &sum{AggregationTemporality: temporalityCumulative, IsMonotonic: monotonic}
+		}
+		dp.StartTimeUnixNano = start
+		m.Sum.DataPoints = append(m.Sum.DataPoints, dp)
+	default:
+		return false
+	}
+	return true
+}
+
+func finite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+func attributes(tags dagr.Tags) []attribute {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	attrs := make([]attribute, 0, len(tags))
+	for name, value := range tags {
+		attrs = append(attrs, attribute{Key: name, Value: anyValue{StringValue: value}})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
+
+// OTLP JSON types. These only cover the subset of the metrics protocol the Exporter sends. Per the OTLP JSON encoding,
+// 64-bit integers are encoded as strings.
+
+const temporalityCumulative = 2
+
+type uint64String uint64
+
+func (u uint64String) MarshalJSON() ([]byte, error) {
+	return strconv.AppendQuote(nil, strconv.FormatUint(uint64(u), 10)), nil
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes,omitempty"`
+}
+
+type scopeMetrics struct {
+	Scope   scope     `json:"scope"`
+	Metrics []*metric `json:"metrics"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type attribute struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type metric struct {
+	Name      string     `json:"name"`
+	Gauge     *gauge     `json:"gauge,omitempty"`
+	Sum       *sum       `json:"sum,omitempty"`
+	Histogram *histogram `json:"histogram,omitempty"`
+}
+
+type gauge struct {
+	DataPoints []numberDataPoint `json:"dataPoints"`
+}
+
+type sum struct {
+	DataPoints             []numberDataPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type histogram struct {
+	DataPoints             []histogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type numberDataPoint struct {
+	Attributes        []attribute  `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64String `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      uint64String `json:"timeUnixNano"`
+	AsInt             string       `json:"asInt,omitempty"`
+	AsDouble          *float64     `json:"asDouble,omitempty"`
+}
+
+type histogramDataPoint struct {
+	Attributes        []attribute    `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64String   `json:"startTimeUnixNano"`
+	TimeUnixNano      uint64String   `json:"timeUnixNano"`
+	Count             uint64String   `json:"count"`
+	Sum               *float64       `json:"sum,omitempty"`
+	Min               *float64       `json:"min,omitempty"`
+	Max               *float64       `json:"max,omitempty"`
+	BucketCounts      []uint64String `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds,omitempty"`
+}
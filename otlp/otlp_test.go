@@ -0,0 +1,224 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.spiff.io/dagr"
+	"go.spiff.io/dagr/outflux"
+)
+
+var testTime = time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+// collector is a stand-in for an OTLP/HTTP collector. It records each request body it receives and drops the
+// connection for the first failures requests.
+func collector(t *testing.T, failures int32) (*httptest.Server, <-chan map[string]interface{}) {
+	bodies := make(chan map[string]interface{}, 10)
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+
+		if r.Method != "POST" || r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request: %s %s (%s)", r.Method, r.URL, r.Header.Get("Content-Type"))
+		}
+
+		var body map[string]interface{}
+		b, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &body); err != nil {
+			t.Errorf("invalid body %q: %v", b, err)
+		}
+		bodies <- body
+		w.Write([]byte("{}"))
+	}))
+	return srv, bodies
+}
+
+// path walks a decoded JSON value using map keys and slice indices.
+func path(v interface{}, keys ...interface{}) interface{} {
+	for _, k := range keys {
+		switch k := k.(type) {
+		case string:
+			m, _ := v.(map[string]interface{})
+			v = m[k]
+		case int:
+			s, _ := v.([]interface{})
+			if k >= len(s) {
+				return nil
+			}
+			v = s[k]
+		}
+	}
+	return v
+}
+
+func TestExporter(t *testing.T) {
+	srv, bodies := collector(t, 1)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	exp := New(nil, srv.URL+"/v1/metrics", outflux.RetryLimit(2), outflux.FixedBackoff(0))
+	exp.Resource = dagr.Tags{"service.name": "kittens"}
+	exp.Kind = func(key, field string, value dagr.Field) Kind {
+		if field == "active" {
+			return UpDownSum
+		}
+		return DefaultKind(key, field, value)
+	}
+	exp.Start(ctx, 0)
+
+	latency := NewHistogram(100, 10, 50)
+	for _, v := range []float64{5, 20, 20, 500} {
+		latency.Observe(v)
+	}
+
+	exp.WriteMeasurement(dagr.RawPoint{
+		Key:  "http",
+		Tags: dagr.Tags{"host": "example.local"},
+		Fields: dagr.Fields{
+			"requests": dagr.RawInt(4),
+			"active":   dagr.RawInt(-1),
+			"load":     dagr.RawFloat(0.5),
+			"latency":  latency,
+			"msg":      dagr.RawString("skipped"),
+		},
+		Time: testTime,
+	})
+	exp.WriteMeasurement(dagr.RawPoint{
+		Key:    "http",
+		Tags:   dagr.Tags{"host": "other.local"},
+		Fields: dagr.Fields{"requests": dagr.RawInt(1)},
+		Time:   testTime,
+	})
+
+	if err := exp.Flush(ctx); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+
+	body := <-bodies
+	if rms := path(body, "resourceMetrics").([]interface{}); len(rms) != 2 {
+		t.Fatalf("len(resourceMetrics) = %d; want 2", len(rms))
+	}
+
+	rm := path(body, "resourceMetrics", 0)
+	if got := path(rm, "resource", "attributes", 0, "value", "stringValue"); got != "kittens" {
+		t.Errorf("service.name = %v", got)
+	}
+
+	metrics := path(rm, "scopeMetrics", 0, "metrics").([]interface{})
+	byName := make(map[string]interface{})
+	for _, m := range metrics {
+		byName[path(m, "name").(string)] = m
+	}
+	if len(byName) != 4 {
+		t.Errorf("metrics = %v; want 4 metrics", metrics)
+	}
+
+	const nanos = "1136214245000000000"
+	cases := []struct {
+		name string
+		path []interface{}
+		want interface{}
+	}{
+		{"http.requests", []interface{}{"sum", "isMonotonic"}, true},
+		{"http.requests", []interface{}{"sum", "aggregationTemporality"}, 2.0},
+		{"http.requests", []interface{}{"sum", "dataPoints", 0, "asInt"}, "4"},
+		{"http.requests", []interface{}{"sum", "dataPoints", 0, "timeUnixNano"}, nanos},
+		{"http.requests", []interface{}{"sum", "dataPoints", 0, "attributes", 0, "key"}, "host"},
+		{"http.requests", []interface{}{"sum", "dataPoints", 0, "attributes", 0, "value", "stringValue"}, "example.local"},
+		{"http.active", []interface{}{"sum", "isMonotonic"}, false},
+		{"http.active", []interface{}{"sum", "dataPoints", 0, "asInt"}, "-1"},
+		{"http.load", []interface{}{"gauge", "dataPoints", 0, "asDouble"}, 0.5},
+		{"http.latency", []interface{}{"histogram", "dataPoints", 0, "count"}, "4"},
+		{"http.latency", []interface{}{"histogram", "dataPoints", 0, "sum"}, 545.0},
+		{"http.latency", []interface{}{"histogram", "dataPoints", 0, "min"}, 5.0},
+		{"http.latency", []interface{}{"histogram", "dataPoints", 0, "max"}, 500.0},
+		{"http.latency", []interface{}{"histogram", "dataPoints", 0, "explicitBounds", 1}, 50.0},
+		{"http.latency", []interface{}{"histogram", "dataPoints", 0, "bucketCounts", 0}, "1"},
+		{"http.latency", []interface{}{"histogram", "dataPoints", 0, "bucketCounts", 1}, "2"},
+		{"http.latency", []interface{}{"histogram", "dataPoints", 0, "bucketCounts", 3}, "1"},
+	}
+	for _, c := range cases {
+		if got := path(byName[c.name], c.path...); got != c.want {
+			t.Errorf("%s %v = %#v; want %#v", c.name, c.path, got, c.want)
+		}
+	}
+
+	rm = path(body, "resourceMetrics", 1)
+	if got := path(rm, "scopeMetrics", 0, "metrics", 0, "sum", "dataPoints", 0, "asInt"); got != "1" {
+		t.Errorf("second http.requests = %v; want 1", got)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b := NewHistogram(1, 10), NewHistogram(1, 10)
+	a.Observe(5)
+	b.Observe(0.5)
+	b.Observe(20)
+
+	merged, ok := a.Merge(b)
+	if !ok {
+		t.Fatal("Merge() = false; want true")
+	}
+
+	got := merged.(*Histogram).HistogramData()
+	want := HistogramData{Count: 3, Sum: 25.5, Min: 0.5, Max: 20, Bounds: []float64{1, 10}, Counts: []uint64{1, 1, 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge() = %+v; want %+v", got, want)
+	}
+
+	if _, ok := a.Merge(NewHistogram(2)); ok {
+		t.Error("Merge() with different bounds = true; want false")
+	}
+}
+
+func TestNewURLOptions(t *testing.T) {
+	srv, bodies := collector(t, 0)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A caller's Encoding is kept, with its empty fields set to the Exporter's defaults.
+	var calls int32
+	exp := New(nil, srv.URL+"/v1/metrics", outflux.Encoding{
+		Body: func(data []byte) ([]byte, error) {
+			atomic.AddInt32(&calls, 1)
+			return requestBody(data)
+		},
+	})
+	exp.Start(ctx, 0)
+	exp.WriteMeasurement(dagr.RawPoint{Key: "http", Fields: dagr.Fields{"requests": dagr.RawInt(1)}, Time: testTime})
+	if err := exp.Flush(ctx); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	<-bodies
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("Body calls = %d; want 1", got)
+	}
+
+	for _, opt := range []outflux.Option{outflux.DefaultTags{"a": "b"}, outflux.JSONLines} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("New(%#v) did not panic", opt)
+				}
+			}()
+			New(nil, srv.URL+"/v1/metrics", opt)
+		}()
+	}
+}
@@ -3,6 +3,8 @@ package outflux
 import (
 	"net/http"
 	"time"
+
+	"go.spiff.io/dagr"
 )
 
 // Option is any configuration option capable of configuring a Proxy on creation.
@@ -17,6 +19,31 @@ func (sz FlushSize) configure(p *Proxy) {
 	p.flushSize = int(sz)
 }
 
+// DefaultTags are tags added to every measurement written to a Proxy through its WriteMeasurement, WriteMeasurements,
+// and WritePoint methods. Tags belonging to a measurement take precedence over default tags of the same name. Data
+// written to the Proxy as raw bytes (e.g., via Write or Transaction) does not receive default tags.
+type DefaultTags dagr.Tags
+
+func (t DefaultTags) configure(p *Proxy) {
+	p.encoder.DefaultTags = dagr.Tags(t).Dup()
+}
+
+// Format controls the format measurements are written in by a Proxy's WriteMeasurement, WriteMeasurements, and
+// WritePoint methods. Data written to the Proxy as raw bytes is not affected.
+type Format int
+
+const (
+	// LineProtocol writes measurements as InfluxDB line protocol. This is the default.
+	LineProtocol Format = iota
+	// JSONLines writes measurements as JSON Lines, as written by dagr.WriteMeasurementsJSON. Unless an Encoding
+	// option gives a Content-Type, requests are sent with a Content-Type of application/x-ndjson.
+	JSONLines
+)
+
+func (f Format) configure(p *Proxy) {
+	p.format = f
+}
+
 // Timeout controls the timeout for InfluxDB requests. If the timeout is <= 0, soft timeouts are
 // disabled. This does not affect client / transport and server timeouts, the former of which must
 // be provided by way of an HTTP client on creation.
@@ -124,3 +151,29 @@ func DefaultBackoff(retry, maxRetries int) time.Duration {
 // A Director is responsible for configuring an HTTP request as needed before sending it. If the
 // Director returns an error, the request is discarded immediately.
 type Director func(*http.Request) error
+
+// Encoding controls how a Proxy sends its buffered data. The zero Encoding sends data as-is, as expected by InfluxDB.
+// This is mainly useful for sending data to something other than InfluxDB, where the Proxy's buffering, retries, and
+// backoff are still wanted but the request needs to look different.
+type Encoding struct {
+	// ContentType is the Content-Type header sent with each request.
+	ContentType string
+
+	// Body, if not nil, converts buffered data to a request body before sending it. If it returns an error, the data
+	// is discarded.
+	Body func(data []byte) ([]byte, error)
+
+	// Status is the response status that indicates success. If 0, it defaults to 204 (No Content).
+	Status int
+}
+
+func (e Encoding) configure(p *Proxy) {
+	p.encoding = e
+}
+
+func (e Encoding) status() int {
+	if e.Status == 0 {
+		return http.StatusNoContent
+	}
+	return e.Status
+}
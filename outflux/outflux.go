@@ -51,10 +51,19 @@ type Proxy struct {
 	retries   int
 	delayfunc BackoffFunc
 
+	encoder  dagr.Encoder
+	encoding Encoding
+	format   Format
+
 	startOnce sync.Once
 	flush     chan flushop
+
+	statusLock sync.Mutex // controls status
+	status     Status
 }
 
+var _ = dagr.MeasurementWriter((*Proxy)(nil))
+
 // NewURL allocates a new Proxy with a given context, HTTP client, and URL. If the URL is nil, NewURL panics. If the
 // context is nil, a new background context is allocated specifically for the Proxy.
 //
@@ -200,17 +209,28 @@ func (w *Proxy) Transaction(fn WriteFunc) (err error) {
 }
 
 // WriteMeasurements writes all measurements in measurements to the Proxy, effectively queueing them for delivery.
+// Measurements are encoded in the Proxy's Format with any DefaultTags the Proxy was configured with.
 func (w *Proxy) WriteMeasurements(measurements ...dagr.Measurement) (n int64, err error) {
 	if len(measurements) == 0 {
 		return 0, nil
 	}
 
-	return dagr.WriteMeasurements(w, measurements...)
+	if w.format == JSONLines {
+		return w.encoder.WriteMeasurementsJSON(w, measurements...)
+	}
+
+	// The Encoder appends measurements to one of dagr's pooled scratch buffers and writes them in a single call to
+	// Write, so encoding doesn't hold the write buffer's lock or allocate.
+	return w.encoder.WriteMeasurements(w, measurements...)
 }
 
 // WriteMeasurement writes a single measurement to the Proxy.
 func (w *Proxy) WriteMeasurement(measurement dagr.Measurement) (n int64, err error) {
-	return dagr.WriteMeasurement(w, measurement)
+	if w.format == JSONLines {
+		return w.encoder.WriteMeasurementsJSON(w, measurement)
+	}
+
+	return w.encoder.WriteMeasurement(w, measurement)
 }
 
 // WritePoint writes a single point to the Proxy.
@@ -227,7 +247,7 @@ func (w *Proxy) WritePoint(key string, when time.Time, tags dagr.Tags, fields da
 		when = time.Now()
 	}
 
-	return dagr.WriteMeasurement(w, dagr.RawPoint{key, tags, fields, when})
+	return w.WriteMeasurement(dagr.RawPoint{Key: key, Tags: tags, Fields: fields, Time: when})
 }
 
 // Start creates a goroutine that POSTs buffered data at the given interval. If interval is not a positive duration, the
@@ -367,11 +387,13 @@ func (w *Proxy) swapAndSend(op flushop) {
 		return
 	}
 
+	w.beginSend()
 	go func() {
 		var err error
 		defer func() { op.reply(err) }()
 
 		err = w.sendData(op.ctx, data, w.retries)
+		w.endSend(len(data), err)
 	}()
 }
 
@@ -382,6 +404,15 @@ func (w *Proxy) sendData(ctx context.Context, data []byte, retries int) error {
 		err   error
 	)
 
+	if fn := w.encoding.Body; fn != nil {
+		body, err := fn(data)
+		if err != nil {
+			logf("Error encoding payload of size=%d for %s: %v", len(data), w.destURL.Host, err)
+			return err
+		}
+		data = body
+	}
+
 retryLoop:
 	for i := 0; i <= retries; i++ {
 		retry, err = w.send(ctx, bytes.NewReader(data))
@@ -441,7 +472,11 @@ func (w *Proxy) send(ctx context.Context, body *bytes.Reader) (retry bool, err e
 	}
 
 	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "")
+	contentType := w.encoding.ContentType
+	if contentType == "" && w.format == JSONLines {
+		contentType = "application/x-ndjson"
+	}
+	req.Header.Set("Content-Type", contentType)
 	if w.director != nil {
 		if err := w.director(req); err != nil {
 			return false, err
@@ -465,7 +500,7 @@ func (w *Proxy) send(ctx context.Context, body *bytes.Reader) (retry bool, err e
 
 	// Per InfluxDB docs (0.11-ish I think, but possibly earlier), anything other than 204,
 	// including status 200, is an error.
-	if resp.StatusCode != 204 {
+	if resp.StatusCode != w.encoding.status() {
 		var sterr = &BadStatusError{Code: resp.StatusCode}
 		sterr.Body, sterr.Err = ioutil.ReadAll(resp.Body)
 		return false, sterr // InfluxDB rejected the response, so discard it.
@@ -0,0 +1,57 @@
+package outflux
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.spiff.io/dagr"
+)
+
+func TestProxyJSONLines(t *testing.T) {
+	defer logtest(t)()
+
+	type request struct {
+		contentType string
+		body        string
+	}
+	requests := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests <- request{r.Header.Get("Content-Type"), string(b)}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	proxy := New(nil, srv.URL, JSONLines, DefaultTags{"host": "example.local"})
+	proxy.Start(ctx, 0)
+
+	when := time.Unix(1, 0)
+	proxy.WritePoint("event", when, dagr.Tags{"pid": "1234"}, dagr.Fields{"value": dagr.RawInt(1)})
+	proxy.WriteMeasurements(dagr.RawPoint{Key: "event", Fields: dagr.Fields{"value": dagr.RawInt(2)}, Time: when})
+
+	if err := proxy.Flush(ctx); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+
+	req := <-requests
+	want := `{"Key":"event","Timestamp":"1000000000","Tags":{"host":"example.local","pid":"1234"},"Fields":{"value":{"Type":"int","Value":1}}}` + "\n" +
+		`{"Key":"event","Timestamp":"1000000000","Tags":{"host":"example.local"},"Fields":{"value":{"Type":"int","Value":2}}}` + "\n"
+	if req.body != want {
+		t.Errorf("Expected ---\n%s---\n\nGot ---\n%s---", want, req.body)
+	}
+	if req.contentType != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q; want application/x-ndjson", req.contentType)
+	}
+
+	if st := proxy.Status(); st.Sent != 1 || st.SentBytes != uint64(len(want)) || st.Failed != 0 || st.Buffered != 0 ||
+		st.Sending != 0 || st.LastSent.IsZero() || st.URL != srv.URL {
+		t.Errorf("Status() = %+v", st)
+	}
+}
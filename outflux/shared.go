@@ -50,7 +50,7 @@ func WriteMeasurements(ms ...dagr.Measurement) (int64, error) {
 		return 0, nil
 	}
 
-	return dagr.WriteMeasurements(sharedWriter, ms...)
+	return sharedWriter.WriteMeasurements(ms...)
 }
 
 // WriteMeasurement writes a single dagr.Measurement to the shared outflux Proxy. It returns the
@@ -68,7 +68,7 @@ func WriteMeasurement(m dagr.Measurement) (int64, error) {
 		return 0, nil
 	}
 
-	return dagr.WriteMeasurement(sharedWriter, m)
+	return sharedWriter.WriteMeasurement(m)
 }
 
 // WritePoint writes a single point to the shared outflux Proxy. It returns the number of bytes
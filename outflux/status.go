@@ -0,0 +1,54 @@
+package outflux
+
+import "time"
+
+// Status describes a Proxy's buffer and its sends to InfluxDB, as returned by Proxy.Status.
+type Status struct {
+	URL      string // The destination URL, with any password redacted
+	Buffered int    // The number of bytes buffered and waiting to be sent
+	Sending  int    // The number of sends in progress
+
+	Sent      uint64    // The number of payloads sent successfully
+	SentBytes uint64    // The number of bytes sent successfully, before any encoding
+	Failed    uint64    // The number of payloads that failed to send after all retries
+	LastSent  time.Time // When the last payload was sent successfully
+
+	LastError     error     // The error of the last failed send, if any
+	LastErrorTime time.Time // When the last send failed
+}
+
+// Status returns the current status of the Proxy's buffer and sends.
+func (w *Proxy) Status() Status {
+	w.statusLock.Lock()
+	st := w.status
+	w.statusLock.Unlock()
+
+	st.URL = w.destURL.Redacted()
+	st.Buffered = w.buffer.Len()
+	return st
+}
+
+// beginSend records the start of a send.
+func (w *Proxy) beginSend() {
+	w.statusLock.Lock()
+	w.status.Sending++
+	w.statusLock.Unlock()
+}
+
+// endSend records the result of sending a payload of size bytes.
+func (w *Proxy) endSend(size int, err error) {
+	now := time.Now()
+
+	w.statusLock.Lock()
+	defer w.statusLock.Unlock()
+
+	w.status.Sending--
+	if err != nil {
+		w.status.Failed++
+		w.status.LastError, w.status.LastErrorTime = err, now
+		return
+	}
+	w.status.Sent++
+	w.status.SentBytes += uint64(size)
+	w.status.LastSent = now
+}
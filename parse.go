@@ -0,0 +1,382 @@
+package dagr
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyntaxError is returned when parsing line protocol fails. Line and Column are both 1-based, and Column is a byte
+// offset into the line rather than a rune offset.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("dagr: line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// errUnterminatedString is used internally to signal that a string field ran past the end of the input. When scanning
+// a stream, this causes the scanner to append the next line and try again, since strings may contain newlines.
+var errUnterminatedString = errors.New("unterminated string")
+
+// ParseLine parses a single line of line protocol and returns it as a RawPoint. Field values are returned as RawInt,
+// RawUint, RawFloat, RawBool, and RawString. If the line has no timestamp, the RawPoint's Time is zero.
+//
+// Trailing newlines are ignored. Blank lines and comments are considered errors, since they contain no point. If the
+// line cannot be parsed, the error returned is a *SyntaxError.
+func ParseLine(line []byte) (RawPoint, error) {
+	line = bytes.TrimRight(line, "\r\n")
+	p := lineParser{buf: line, line: 1}
+	if p.blank() {
+		return RawPoint{}, p.errorf(0, "line is empty")
+	}
+
+	pt, err := p.parse()
+	if err == errUnterminatedString {
+		err = p.errorf(p.pos, "unterminated string")
+	}
+	return pt, err
+}
+
+// LineScanner reads points from a stream of line protocol. Blank lines and lines beginning with '#' are skipped. It is
+// used in the same way as a bufio.Scanner:
+//
+//	s := dagr.ParseLines(r)
+//	for s.Scan() {
+//	        pt := s.Point()
+//	        // ...
+//	}
+//	if err := s.Err(); err != nil {
+//	        // ...
+//	}
+//
+// Scanning stops at the first error. Errors in the line protocol itself are returned as a *SyntaxError.
+type LineScanner struct {
+	r    *bufio.Reader
+	line int
+	pt   RawPoint
+	err  error
+	buf  []byte
+}
+
+// ParseLines returns a LineScanner that reads points from r.
+func ParseLines(r io.Reader) *LineScanner {
+	return &LineScanner{r: bufio.NewReader(r)}
+}
+
+// readLine reads a single physical line from the scanner's reader, including its trailing newline. It returns io.EOF
+// only if there is no more data to read.
+func (s *LineScanner) readLine(dst []byte) ([]byte, error) {
+	for {
+		chunk, err := s.r.ReadSlice('\n')
+		dst = append(dst, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err == io.EOF && len(dst) > 0 {
+			return dst, nil
+		}
+		return dst, err
+	}
+}
+
+// Scan advances the scanner to the next point. It returns false when there are no more points or an error occurred.
+func (s *LineScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+
+	for {
+		var err error
+		s.buf, err = s.readLine(s.buf[:0])
+		if err != nil {
+			s.err = err
+			return false
+		}
+		s.line++
+
+		first := s.line
+		for {
+			p := lineParser{buf: bytes.TrimRight(s.buf, "\r\n"), line: first}
+			if p.blank() {
+				break
+			}
+
+			pt, err := p.parse()
+			if err == errUnterminatedString {
+				// Strings may span multiple lines, so try again with the next line included.
+				var rerr error
+				if s.buf, rerr = s.readLine(s.buf); rerr == nil {
+					s.line++
+					continue
+				} else if rerr != io.EOF {
+					s.err = rerr
+					return false
+				}
+				err = p.errorf(p.pos, "unterminated string")
+			}
+
+			if err != nil {
+				s.err = err
+				return false
+			}
+
+			s.pt = pt
+			return true
+		}
+	}
+}
+
+// Point returns the point most recently parsed by Scan.
+func (s *LineScanner) Point() RawPoint {
+	return s.pt
+}
+
+// Err returns the first non-EOF error that occurred while scanning.
+func (s *LineScanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// lineParser parses a single point from buf. Since strings may contain newlines, buf may span multiple physical lines,
+// in which case line is the number of the first.
+type lineParser struct {
+	buf  []byte
+	pos  int
+	line int
+}
+
+// errorf returns a *SyntaxError for the byte at offset pos in the parser's buffer.
+func (p *lineParser) errorf(pos int, format string, args ...interface{}) *SyntaxError {
+	line, col := p.line, pos+1
+	if i := bytes.LastIndexByte(p.buf[:pos], '\n'); i != -1 {
+		line += bytes.Count(p.buf[:pos], []byte{'\n'})
+		col = pos - i
+	}
+	return &SyntaxError{Line: line, Column: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *lineParser) skipSpace() {
+	for p.pos < len(p.buf) && (p.buf[p.pos] == ' ' || p.buf[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// blank returns whether the parser's line is empty or a comment.
+func (p *lineParser) blank() bool {
+	p.skipSpace()
+	return p.pos == len(p.buf) || p.buf[p.pos] == '#'
+}
+
+func (p *lineParser) peek() byte {
+	if p.pos < len(p.buf) {
+		return p.buf[p.pos]
+	}
+	return 0
+}
+
+// Escapable characters for each position, as defined by line protocol. A backslash before any other character,
+// including another backslash, is kept as-is.
+const (
+	keyEscapes   = ", "
+	tagEscapes   = ",= " // Tag names and values
+	fieldEscapes = ",= " // Field names
+)
+
+// ident scans a key, tag name, tag value, or field name up to the first unescaped byte in stop or the end of the line.
+// A backslash followed by a byte in escapes is unescaped. Any other backslash is kept as-is.
+func (p *lineParser) ident(stop, escapes string) string {
+	start, escaped := p.pos, false
+	for p.pos < len(p.buf) {
+		c := p.buf[p.pos]
+		if c == '\\' && p.pos+1 < len(p.buf) && strings.IndexByte(escapes, p.buf[p.pos+1]) != -1 {
+			escaped = true
+			p.pos += 2
+			continue
+		} else if c == '\n' || c == '\t' || strings.IndexByte(stop, c) != -1 {
+			break
+		}
+		p.pos++
+	}
+
+	raw := p.buf[start:p.pos]
+	if !escaped {
+		return string(raw)
+	}
+
+	out := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '\\' && i+1 < len(raw) && strings.IndexByte(escapes, raw[i+1]) != -1 {
+			i++
+		}
+		out = append(out, raw[i])
+	}
+	return string(out)
+}
+
+func (p *lineParser) parse() (pt RawPoint, err error) {
+	p.skipSpace()
+
+	start := p.pos
+	if pt.Key = p.ident(", ", keyEscapes); pt.Key == "" {
+		return pt, p.errorf(start, "missing measurement key")
+	}
+
+	for p.peek() == ',' {
+		p.pos++
+		start := p.pos
+		name := p.ident(",= ", tagEscapes)
+		if name == "" {
+			return pt, p.errorf(start, "missing tag name")
+		} else if p.peek() != '=' {
+			return pt, p.errorf(p.pos, "expected '=' after tag name %q", name)
+		}
+		p.pos++
+
+		start = p.pos
+		value := p.ident(", ", tagEscapes)
+		if value == "" {
+			return pt, p.errorf(start, "missing value for tag %q", name)
+		}
+
+		if pt.Tags == nil {
+			pt.Tags = make(Tags)
+		}
+		pt.Tags[name] = value
+	}
+
+	if p.peek() != ' ' {
+		return pt, p.errorf(p.pos, "expected space before fields")
+	}
+	p.skipSpace()
+
+	pt.Fields = make(Fields)
+	for {
+		start := p.pos
+		name := p.ident(",= ", fieldEscapes)
+		if name == "" {
+			return pt, p.errorf(start, "missing field name")
+		} else if p.peek() != '=' {
+			return pt, p.errorf(p.pos, "expected '=' after field name %q", name)
+		}
+		p.pos++
+
+		value, err := p.fieldValue(name)
+		if err != nil {
+			return pt, err
+		}
+		pt.Fields[name] = value
+
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+
+	if p.pos == len(p.buf) {
+		return pt, nil
+	} else if c := p.peek(); c != ' ' && c != '\t' {
+		return pt, p.errorf(p.pos, "unexpected %q after fields", c)
+	}
+
+	p.skipSpace()
+	if p.pos == len(p.buf) {
+		return pt, nil
+	}
+
+	start = p.pos
+	for p.pos < len(p.buf) && p.buf[p.pos] != ' ' && p.buf[p.pos] != '\t' {
+		p.pos++
+	}
+	ts, perr := strconv.ParseInt(string(p.buf[start:p.pos]), 10, 64)
+	if perr != nil {
+		return pt, p.errorf(start, "invalid timestamp %q", p.buf[start:p.pos])
+	}
+	pt.Time = time.Unix(0, ts)
+
+	if p.skipSpace(); p.pos != len(p.buf) {
+		return pt, p.errorf(p.pos, "unexpected %q after timestamp", p.peek())
+	}
+
+	return pt, nil
+}
+
+// fieldValue parses a single field value, with the parser positioned immediately after the field's '='.
+func (p *lineParser) fieldValue(name string) (Field, error) {
+	start := p.pos
+	if p.peek() == '"' {
+		return p.stringValue()
+	}
+
+	for p.pos < len(p.buf) {
+		if c := p.buf[p.pos]; c == ',' || c == ' ' || c == '\t' {
+			break
+		}
+		p.pos++
+	}
+
+	tok := string(p.buf[start:p.pos])
+	switch tok {
+	case "":
+		return nil, p.errorf(start, "missing value for field %q", name)
+	case "t", "T", "true", "True", "TRUE":
+		return RawBool(true), nil
+	case "f", "F", "false", "False", "FALSE":
+		return RawBool(false), nil
+	}
+
+	if c := tok[0]; c != '-' && c != '+' && c != '.' && (c < '0' || c > '9') {
+		return nil, p.errorf(start, "invalid value %q for field %q", tok, name)
+	}
+
+	switch last := len(tok) - 1; tok[last] {
+	case 'i':
+		if n, err := strconv.ParseInt(tok[:last], 10, 64); err == nil {
+			return RawInt(n), nil
+		}
+		return nil, p.errorf(start, "invalid integer %q for field %q", tok, name)
+	case 'u':
+		if n, err := strconv.ParseUint(tok[:last], 10, 64); err == nil {
+			return RawUint(n), nil
+		}
+		return nil, p.errorf(start, "invalid unsigned integer %q for field %q", tok, name)
+	}
+
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return RawFloat(f), nil
+	}
+	return nil, p.errorf(start, "invalid float %q for field %q", tok, name)
+}
+
+// stringValue parses a quoted string field, unescaping any escaped quotes. Other backslashes are kept as-is, matching
+// how strings are written.
+func (p *lineParser) stringValue() (Field, error) {
+	p.pos++ // Opening quote
+
+	var out []byte
+	for start := p.pos; p.pos < len(p.buf); p.pos++ {
+		switch p.buf[p.pos] {
+		case '\\':
+			if p.pos+1 < len(p.buf) && p.buf[p.pos+1] == '"' {
+				out = append(out, p.buf[start:p.pos]...)
+				p.pos++
+				start = p.pos
+			}
+		case '"':
+			out = append(out, p.buf[start:p.pos]...)
+			p.pos++
+			return RawString(out), nil
+		}
+	}
+
+	return nil, errUnterminatedString
+}
@@ -0,0 +1,215 @@
+package dagr
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	cases := []struct {
+		line string
+		want RawPoint
+	}{
+		{
+			`cpu value=1`,
+			RawPoint{Key: "cpu", Fields: Fields{"value": RawFloat(1)}},
+		},
+		{
+			`cpu,host=example.local,region=us-west value=0.64,count=12i,total=18446744073709551615u 1136214245000000000`,
+			RawPoint{
+				Key:  "cpu",
+				Tags: Tags{"host": "example.local", "region": "us-west"},
+				Fields: Fields{
+					"value": RawFloat(0.64),
+					"count": RawInt(12),
+					"total": RawUint(18446744073709551615),
+				},
+				Time: testTime,
+			},
+		},
+		{
+			`system\ load\,avg,tag\ name=tag\=value field\,name=-1.5e3 -1`,
+			RawPoint{
+				Key:    "system load,avg",
+				Tags:   Tags{"tag name": "tag=value"},
+				Fields: Fields{"field,name": RawFloat(-1500)},
+				Time:   time.Unix(0, -1),
+			},
+		},
+		{
+			// Backslashes are only escapes before the characters escaped in each position
+			`path\\to\=x,dir=C:\\tmp\ x,a\=b=c f\\g=1`,
+			RawPoint{
+				Key:    `path\\to\=x`,
+				Tags:   Tags{"dir": `C:\\tmp x`, "a=b": "c"},
+				Fields: Fields{`f\\g`: RawFloat(1)},
+			},
+		},
+		{
+			`flags a=t,b=T,c=true,d=True,e=TRUE,f=f,g=F,h=false,i=False,j=FALSE`,
+			RawPoint{
+				Key: "flags",
+				Fields: Fields{
+					"a": RawBool(true), "b": RawBool(true), "c": RawBool(true), "d": RawBool(true), "e": RawBool(true),
+					"f": RawBool(false), "g": RawBool(false), "h": RawBool(false), "i": RawBool(false), "j": RawBool(false),
+				},
+			},
+		},
+		{
+			`event msg="a \"string\", with = spaces",path="C:\dir\n"` + "\r\n",
+			RawPoint{
+				Key: "event",
+				Fields: Fields{
+					"msg":  RawString(`a "string", with = spaces`),
+					"path": RawString(`C:\dir\n`),
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		got, err := ParseLine([]byte(c.line))
+		if err != nil {
+			t.Errorf("ParseLine(%q): unexpected error: %v", c.line, err)
+			continue
+		}
+
+		if !got.Time.Equal(c.want.Time) {
+			t.Errorf("ParseLine(%q).Time = %v; want %v", c.line, got.Time, c.want.Time)
+		}
+		got.Time, c.want.Time = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseLine(%q) =\n%#v\nwant\n%#v", c.line, got, c.want)
+		}
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	cases := []struct {
+		line   string
+		column int
+	}{
+		{``, 1},
+		{`# comment`, 1},
+		{`,host=a value=1`, 1},
+		{`cpu`, 4},
+		{`cpu,host value=1`, 9},
+		{`cpu,host= value=1`, 10},
+		{`cpu value`, 10},
+		{`cpu value=`, 11},
+		{`cpu value=abc`, 11},
+		{`cpu value=12ai`, 11},
+		{`cpu value=-1u`, 11},
+		{`cpu value="unterminated`, 24},
+		{`cpu value=1 12x`, 13},
+		{`cpu value=1 12 13`, 16},
+	}
+
+	for _, c := range cases {
+		_, err := ParseLine([]byte(c.line))
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("ParseLine(%q): error = %#v; want *SyntaxError", c.line, err)
+			continue
+		}
+
+		if serr.Line != 1 || serr.Column != c.column {
+			t.Errorf("ParseLine(%q): error at %d:%d; want 1:%d (%v)", c.line, serr.Line, serr.Column, c.column, err)
+		}
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	const input = "# A comment\n" +
+		"\n" +
+		"first value=1i 1\n" +
+		"second msg=\"multiple\nlines\" 2\n" +
+		"  \t\n" +
+		"third value=3i 3"
+
+	s := ParseLines(strings.NewReader(input))
+	var keys []string
+	for s.Scan() {
+		pt := s.Point()
+		keys = append(keys, pt.Key)
+		if pt.Key == "second" && pt.Fields["msg"] != RawString("multiple\nlines") {
+			t.Errorf("second.msg = %q; want %q", pt.Fields["msg"], "multiple\nlines")
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		t.Fatalf("Err() = %v; want nil", err)
+	}
+
+	if want := []string{"first", "second", "third"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %q; want %q", keys, want)
+	}
+}
+
+func TestParseLinesError(t *testing.T) {
+	const input = "first value=1i 1\n" +
+		"second msg=\"multiple\nlines\" 2\n" +
+		"third value=3x 3\n" +
+		"fourth value=4i 4\n"
+
+	s := ParseLines(strings.NewReader(input))
+	n := 0
+	for s.Scan() {
+		n++
+	}
+
+	if n != 2 {
+		t.Errorf("scanned %d points; want 2", n)
+	}
+
+	serr, ok := s.Err().(*SyntaxError)
+	if !ok {
+		t.Fatalf("Err() = %#v; want *SyntaxError", s.Err())
+	}
+
+	if serr.Line != 4 || serr.Column != 13 {
+		t.Errorf("error at %d:%d; want 4:13 (%v)", serr.Line, serr.Column, serr)
+	}
+}
+
+func TestParseLineRoundTrip(t *testing.T) {
+	defer prepareLogger(t)()
+
+	integer := new(Int)
+	boolean := new(Bool)
+	float := new(Float)
+	str := new(String)
+
+	integer.Set(123)
+	boolean.Set(true)
+	float.Set(123.456)
+	str.Set(`a "string" of sorts in C:\dir`)
+
+	m := NewPoint(
+		"service.some event",
+		Tags{"pid": "1234", "host name": "example,local"},
+		Fields{"value": integer, "depth": float, "on": boolean, "msg": str, "count": RawUint(7)},
+	)
+
+	var first bytes.Buffer
+	if _, err := WriteMeasurement(&first, m); err != nil {
+		t.Fatal(err)
+	}
+
+	pt, err := ParseLine(first.Bytes())
+	if err != nil {
+		t.Fatalf("ParseLine(%q): %v", first.String(), err)
+	}
+
+	var second bytes.Buffer
+	if _, err := WriteMeasurement(&second, pt); err != nil {
+		t.Fatal(err)
+	}
+
+	if first.String() != second.String() {
+		t.Errorf("Round trip mismatch:\nwrote  %q\nparsed %q", first.String(), second.String())
+	}
+}
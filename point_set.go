@@ -2,6 +2,7 @@ package dagr
 
 import (
 	"io"
+	"sort"
 	"sync"
 )
 
@@ -36,10 +37,10 @@ type StaticPointAllocator struct {
 func (s StaticPointAllocator) AllocatePoint(identifier string, _ interface{}) (key string, tags Tags, fields Fields) {
 	tags = s.Tags
 	if s.IdentifierTag != "" {
+		tags = s.Tags.Dup()
 		if tags == nil {
 			tags = make(Tags, 1)
 		}
-		tags = s.Tags.Dup()
 		tags[s.IdentifierTag] = identifier
 	}
 
@@ -62,24 +63,52 @@ func (fn PointAllocFunc) AllocatePoint(identifier string, opaque interface{}) (k
 	return fn(identifier, opaque)
 }
 
-type taggedMetric struct {
-	Measurement
-	fields Fields
-}
-
-func (t taggedMetric) GetFields() Fields {
-	return t.fields.Dup(false)
-}
-
 // PointSet is a simple collection of (compiled) points that supports dynamic allocation and revocation of points. It is
 // intended for situations where you have a single point form varying across a tag or field, such as a request path or
 // some other varying data.
+//
+// Each point is held as a CompiledPoint, so changes made to a point through PointForID (e.g., setting a tag on it) are
+// picked up the next time it's written.
+//
+// By default, a PointSet holds as many points as it's given identifiers. To bound the number of points it holds, use
+// SetLimit. Points are written in no particular order unless the PointSet is sorted with SetSorted.
 type PointSet struct {
+	tick uint64 // Accessed atomically; incremented each time a point is used while the PointSet is limited
+
 	allocator PointAllocator
-	m         sync.RWMutex // controls metrics
-	metrics   map[string]taggedMetric
+	expiring  sync.Mutex   // held by Expire
+	m         sync.RWMutex // controls metrics, limit, and other
+	metrics   map[string]*pointSetEntry
+
+	sorted     bool
+	limit      PointSetLimit
+	expiry     PointSetExpiry
+	rollup     PointSetRollup
+	other      *CompiledPoint
+	candidates map[string]struct{} // New identifiers redirected to other that may be admitted if seen again
+	removed    Fields              // Summed fields of points removed while the PointSet had a rollup
+	evictions  Int
+	rejections Int
+
+	ordering sync.Mutex // controls order while the PointSet is locked for reading
+	order    []string   // The PointSet's identifiers in ascending order, or nil if they need sorting
 }
 
+// pointSetEntry is a point held by a PointSet along with how it's been used, for eviction.
+type pointSetEntry struct {
+	used     uint64 // Accessed atomically; the PointSet's tick when the point was last used
+	hits     uint64 // Accessed atomically; the number of times the point was used
+	accessed int64  // Accessed atomically; the clock time, in nanoseconds, when the point was last used
+
+	// Only accessed by Expire, while holding the PointSet's expiring lock, or while the PointSet is locked for writing
+	version uint64 // The point's version as of the last call to Expire or when it was allocated
+	seq     uint64 // The point's Update sequence as of the last call to Expire or when it was allocated
+
+	*CompiledPoint
+}
+
+var _ = MeasurementSet((*PointSet)(nil))
+
 // NewPointSet allocates a new PointSet with the given allocator. If allocator is nil, the function panics with
 // ErrNoAllocator. You shouldn't bother recovering from this because there is no recovering from a PointSet without an
 // allocator.
@@ -90,7 +119,7 @@ func NewPointSet(allocator PointAllocator) *PointSet {
 
 	return &PointSet{
 		allocator: allocator,
-		metrics:   make(map[string]taggedMetric),
+		metrics:   make(map[string]*pointSetEntry),
 	}
 }
 
@@ -98,70 +127,129 @@ func (p *PointSet) delete(ident string) {
 	p.m.Lock()
 	defer p.m.Unlock()
 
+	if e, ok := p.metrics[ident]; ok {
+		p.remove(ident, e)
+	}
+	delete(p.candidates, ident)
+}
+
+// remove removes the point e held for ident, retaining its summed fields for the rollup. The PointSet must be locked
+// for writing.
+func (p *PointSet) remove(ident string, e *pointSetEntry) {
+	p.retain(e.CompiledPoint)
 	delete(p.metrics, ident)
+	p.order = nil
 }
 
-// alloc allocates a new point and stores it in the PointSet, returning a copy of the taggedMetric and whether the
-// allocation was successful. It will always check, first, whether the point was allocated prior to the lock being
-// acquired (i.e., if an alloc for the same identifier was waiting elsewhere) and return that if one was found.
+// alloc allocates a new point and stores it in the PointSet, returning the point and whether the allocation was
+// successful. It will always check, first, whether the point was allocated prior to the lock being acquired (i.e., if
+// an alloc for the same identifier was waiting elsewhere) and return that if one was found.
 //
 // It is possible to significantly degrate PointSet performance by using an allocator that returns an empty key or
 // fields map for frequently used identifiers. In that case, it will always take a write lock and fail each time the
 // allocator returns nil. This isn't a bug, but it is something to consider when writing allocators.
-func (p *PointSet) alloc(ident string, opaque interface{}) (m taggedMetric, ok bool) {
+//
+// If the PointSet is limited and full, alloc either evicts a point to make room for the new one or redirects the
+// identifier to the PointSet's other point, depending on its policy (see admit).
+func (p *PointSet) alloc(ident string, opaque interface{}) (m *CompiledPoint, ok bool) {
 	p.m.Lock()
 	defer p.m.Unlock()
 
-	if metric, ok := p.metrics[ident]; ok {
-		return metric, true
+	if e, ok := p.metrics[ident]; ok {
+		p.touch(e)
+		return e.CompiledPoint, true
+	}
+
+	if p.full() && !p.admit(ident) {
+		p.rejections.Add(1)
+		return p.other, p.other != nil
 	}
 
 	key, tags, fields := p.allocator.AllocatePoint(ident, opaque)
 	if key == "" || len(fields) == 0 {
-		return m, false
+		return nil, false
 	}
 
-	pt := NewPoint(key, tags, fields)
-	compiled := pt.Compiled()
-	if compiled == nil {
-		return m, false
+	for p.full() {
+		p.evict()
 	}
 
-	m = taggedMetric{
-		Measurement: compiled,
-		fields:      pt.GetFields(),
-	}
-	p.metrics[ident] = m
+	// Idle time is counted from allocation, so points that are never used still expire
+	e := &pointSetEntry{CompiledPoint: NewPoint(key, tags, fields).Linked(), accessed: clock.Now().UnixNano()}
+	e.changed()
+	p.touch(e)
+	p.metrics[ident] = e
+	p.order = nil
 
-	return m, true
+	return e.CompiledPoint, true
 }
 
-// lookup tries to find an existing metric for ident. If one is found, it returns the taggedMetric and true (otherwise,
-// nothing and false).
+// lookup tries to find an existing metric for ident. If one is found, it returns the point and true (otherwise, nil
+// and false).
 //
 // lookup takes a read lock on the PointSet. This is the common case when getting a metric out of the PointSet.
-func (p *PointSet) lookup(ident string) (m taggedMetric, ok bool) {
+//
+// If the PointSet is full and rejects new identifiers, lookup returns the PointSet's other point for unknown
+// identifiers instead of leaving them to alloc, so that rejected identifiers don't take a write lock.
+func (p *PointSet) lookup(ident string) (m *CompiledPoint, ok bool) {
 	p.m.RLock()
 	defer p.m.RUnlock()
 
-	if m, ok := p.metrics[ident]; ok {
-		return m, true
+	if e, ok := p.metrics[ident]; ok {
+		p.touch(e)
+		return e.CompiledPoint, true
+	} else if p.other != nil && p.full() && p.limit.Policy == RejectNew {
+		p.rejections.Add(1)
+		return p.other, true
 	}
+	return nil, false
+}
 
-	return taggedMetric{}, false
+// get returns the point for ident, allocating it if necessary.
+func (p *PointSet) get(ident string, opaque interface{}) (m *CompiledPoint, ok bool) {
+	if m, ok := p.lookup(ident); ok {
+		return m, true
+	}
+	return p.alloc(ident, opaque)
 }
 
 // FieldsForID returns the fields for a particular identifier. If no point is found and no point can be allocated for
 // the identifier, it returns nil. The identifier may be an empty string.
 func (p *PointSet) FieldsForID(identifier string, opaque interface{}) Fields {
-	if m, ok := p.lookup(identifier); ok {
-		return m.GetFields()
+	if m, ok := p.get(identifier, opaque); ok {
+		return m.Point().GetFields()
 	}
+	return nil
+}
 
-	if m, ok := p.alloc(identifier, opaque); ok {
-		return m.GetFields()
+// fieldsForRestore returns the fields for identifier the same as FieldsForID with a nil opaque value, and whether they
+// are the fields of the PointSet's other point.
+func (p *PointSet) fieldsForRestore(identifier string) (Fields, bool) {
+	m, ok := p.get(identifier, nil)
+	if !ok {
+		return nil, false
 	}
 
+	p.m.RLock()
+	other := m == p.other
+	p.m.RUnlock()
+	return m.Point().GetFields(), other
+}
+
+// otherPoint returns the PointSet's other point, or nil if it has none.
+func (p *PointSet) otherPoint() *CompiledPoint {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.other
+}
+
+// PointForID returns the point for a particular identifier, allocating it the same as FieldsForID if necessary. If no
+// point is found and no point can be allocated for the identifier, it returns nil. Changes to the point's key, tags,
+// and fields are reflected when the PointSet is next written.
+func (p *PointSet) PointForID(identifier string, opaque interface{}) *Point {
+	if m, ok := p.get(identifier, opaque); ok {
+		return m.Point()
+	}
 	return nil
 }
 
@@ -173,31 +261,176 @@ func (p *PointSet) Clear() {
 	// Retain current length as new capacity, since presumably we'll end up with the same -- you can obviously
 	// double-clear to completely zero out the initial capacity.
 	capacity := len(p.metrics)
-	p.metrics = make(map[string]taggedMetric, capacity)
+	for _, e := range p.metrics {
+		p.retain(e.CompiledPoint)
+	}
+	p.metrics = make(map[string]*pointSetEntry, capacity)
+	p.candidates = nil
+	p.order = nil
 }
 
 func (p *PointSet) Remove(identifier string) {
 	p.delete(identifier)
 }
 
+// SetSorted sets whether the PointSet writes its points in ascending order by identifier, both when it's written
+// directly and when its Measurements are written, such as by an Encoder with DefaultTags. Sorting the points makes
+// output stable between writes, such as for tests, at the cost of sorting identifiers on the first write after points
+// are added or removed. Either way, the PointSet's other point, if it has one, is written last.
+func (p *PointSet) SetSorted(sorted bool) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.sorted = sorted
+}
+
+// Len returns the number of points held by the PointSet, not counting its other point.
+func (p *PointSet) Len() int {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return len(p.metrics)
+}
+
+// IDs returns the identifiers of the points held by the PointSet in ascending order.
+func (p *PointSet) IDs() []string {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return append([]string(nil), p.ids()...)
+}
+
+// ids returns the PointSet's identifiers in ascending order. The order is cached until a point is added or removed, so
+// the slice returned is shared and must not be modified. The PointSet must be locked.
+func (p *PointSet) ids() []string {
+	p.ordering.Lock()
+	defer p.ordering.Unlock()
+
+	if p.order == nil {
+		ids := make([]string, 0, len(p.metrics))
+		for id := range p.metrics {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		p.order = ids
+	}
+	return p.order
+}
+
+// Each calls fn with the identifier and point of each point held by the PointSet, in ascending order by identifier,
+// until fn returns false. Each point is a CompiledPoint. Calling Each does not count as a use of the points for the
+// PointSet's limit or expiry. fn must not modify the PointSet.
+func (p *PointSet) Each(fn func(id string, m Measurement) bool) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	for _, id := range p.ids() {
+		if !fn(id, p.metrics[id].CompiledPoint) {
+			return
+		}
+	}
+}
+
+// Snapshots returns snapshots of the points held by the PointSet in ascending order by identifier, followed by its
+// other point and rollup point, if it has them. Points without fields are omitted. All of the snapshots share the same
+// time.
+func (p *PointSet) Snapshots() []TimeMeasurement {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	now := clock.Now()
+	snaps := make([]TimeMeasurement, 0, len(p.metrics)+1)
+	add := func(m *CompiledPoint) {
+		if c := m.current(); len(c.fields) > 0 {
+			snaps = append(snaps, c.snapshotAt(now))
+		}
+	}
+
+	for _, id := range p.ids() {
+		add(p.metrics[id].CompiledPoint)
+	}
+	if p.other != nil {
+		add(p.other)
+	}
+	if rollup, ok := p.computeRollup(now); ok {
+		snaps = append(snaps, rollup)
+	}
+	return snaps
+}
+
+// Measurements returns the points currently held by the PointSet as CompiledPoints, followed by its other point if it
+// has one. Their fields are those held by the PointSet. If the PointSet is sorted, the points are in ascending order by
+// identifier. If the PointSet has a rollup, a snapshot of the rollup point is last.
+func (p *PointSet) Measurements() []Measurement {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	ms := make([]Measurement, 0, len(p.metrics)+2)
+	if p.sorted {
+		for _, id := range p.ids() {
+			ms = append(ms, p.metrics[id].CompiledPoint)
+		}
+	} else {
+		for _, e := range p.metrics {
+			ms = append(ms, e.CompiledPoint)
+		}
+	}
+	if p.other != nil {
+		ms = append(ms, p.other)
+	}
+	if rollup, ok := p.computeRollup(clock.Now()); ok {
+		ms = append(ms, rollup)
+	}
+	return ms
+}
+
 func (p *PointSet) WriteTo(w io.Writer) (int64, error) {
-	buf := getBuffer(w)
-	defer putBuffer(buf)
+	bp := getScratch()
+	b, err := p.appendTagged(*bp, nil, nil)
+	defer putScratch(bp, b)
+	return writeScratch(w, b, err)
+}
 
+// appendTagged appends each of the PointSet's points with fields to dst, followed by its other point and rollup point.
+// If the PointSet is sorted, points are appended in ascending order by identifier.
+func (p *PointSet) appendTagged(dst []byte, defaults Tags, defaultOrder []string) ([]byte, error) {
 	p.m.RLock()
 	defer p.m.RUnlock()
 
-	for _, m := range p.metrics {
-		head := buf.Len()
-		if _, err := WriteMeasurement(buf, m.Measurement); err == ErrNoFields {
-			buf.Truncate(head)
+	appendPoint := func(m *CompiledPoint) error {
+		b, err := m.appendTagged(dst, defaults, defaultOrder)
+		if err == ErrNoFields {
+			return nil
 		} else if err != nil {
-			buf.Truncate(int(buf.head))
-			return 0, err
+			dst = b
+			return err
+		}
+		dst = b
+		return nil
+	}
+
+	if p.sorted {
+		for _, id := range p.ids() {
+			if err := appendPoint(p.metrics[id].CompiledPoint); err != nil {
+				return dst, err
+			}
+		}
+	} else {
+		for _, e := range p.metrics {
+			if err := appendPoint(e.CompiledPoint); err != nil {
+				return dst, err
+			}
 		}
 	}
 
-	return buf.WriteTo(w)
+	if p.other != nil {
+		if err := appendPoint(p.other); err != nil {
+			return dst, err
+		}
+	}
+
+	if rollup, ok := p.computeRollup(clock.Now()); ok {
+		return appendMeasurement(dst, rollup, defaults, defaultOrder)
+	}
+
+	return dst, nil
 }
 
 // The following prevents the PointSet from looking like a valid point to anything but WriteMeasurement(s), since
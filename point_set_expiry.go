@@ -0,0 +1,132 @@
+package dagr
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// PointSetExpiry controls when a PointSet removes points that haven't been used. A point is used when it's allocated,
+// each time its fields or point are requested through FieldsForID or PointForID, and when Expire finds that it has
+// changed since the last time Expire ran. Idle times are measured using the package clock.
+type PointSetExpiry struct {
+	// Idle is how long a point may go unused before it's removed. If Idle is <= 0, points do not expire.
+	Idle time.Duration
+
+	// Final, if not nil, receives each expired point one last time before it's removed, so that updates made since
+	// the PointSet was last written aren't lost.
+	Final MeasurementWriter
+}
+
+// SetExpiry sets when the PointSet removes idle points. Points are only removed when Expire is called, either directly
+// or by StartExpiring. Points already in the PointSet are considered used as of the call to SetExpiry.
+func (p *PointSet) SetExpiry(expiry PointSetExpiry) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.expiry = expiry
+	now := clock.Now().UnixNano()
+	for _, e := range p.metrics {
+		atomic.StoreInt64(&e.accessed, now)
+	}
+}
+
+// Expire removes points that have been idle longer than the PointSet's expiry allows and returns the number of points
+// removed. If the expiry has a Final writer, the removed points are written to it before Expire returns. Points that
+// have changed since the last call to Expire are considered used, even if their fields weren't requested through the
+// PointSet, so that points updated through a retained reference don't expire.
+//
+// A point has changed if its key, tags, or set of fields were modified, or if it was updated with Point.Update. Field
+// values aren't sampled, so fields modified directly, rather than through Update, don't count as a change. To keep a
+// point that's updated through a retained reference from expiring, retain the point from PointForID and modify its
+// fields with Update.
+//
+// Points are checked while the PointSet is locked for reading, so only removing idle points blocks other uses of it.
+func (p *PointSet) Expire() int {
+	p.expiring.Lock()
+	defer p.expiring.Unlock()
+
+	p.m.RLock()
+	if p.expiry.Idle <= 0 {
+		p.m.RUnlock()
+		return 0
+	}
+
+	var (
+		now    = clock.Now().UnixNano()
+		cutoff = now - int64(p.expiry.Idle)
+		idle   []string
+	)
+
+	for ident, e := range p.metrics {
+		if e.changed() {
+			atomic.StoreInt64(&e.accessed, now)
+		} else if atomic.LoadInt64(&e.accessed) <= cutoff {
+			idle = append(idle, ident)
+		}
+	}
+	p.m.RUnlock()
+
+	if len(idle) == 0 {
+		return 0
+	}
+
+	var expired []Measurement
+	p.m.Lock()
+	final := p.expiry.Final
+	for _, ident := range idle {
+		// Points used since they were checked are kept
+		e, ok := p.metrics[ident]
+		if !ok || atomic.LoadInt64(&e.accessed) > cutoff {
+			continue
+		}
+
+		p.remove(ident, e)
+		expired = append(expired, e.CompiledPoint)
+	}
+	p.m.Unlock()
+
+	if final != nil && len(expired) > 0 {
+		if _, err := final.WriteMeasurements(expired...); err != nil {
+			Log.Printf("dagr: error writing %d expired points: %v", len(expired), err)
+		}
+	}
+
+	return len(expired)
+}
+
+// StartExpiring creates a goroutine that calls Expire at the given interval until ctx is done. The interval should be
+// shorter than the PointSet's idle duration, as points are only removed at each interval.
+//
+// The context may not be nil.
+func (p *PointSet) StartExpiring(ctx context.Context, interval time.Duration) {
+	if ctx == nil {
+		panic("dagr: context is nil")
+	} else if interval <= 0 {
+		panic("dagr: interval must be > 0")
+	}
+
+	ticker := newTicker(interval)
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.Chan():
+				p.Expire()
+			}
+		}
+	}()
+}
+
+// changed returns whether the entry's point has changed since the last call to changed (see Expire), using the point's
+// version and Update sequence. It must only be called by Expire or while the PointSet is locked for writing.
+func (e *pointSetEntry) changed() bool {
+	p := e.Point()
+	version, seq := p.Version(), p.seq.begin()
+	changed := version != e.version || seq != e.seq
+	e.version, e.seq = version, seq
+	return changed
+}
@@ -0,0 +1,179 @@
+package dagr
+
+import "sync/atomic"
+
+// EvictionPolicy controls what a limited PointSet does with a new identifier once it's full.
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the point that was least recently used to make room for a new one.
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the point that was least frequently used to make room for a new one. Ties are broken by
+	// evicting the least recently used point.
+	EvictLFU
+	// RejectNew keeps the points the PointSet already holds and redirects new identifiers to its other point.
+	RejectNew
+)
+
+// EvictionsField and RejectionsField are the names of the fields a limited PointSet adds to its other point to count
+// the points it has evicted and the identifiers it has redirected to the other point or refused.
+const (
+	EvictionsField  = "evictions"
+	RejectionsField = "rejections"
+)
+
+// PointSetLimit bounds the number of points a PointSet holds. A point is used each time its fields or point are
+// requested through FieldsForID or PointForID.
+type PointSetLimit struct {
+	// Max is the maximum number of points the PointSet holds, not counting Other. If Max is <= 0, the PointSet is
+	// unlimited.
+	Max int
+
+	// Policy is what to do with new identifiers once the PointSet holds Max points.
+	Policy EvictionPolicy
+
+	// Other, if not nil, is the point that new identifiers are redirected to once the PointSet is full. Its fields
+	// are returned by FieldsForID for redirected identifiers, and it's written along with the PointSet's other
+	// points.
+	//
+	// Under RejectNew, every new identifier is redirected to Other. Under EvictLRU and EvictLFU, a new identifier is
+	// redirected to Other the first time it's seen while the PointSet is full, and a point is only evicted to make
+	// room for it if it's seen again. This keeps identifiers that are only seen once, such as paths requested by a
+	// crawler, from evicting points that are in use.
+	//
+	// If Other is nil, EvictLRU and EvictLFU always evict a point to make room for a new identifier, and identifiers
+	// rejected under RejectNew get no fields, as if the allocator had refused them.
+	Other *Point
+}
+
+// SetLimit sets the limit on the number of points the PointSet holds. If the PointSet already holds more than
+// limit.Max points and its policy evicts points, points are evicted until it holds limit.Max points. Setting a limit
+// with Max <= 0 removes the limit.
+//
+// Evictions and rejections are counted by the fields returned by Evictions and Rejections. If limit.Other is set, they
+// are added to it as the fields EvictionsField and RejectionsField, unless it already has fields with those names, so
+// that they're written with it:
+//
+//	ps := NewPointSet(allocator)
+//	ps.SetLimit(PointSetLimit{
+//		Max:    1000,
+//		Policy: RejectNew,
+//		Other:  NewPoint("http_request", Tags{"path": "other"}, Fields{"count": new(Int)}),
+//	})
+//
+// Without an Other point, the fields may be added to a point of your own to record them.
+func (p *PointSet) SetLimit(limit PointSetLimit) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.limit = limit
+	p.candidates = nil
+	p.other = nil
+	if other := limit.Other; other != nil {
+		fields := other.GetFields()
+		if _, ok := fields[EvictionsField]; !ok {
+			other.SetField(EvictionsField, &p.evictions)
+		}
+		if _, ok := fields[RejectionsField]; !ok {
+			other.SetField(RejectionsField, &p.rejections)
+		}
+		p.other = other.Linked()
+	}
+
+	if limit.Policy == RejectNew {
+		return
+	}
+
+	for len(p.metrics) > limit.Max && limit.Max > 0 {
+		p.evict()
+	}
+}
+
+// Limit returns the PointSet's current limit.
+func (p *PointSet) Limit() PointSetLimit {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.limit
+}
+
+// Evictions returns the field counting the number of points the PointSet has evicted. The field is owned by the
+// PointSet and may be added to points of your own.
+func (p *PointSet) Evictions() *Int {
+	return &p.evictions
+}
+
+// Rejections returns the field counting the number of times the PointSet has redirected an identifier to its other
+// point or refused it. Each request for a redirected identifier's fields counts as a rejection.
+func (p *PointSet) Rejections() *Int {
+	return &p.rejections
+}
+
+// full returns whether the PointSet is limited and holds its maximum number of points. The PointSet must be locked.
+func (p *PointSet) full() bool {
+	return p.limit.Max > 0 && len(p.metrics) >= p.limit.Max
+}
+
+// admit returns whether a new identifier may be given a point, evicting another to make room for it, once the PointSet
+// is full. Under RejectNew, it never may. Under an evicting policy with an other point, an identifier is admitted the
+// second time it's seen while the PointSet is full and is redirected to the other point until then. The identifiers
+// seen once are remembered up to the PointSet's limit, after which they're forgotten. The PointSet must be locked for
+// writing.
+func (p *PointSet) admit(ident string) bool {
+	switch {
+	case p.limit.Policy == RejectNew:
+		return false
+	case p.other == nil:
+		return true
+	}
+
+	if _, ok := p.candidates[ident]; ok {
+		delete(p.candidates, ident)
+		return true
+	}
+
+	if p.candidates == nil || len(p.candidates) >= p.limit.Max {
+		p.candidates = make(map[string]struct{})
+	}
+	p.candidates[ident] = struct{}{}
+	return false
+}
+
+// touch records a use of e. It is a no-op if the PointSet is unlimited and points don't expire. The PointSet must be
+// locked, though it may be locked for reading.
+func (p *PointSet) touch(e *pointSetEntry) {
+	if p.expiry.Idle > 0 {
+		atomic.StoreInt64(&e.accessed, clock.Now().UnixNano())
+	}
+	if p.limit.Max <= 0 {
+		return
+	}
+	atomic.StoreUint64(&e.used, atomic.AddUint64(&p.tick, 1))
+	atomic.AddUint64(&e.hits, 1)
+}
+
+// evict removes the point chosen by the PointSet's eviction policy. This scans all of the PointSet's points, so its
+// cost grows with the PointSet's limit. The PointSet must be locked for writing.
+func (p *PointSet) evict() {
+	var (
+		victim     string
+		found      bool
+		used, hits uint64
+	)
+
+	for ident, e := range p.metrics {
+		eused, ehits := atomic.LoadUint64(&e.used), atomic.LoadUint64(&e.hits)
+		switch {
+		case !found:
+		case p.limit.Policy == EvictLFU && ehits < hits:
+		case (p.limit.Policy != EvictLFU || ehits == hits) && eused < used:
+		default:
+			continue
+		}
+		victim, found, used, hits = ident, true, eused, ehits
+	}
+
+	if found {
+		p.remove(victim, p.metrics[victim])
+		p.evictions.Add(1)
+	}
+}
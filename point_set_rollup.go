@@ -0,0 +1,220 @@
+package dagr
+
+import "time"
+
+// Reducer is how a PointSet's rollup combines the values of a field across its points.
+type Reducer int
+
+const (
+	// ReduceSum adds values together. Int, uint, and float fields are summed, and fields implementing MergeField
+	// are merged. Other fields, such as bools and strings, can't be summed and are reduced using ReduceLast.
+	ReduceSum Reducer = iota
+	// ReduceMax keeps the largest value. Non-numeric fields are reduced using ReduceLast.
+	ReduceMax
+	// ReduceMin keeps the smallest value. Non-numeric fields are reduced using ReduceLast.
+	ReduceMin
+	// ReduceLast keeps the value of the last point in ascending order by identifier that has the field. The other
+	// point, if any, comes last.
+	ReduceLast
+)
+
+// MergeField is implemented by fields that can be combined with other fields of the same kind, such as histograms,
+// so that they can be summed by a rollup. Merge returns a new field combining the field and other, and whether they
+// could be combined. Neither field is modified.
+type MergeField interface {
+	Field
+	Merge(other Field) (Field, bool)
+}
+
+// PointSetRollup describes an aggregate point computed from all of a PointSet's points, such as the total number of
+// requests across all paths.
+type PointSetRollup struct {
+	// Key is the rollup point's key. If Key is empty, the PointSet has no rollup.
+	Key string
+
+	// Tags are the rollup point's tags. The tags of the PointSet's points are not included.
+	Tags Tags
+
+	// Reducers maps field names to how they're reduced. Fields not in Reducers are reduced using ReduceSum.
+	Reducers map[string]Reducer
+}
+
+// SetRollup sets the PointSet's rollup point. The rollup point is computed each time the PointSet is written, from
+// snapshots of all of its points, including its other point, and is written after them. A field only present on some
+// points is reduced from those points. Setting a rollup with an empty key removes it.
+//
+// So that totals don't drop when points go away, numeric and MergeField fields reduced using ReduceSum keep the values
+// of points the PointSet removes, whether by Remove, Clear, eviction, or expiry. Fields using other reducers only
+// reflect the points the PointSet currently holds. Setting the rollup discards the values kept from removed points.
+func (p *PointSet) SetRollup(rollup PointSetRollup) {
+	if rollup.Key != "" {
+		rollup.Tags = rollup.Tags.Dup()
+		reducers := make(map[string]Reducer, len(rollup.Reducers))
+		for name, r := range rollup.Reducers {
+			reducers[name] = r
+		}
+		rollup.Reducers = reducers
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.rollup = rollup
+	p.removed = nil
+}
+
+// Rollup returns a snapshot of the PointSet's rollup point. If the PointSet has no rollup or there are no fields to roll
+// up, it returns nil.
+func (p *PointSet) Rollup() TimeMeasurement {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	if m, ok := p.computeRollup(clock.Now()); ok {
+		return m
+	}
+	return nil
+}
+
+// computeRollup returns the rollup point as of when. It returns false if there is no rollup or nothing to roll up. The
+// PointSet must be locked.
+func (p *PointSet) computeRollup(when time.Time) (timePoint, bool) {
+	if p.rollup.Key == "" {
+		return timePoint{}, false
+	}
+
+	fields := make(Fields, len(p.removed))
+	for name, f := range p.removed {
+		fields[name] = f
+	}
+	add := func(m *CompiledPoint) {
+		for name, f := range m.current().snapshotAt(when).fields {
+			if prev, ok := fields[name]; ok {
+				fields[name] = reduceField(p.rollup.Reducers[name], prev, f)
+			} else {
+				fields[name] = f
+			}
+		}
+	}
+
+	for _, id := range p.ids() {
+		add(p.metrics[id].CompiledPoint)
+	}
+	if p.other != nil {
+		add(p.other)
+	}
+
+	if len(fields) == 0 {
+		return timePoint{}, false
+	}
+	return timePoint{p.rollup.Key, when, p.rollup.Tags, fields}, true
+}
+
+// retain adds the summed fields of m, a point being removed, to the totals the rollup keeps for removed points. Fields
+// that can't be summed aren't kept. The PointSet must be locked for writing.
+func (p *PointSet) retain(m *CompiledPoint) {
+	if p.rollup.Key == "" {
+		return
+	}
+
+	c := m.current()
+	if len(c.fields) == 0 {
+		return
+	}
+
+	for name, f := range c.snapshotAt(clock.Now()).fields {
+		if p.rollup.Reducers[name] != ReduceSum {
+			continue
+		} else if _, ok := f.(MergeField); !ok {
+			if _, ok := numericValue(f); !ok {
+				continue
+			}
+		}
+
+		if prev, ok := p.removed[name]; ok {
+			p.removed[name] = reduceField(ReduceSum, prev, f)
+			continue
+		} else if p.removed == nil {
+			p.removed = make(Fields)
+		}
+		p.removed[name] = f
+	}
+}
+
+// reduceField combines the snapshots acc and next using r. If acc is a MergeField being summed and can't be merged
+// with next, acc is kept.
+func reduceField(r Reducer, acc, next Field) Field {
+	if r == ReduceSum {
+		if m, ok := acc.(MergeField); ok {
+			if merged, ok := m.Merge(next); ok {
+				return merged
+			}
+			return acc
+		}
+	}
+
+	switch a := FieldValue(acc).(type) {
+	case int64:
+		if b, ok := FieldValue(next).(int64); ok {
+			return RawInt(reduceInt(r, a, b))
+		}
+	case uint64:
+		if b, ok := FieldValue(next).(uint64); ok {
+			return RawUint(reduceUint(r, a, b))
+		}
+	case float64:
+		if b, ok := numericValue(next); ok {
+			return RawFloat(reduceFloat(r, a, b))
+		}
+	}
+
+	// Mixed numeric types are reduced as floats
+	if a, ok := numericValue(acc); ok {
+		if b, ok := numericValue(next); ok {
+			return RawFloat(reduceFloat(r, a, b))
+		}
+	}
+
+	return next
+}
+
+// numericValue returns the value of a field as a float64 if it's numeric.
+func numericValue(f Field) (float64, bool) {
+	switch v := FieldValue(f).(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func reduceInt(r Reducer, a, b int64) int64 {
+	switch {
+	case r == ReduceSum:
+		return a + b
+	case r == ReduceMax && a > b, r == ReduceMin && a < b:
+		return a
+	}
+	return b
+}
+
+func reduceUint(r Reducer, a, b uint64) uint64 {
+	switch {
+	case r == ReduceSum:
+		return a + b
+	case r == ReduceMax && a > b, r == ReduceMin && a < b:
+		return a
+	}
+	return b
+}
+
+func reduceFloat(r Reducer, a, b float64) float64 {
+	switch {
+	case r == ReduceSum:
+		return a + b
+	case r == ReduceMax && a > b, r == ReduceMin && a < b:
+		return a
+	}
+	return b
+}
@@ -2,10 +2,13 @@ package dagr
 
 import (
 	"bytes"
+	"context"
 	"path"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -131,3 +134,524 @@ func TestPointSetAllocator(t *testing.T) {
 
 	t.Logf("OUT=\n%s", out)
 }
+
+func TestPointSetPointForID(t *testing.T) {
+	defer prepareLogger(t)()
+
+	set := NewPointSet(StaticPointAllocator{
+		Key:           "http_request",
+		IdentifierTag: "path",
+		Fields:        Fields{"count": new(Int)},
+	})
+	set.FieldsForID("/kittens", nil)["count"].(*Int).Add(1)
+
+	// Tags set on a point after allocation are written.
+	set.PointForID("/kittens", nil).SetTag("status", "200")
+	set.PointForID("/kittens", nil).SetField("ok", RawBool(true))
+
+	var buf bytes.Buffer
+	if _, err := WriteMeasurement(&buf, set); err != nil {
+		t.Fatal(err)
+	}
+
+	const want = "http_request,path=/kittens,status=200 count=1i,ok=T 1136214245000000000\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Expected %q\nGot %q", want, got)
+	}
+
+	if fields := set.FieldsForID("/kittens", nil); len(fields) != 2 {
+		t.Errorf("FieldsForID() = %v; want 2 fields", fields)
+	}
+}
+
+func TestPointSetLimit(t *testing.T) {
+	defer prepareLogger(t)()
+
+	newSet := func(policy EvictionPolicy, other *Point) *PointSet {
+		p := NewPointSet(StaticPointAllocator{
+			Key:           "http_request",
+			IdentifierTag: "path",
+			Fields:        Fields{"count": new(Int)},
+		})
+		p.SetLimit(PointSetLimit{Max: 2, Policy: policy, Other: other})
+		return p
+	}
+
+	paths := func(p *PointSet) []string {
+		var names []string
+		for _, m := range p.Measurements() {
+			names = append(names, m.GetTags()["path"])
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	t.Run("LRU", func(t *testing.T) {
+		p := newSet(EvictLRU, nil)
+		p.FieldsForID("/a", nil)
+		p.FieldsForID("/b", nil)
+		p.FieldsForID("/a", nil)
+		p.FieldsForID("/c", nil) // Evicts /b
+
+		if got, want := paths(p), []string{"/a", "/c"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("paths = %q; want %q", got, want)
+		}
+		if n := p.Evictions().sample(); n != 1 {
+			t.Errorf("evictions = %d; want 1", n)
+		}
+	})
+
+	t.Run("LFU", func(t *testing.T) {
+		p := newSet(EvictLFU, nil)
+		p.FieldsForID("/a", nil)
+		p.FieldsForID("/b", nil)
+		p.FieldsForID("/b", nil)
+		p.FieldsForID("/b", nil)
+		p.FieldsForID("/a", nil) // Most recent, but used less than /b
+		p.FieldsForID("/c", nil) // Evicts /a
+
+		if got, want := paths(p), []string{"/b", "/c"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("paths = %q; want %q", got, want)
+		}
+	})
+
+	t.Run("RejectNew", func(t *testing.T) {
+		other := NewPoint("http_request", Tags{"path": "other"}, Fields{"count": new(Int)})
+		p := newSet(RejectNew, other)
+
+		for _, path := range []string{"/a", "/b", "/c", "/d", "/a"} {
+			p.FieldsForID(path, nil)["count"].(*Int).Add(1)
+		}
+
+		var buf bytes.Buffer
+		if _, err := WriteMeasurement(&buf, p); err != nil {
+			t.Fatalf("WriteMeasurement() error: %v", err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		sort.Strings(lines)
+		want := []string{
+			`http_request,path=/a count=2i 1136214245000000000`,
+			`http_request,path=/b count=1i 1136214245000000000`,
+			`http_request,path=other count=2i,evictions=0i,rejections=2i 1136214245000000000`,
+		}
+		if !reflect.DeepEqual(lines, want) {
+			t.Errorf("WriteMeasurement() =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+		}
+	})
+
+	t.Run("EvictOther", func(t *testing.T) {
+		other := NewPoint("http_request", Tags{"path": "other"}, Fields{"count": new(Int)})
+		p := newSet(EvictLRU, other)
+		for _, path := range []string{"/a", "/b", "/c", "/d", "/a", "/c"} {
+			p.FieldsForID(path, nil)["count"].(*Int).Add(1)
+		}
+
+		// /c and /d are redirected to other when first seen, and /c evicts /b when it's seen again
+		if got, want := paths(p), []string{"/a", "/c", "other"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("paths = %q; want %q", got, want)
+		}
+
+		fields := other.GetFields()
+		for name, want := range map[string]int64{"count": 2, EvictionsField: 1, RejectionsField: 2} {
+			if got := fields[name].(*Int).sample(); got != want {
+				t.Errorf("other %s = %d; want %d", name, got, want)
+			}
+		}
+	})
+
+	t.Run("RemoveCandidate", func(t *testing.T) {
+		other := NewPoint("http_request", Tags{"path": "other"}, Fields{"count": new(Int)})
+		p := newSet(EvictLRU, other)
+		p.FieldsForID("/a", nil)
+		p.FieldsForID("/b", nil)
+		p.FieldsForID("/c", nil) // Redirected to other
+
+		// Removing /c forgets that it was seen, so it's redirected again instead of evicting a point.
+		p.Remove("/c")
+		p.FieldsForID("/c", nil)
+
+		if got, want := paths(p), []string{"/a", "/b", "other"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("paths = %q; want %q", got, want)
+		}
+		if n := p.Rejections().sample(); n != 2 {
+			t.Errorf("rejections = %d; want 2", n)
+		}
+	})
+
+	t.Run("RejectNoOther", func(t *testing.T) {
+		p := newSet(RejectNew, nil)
+		p.FieldsForID("/a", nil)
+		p.FieldsForID("/b", nil)
+		if fields := p.FieldsForID("/c", nil); fields != nil {
+			t.Errorf("FieldsForID(/c) = %v; want nil", fields)
+		}
+		if n := p.Rejections().sample(); n != 1 {
+			t.Errorf("rejections = %d; want 1", n)
+		}
+	})
+
+	t.Run("Shrink", func(t *testing.T) {
+		p := newSet(EvictLRU, nil)
+		p.FieldsForID("/a", nil)
+		p.FieldsForID("/b", nil)
+		p.SetLimit(PointSetLimit{Max: 1})
+
+		if got, want := paths(p), []string{"/b"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("paths = %q; want %q", got, want)
+		}
+	})
+}
+
+func TestPointSetExpire(t *testing.T) {
+	defer prepareLogger(t)()
+	defer func(c timeSource) { clock = c }(clock)
+
+	now := testTime
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		clock = testClock(now)
+	}
+
+	var final bytes.Buffer
+	p := NewPointSet(StaticPointAllocator{
+		Key:           "conn",
+		IdentifierTag: "id",
+		Fields:        Fields{"bytes": new(Int)},
+	})
+	p.SetExpiry(PointSetExpiry{
+		Idle: time.Minute,
+		Final: WriteMeasurementsFunc(func(ms ...Measurement) (int64, error) {
+			return WriteMeasurements(&final, ms...)
+		}),
+	})
+
+	p.FieldsForID("a", nil)["bytes"].(*Int).Add(1)
+	retained := p.PointForID("b", nil)
+	p.FieldsForID("c", nil)
+
+	if n := p.Expire(); n != 0 {
+		t.Fatalf("Expire() = %d; want 0", n)
+	}
+
+	advance(45 * time.Second)
+	p.FieldsForID("a", nil)
+	retained.Update(func(fs Fields) { fs["bytes"].(*Int).Add(10) })
+	if n := p.Expire(); n != 0 {
+		t.Fatalf("Expire() = %d; want 0", n)
+	}
+
+	// c has been idle for 75s; a was requested and b was updated 30s ago.
+	advance(30 * time.Second)
+	if n := p.Expire(); n != 1 {
+		t.Fatalf("Expire() = %d; want 1", n)
+	}
+
+	want := `conn,id=c bytes=0i ` + strconv.FormatInt(now.UnixNano(), 10) + "\n"
+	if got := final.String(); got != want {
+		t.Errorf("final writes = %q; want %q", got, want)
+	}
+
+	advance(time.Minute)
+	if n := p.Expire(); n != 2 {
+		t.Fatalf("Expire() = %d; want 2", n)
+	}
+	if ms := p.Measurements(); len(ms) != 0 {
+		t.Errorf("Measurements() = %v; want none", ms)
+	}
+}
+
+func TestPointSetExpireUpdate(t *testing.T) {
+	defer prepareLogger(t)()
+	defer func(c timeSource) { clock = c }(clock)
+
+	p := NewPointSet(StaticPointAllocator{Key: "gauge", IdentifierTag: "id", Fields: Fields{"value": new(Int)}})
+	p.SetExpiry(PointSetExpiry{Idle: time.Minute})
+	point := p.PointForID("a", nil)
+	p.Expire()
+
+	// Updated back to the same value, which still counts as a change
+	clock = testClock(testTime.Add(45 * time.Second))
+	point.Update(func(fs Fields) {
+		fs["value"].(*Int).Add(1)
+		fs["value"].(*Int).Add(-1)
+	})
+	if n := p.Expire(); n != 0 {
+		t.Fatalf("Expire() = %d; want 0", n)
+	}
+
+	clock = testClock(testTime.Add(90 * time.Second))
+	if n := p.Expire(); n != 0 {
+		t.Fatalf("Expire() = %d; want 0", n)
+	}
+
+	clock = testClock(testTime.Add(106 * time.Second))
+	if n := p.Expire(); n != 1 {
+		t.Fatalf("Expire() = %d; want 1", n)
+	}
+}
+
+func TestPointSetExpireUnused(t *testing.T) {
+	defer prepareLogger(t)()
+	defer func(c timeSource) { clock = c }(clock)
+
+	p := NewPointSet(StaticPointAllocator{Key: "gauge", IdentifierTag: "id", Fields: Fields{"value": new(Int)}})
+	p.SetExpiry(PointSetExpiry{Idle: time.Minute})
+	fields := p.FieldsForID("a", nil)
+
+	// Idle time counts from allocation, not from the first call to Expire
+	clock = testClock(testTime.Add(61 * time.Second))
+	if n := p.Expire(); n != 1 {
+		t.Fatalf("Expire() = %d; want 1", n)
+	}
+
+	// Fields modified outside of Update aren't a change
+	fields = p.FieldsForID("b", nil)
+	clock = testClock(testTime.Add(122 * time.Second))
+	fields["value"].(*Int).Add(1)
+	if n := p.Expire(); n != 1 {
+		t.Fatalf("Expire() = %d; want 1", n)
+	}
+}
+
+// manualClock is a timeSource whose tickers only tick when sent to. If stopped is not nil, it's closed when a ticker is
+// stopped.
+type manualClock struct {
+	m       sync.Mutex
+	now     time.Time
+	c       chan time.Time
+	stopped chan struct{}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+func (c *manualClock) NewTicker(time.Duration) ticker { return c }
+func (c *manualClock) Chan() <-chan time.Time         { return c.c }
+
+func (c *manualClock) Stop() {
+	if c.stopped != nil {
+		close(c.stopped)
+	}
+}
+
+// tick advances the clock by d and ticks its tickers.
+func (c *manualClock) tick(d time.Duration) {
+	c.m.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	c.m.Unlock()
+	c.c <- now
+}
+
+func TestPointSetStartExpiring(t *testing.T) {
+	defer prepareLogger(t)()
+	defer func(c timeSource) { clock = c }(clock)
+
+	mc := &manualClock{now: testTime, c: make(chan time.Time)}
+	clock = mc
+
+	expired := make(chan string, 1)
+	p := NewPointSet(StaticPointAllocator{Key: "conn", IdentifierTag: "id", Fields: Fields{"bytes": new(Int)}})
+	p.SetExpiry(PointSetExpiry{
+		Idle: time.Minute,
+		Final: WriteMeasurementsFunc(func(ms ...Measurement) (int64, error) {
+			for _, m := range ms {
+				expired <- m.GetTags()["id"]
+			}
+			return 0, nil
+		}),
+	})
+	p.FieldsForID("a", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.StartExpiring(ctx, time.Minute)
+
+	mc.tick(30 * time.Second)
+	mc.tick(time.Minute) // a has been idle since it was allocated
+	select {
+	case id := <-expired:
+		if id != "a" {
+			t.Errorf("expired %q; want a", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for expiry")
+	}
+	if n := p.Len(); n != 0 {
+		t.Errorf("Len() = %d; want 0", n)
+	}
+}
+
+func TestPointSetSorted(t *testing.T) {
+	defer prepareLogger(t)()
+
+	p := NewPointSet(StaticPointAllocator{
+		Key:           "http_request",
+		IdentifierTag: "path",
+		Fields:        Fields{"count": new(Int)},
+	})
+	p.SetSorted(true)
+
+	for i, path := range []string{"/c", "/a", "/d", "/b"} {
+		p.FieldsForID(path, nil)["count"].(*Int).Add(int64(i))
+	}
+
+	const want = `http_request,path=/a count=1i 1136214245000000000` + "\n" +
+		`http_request,path=/b count=3i 1136214245000000000` + "\n" +
+		`http_request,path=/c count=0i 1136214245000000000` + "\n" +
+		`http_request,path=/d count=2i 1136214245000000000` + "\n"
+
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		if _, err := WriteMeasurement(&buf, p); err != nil {
+			t.Fatalf("WriteMeasurement() error: %v", err)
+		}
+		if got := buf.String(); got != want {
+			t.Fatalf("WriteMeasurement() =\n%s\nwant\n%s", got, want)
+		}
+	}
+
+	if n := p.Len(); n != 4 {
+		t.Errorf("Len() = %d; want 4", n)
+	}
+
+	wantIDs := []string{"/a", "/b", "/c", "/d"}
+	if ids := p.IDs(); !reflect.DeepEqual(ids, wantIDs) {
+		t.Errorf("IDs() = %q; want %q", ids, wantIDs)
+	}
+
+	var ids []string
+	p.Each(func(id string, m Measurement) bool {
+		if path := m.GetTags()["path"]; path != id {
+			t.Errorf("Each: path tag of %q = %q", id, path)
+		}
+		ids = append(ids, id)
+		return len(ids) < 2
+	})
+	if want := wantIDs[:2]; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Each() visited %q; want %q", ids, want)
+	}
+
+	snaps := p.Snapshots()
+	if len(snaps) != 4 {
+		t.Fatalf("len(Snapshots()) = %d; want 4", len(snaps))
+	}
+	for i, snap := range snaps {
+		if path := snap.GetTags()["path"]; path != wantIDs[i] {
+			t.Errorf("Snapshots()[%d] path = %q; want %q", i, path, wantIDs[i])
+		}
+		if when := snap.GetTime(); !when.Equal(snaps[0].GetTime()) {
+			t.Errorf("Snapshots()[%d] time = %v; want %v", i, when, snaps[0].GetTime())
+		}
+	}
+
+	p.FieldsForID("/a", nil)["count"].(*Int).Add(10)
+	if v := FieldValue(snaps[0].GetFields()["count"]); v != int64(1) {
+		t.Errorf("Snapshots()[0] count = %v; want 1", v)
+	}
+
+	// Encoders with default tags write the PointSet's Measurements rather than the PointSet itself
+	enc := Encoder{DefaultTags: Tags{"host": "example.local"}}
+	const wantTagged = `http_request,host=example.local,path=/a count=11i 1136214245000000000` + "\n" +
+		`http_request,host=example.local,path=/b count=3i 1136214245000000000` + "\n" +
+		`http_request,host=example.local,path=/c count=0i 1136214245000000000` + "\n" +
+		`http_request,host=example.local,path=/d count=2i 1136214245000000000` + "\n"
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		if _, err := enc.WriteMeasurement(&buf, p); err != nil {
+			t.Fatalf("Encoder.WriteMeasurement() error: %v", err)
+		}
+		if got := buf.String(); got != wantTagged {
+			t.Fatalf("Encoder.WriteMeasurement() =\n%s\nwant\n%s", got, wantTagged)
+		}
+	}
+}
+
+func TestPointSetRollup(t *testing.T) {
+	defer prepareLogger(t)()
+
+	p := NewPointSet(PointAllocFunc(func(id string, _ interface{}) (string, Tags, Fields) {
+		return "http_request", Tags{"path": id}, Fields{
+			"count":   new(Int),
+			"latency": new(Float),
+			"peak":    new(Int),
+			"version": RawString("v1" + id),
+		}
+	}))
+	p.SetSorted(true)
+	p.SetRollup(PointSetRollup{
+		Key:      "http_request",
+		Tags:     Tags{"path": "all"},
+		Reducers: map[string]Reducer{"peak": ReduceMax, "latency": ReduceMin, "version": ReduceLast},
+	})
+
+	record := func(id string, count int64, latency float64) {
+		fields := p.FieldsForID(id, nil)
+		fields["count"].(*Int).Add(count)
+		fields["latency"].(*Float).Set(latency)
+		fields["peak"].(*Int).Set(count * 10)
+	}
+	record("/a", 1, 0.5)
+	record("/b", 4, 0.25)
+	record("/c", 2, 1.5)
+
+	const want = `http_request,path=/a count=1i,latency=0.5,peak=10i,version="v1/a" 1136214245000000000` + "\n" +
+		`http_request,path=/b count=4i,latency=0.25,peak=40i,version="v1/b" 1136214245000000000` + "\n" +
+		`http_request,path=/c count=2i,latency=1.5,peak=20i,version="v1/c" 1136214245000000000` + "\n" +
+		`http_request,path=all count=7i,latency=0.25,peak=40i,version="v1/c" 1136214245000000000` + "\n"
+
+	var buf bytes.Buffer
+	if _, err := WriteMeasurement(&buf, p); err != nil {
+		t.Fatalf("WriteMeasurement() error: %v", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("WriteMeasurement() =\n%s\nwant\n%s", got, want)
+	}
+
+	rollup := p.Rollup()
+	if rollup == nil {
+		t.Fatal("Rollup() = nil")
+	}
+	if v := FieldValue(rollup.GetFields()["count"]); v != int64(7) {
+		t.Errorf("Rollup() count = %v; want 7", v)
+	}
+
+	// Sums keep the values of removed points, while other reducers only see the points held
+	p.Remove("/b")
+	rollup = p.Rollup()
+	for name, want := range map[string]interface{}{"count": int64(7), "peak": int64(20), "latency": 0.5} {
+		if got := FieldValue(rollup.GetFields()[name]); got != want {
+			t.Errorf("Rollup() %s after Remove = %v; want %v", name, got, want)
+		}
+	}
+
+	p.SetRollup(PointSetRollup{})
+	if rollup := p.Rollup(); rollup != nil {
+		t.Errorf("Rollup() = %v; want nil", rollup)
+	}
+}
+
+// mergeInt is a MergeField that only merges with other mergeInts.
+type mergeInt struct{ RawInt }
+
+func (m mergeInt) Dup() Field { return m }
+
+func (m mergeInt) Merge(other Field) (Field, bool) {
+	if o, ok := other.(mergeInt); ok {
+		return mergeInt{m.RawInt + o.RawInt}, true
+	}
+	return nil, false
+}
+
+func TestReduceFieldMerge(t *testing.T) {
+	if got := reduceField(ReduceSum, mergeInt{1}, mergeInt{2}); got != (mergeInt{3}) {
+		t.Errorf("reduceField(1, 2) = %#v; want %#v", got, mergeInt{3})
+	}
+	if got := reduceField(ReduceSum, mergeInt{1}, RawString("x")); got != (mergeInt{1}) {
+		t.Errorf("reduceField(1, x) = %#v; want %#v", got, mergeInt{1})
+	}
+}
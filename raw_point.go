@@ -39,10 +39,14 @@ type RawString string
 var _ = Field(RawString(""))
 
 func (s RawString) WriteTo(w io.Writer) (int64, error) {
-	escaped := stringEscaper.Replace(string(s))
-	n, err := io.WriteString(w, `"`+escaped+`"`)
+	n, err := w.Write(s.Append(make([]byte, 0, len(s)+2)))
 	return int64(n), err
 }
 
+// Append appends the string to dst, quoted and escaped.
+func (s RawString) Append(dst []byte) []byte {
+	return appendQuoted(dst, string(s))
+}
+
 func (s RawString) Dup() Field      { return s }
 func (s RawString) Snapshot() Field { return s }
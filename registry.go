@@ -0,0 +1,162 @@
+package dagr
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+// Registry is a collection of measurements identified by name. Names are only used to look up measurements in the
+// Registry and are not written, so they need not be the same as the measurements' keys. A Registry is a
+// MeasurementSet, so writing the Registry writes all of its measurements.
+//
+// The zero value of a Registry is empty and ready to use. It is safe to use a Registry from concurrent goroutines.
+type Registry struct {
+	m     sync.RWMutex
+	names []string // Sorted
+	ms    map[string]Measurement
+}
+
+var _ = MeasurementSet((*Registry)(nil))
+
+// DefaultRegistry is the process-wide Registry. Packages that provide instrumentation should register their
+// measurements here unless told to use a different Registry.
+var DefaultRegistry = new(Registry)
+
+// Register adds m to the Registry under the given name. If the name is already in use, it returns ErrDuplicateName and
+// the Registry is unchanged. If name is empty or m is nil, Register panics.
+func (r *Registry) Register(name string, m Measurement) error {
+	if name == "" {
+		panic("dagr: Registry.Register: name is empty")
+	} else if m == nil {
+		panic("dagr: Registry.Register: measurement is nil")
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, ok := r.ms[name]; ok {
+		return ErrDuplicateName
+	}
+	r.add(name, m)
+	return nil
+}
+
+func (r *Registry) add(name string, m Measurement) {
+	if r.ms == nil {
+		r.ms = make(map[string]Measurement)
+	}
+	r.ms[name] = m
+	r.names = insertOrderedString(r.names, name)
+}
+
+// Unregister removes the measurement with the given name from the Registry. It returns whether a measurement was
+// removed.
+func (r *Registry) Unregister(name string) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, ok := r.ms[name]; !ok {
+		return false
+	}
+
+	delete(r.ms, name)
+	if i := sort.SearchStrings(r.names, name); i < len(r.names) && r.names[i] == name {
+		copy(r.names[i:], r.names[i+1:])
+		r.names = r.names[:len(r.names)-1]
+	}
+	return true
+}
+
+// Get returns the measurement registered under name. If there is no such measurement, it returns nil.
+func (r *Registry) Get(name string) Measurement {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.ms[name]
+}
+
+// GetOrRegister returns the measurement registered under name. If there is no such measurement, it calls alloc and
+// registers the result under name. alloc is only called once for concurrent calls to GetOrRegister with the same
+// name, and is called while the Registry is locked, so it must not use the Registry. If alloc returns nil, nothing is
+// registered and GetOrRegister returns nil. If name is empty or alloc is nil, GetOrRegister panics.
+func (r *Registry) GetOrRegister(name string, alloc func() Measurement) Measurement {
+	if name == "" {
+		panic("dagr: Registry.GetOrRegister: name is empty")
+	} else if alloc == nil {
+		panic("dagr: Registry.GetOrRegister: alloc is nil")
+	}
+
+	if m := r.Get(name); m != nil {
+		return m
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if m, ok := r.ms[name]; ok {
+		return m
+	}
+
+	m := alloc()
+	if m != nil {
+		r.add(name, m)
+	}
+	return m
+}
+
+// Len returns the number of measurements in the Registry.
+func (r *Registry) Len() int {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return len(r.names)
+}
+
+// Walk calls fn for each measurement in the Registry in ascending order by name. If fn returns false, Walk stops.
+// Walk iterates over the measurements registered at the time it was called, so fn may modify the Registry.
+func (r *Registry) Walk(fn func(name string, m Measurement) bool) {
+	r.m.RLock()
+	names := append([]string(nil), r.names...)
+	ms := make([]Measurement, len(names))
+	for i, name := range names {
+		ms[i] = r.ms[name]
+	}
+	r.m.RUnlock()
+
+	for i, name := range names {
+		if !fn(name, ms[i]) {
+			return
+		}
+	}
+}
+
+// Measurements returns all measurements in the Registry in ascending order by name.
+func (r *Registry) Measurements() []Measurement {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	ms := make([]Measurement, len(r.names))
+	for i, name := range r.names {
+		ms[i] = r.ms[name]
+	}
+	return ms
+}
+
+// WriteTo writes all measurements in the Registry to w in a single write. Measurements without fields are skipped, as
+// with WriteMeasurements.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	return WriteMeasurements(w, r.Measurements()...)
+}
+
+// A Registry is only a collection of measurements, so it has no key, tags, or fields of its own.
+
+func (r *Registry) GetKey() string {
+	return ""
+}
+
+func (r *Registry) GetFields() Fields {
+	return nil
+}
+
+func (r *Registry) GetTags() Tags {
+	return nil
+}
@@ -0,0 +1,85 @@
+package dagr
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	const required = `process,host=example.local running=T 1136214245000000000` + "\n" +
+		`http_request,path=/api/v1/kittens count=2i 1136214245000000000` + "\n"
+
+	defer prepareLogger(t)()
+
+	var r Registry
+
+	running := new(Bool)
+	running.Set(true)
+	process := NewPoint("process", Tags{"host": "example.local"}, Fields{"running": running})
+	if err := r.Register("process", process); err != nil {
+		t.Fatalf("Register() = %v; want nil", err)
+	}
+
+	if err := r.Register("process", process); err != ErrDuplicateName {
+		t.Fatalf("Register() = %v; want %v", err, ErrDuplicateName)
+	}
+
+	allocs := 0
+	alloc := func() Measurement {
+		allocs++
+		return NewPointSet(StaticPointAllocator{
+			Key:           "http_request",
+			IdentifierTag: "path",
+			Fields:        Fields{"count": new(Int)},
+		})
+	}
+
+	requests := r.GetOrRegister("requests", alloc).(*PointSet)
+	if again := r.GetOrRegister("requests", alloc); again != requests || allocs != 1 {
+		t.Fatalf("GetOrRegister() = %p after %d allocations; want %p after 1", again, allocs, requests)
+	}
+	requests.FieldsForID("/api/v1/kittens", nil)["count"].(*Int).Add(2)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("GetOrRegister() with an empty name didn't panic")
+			}
+		}()
+		r.GetOrRegister("", alloc)
+	}()
+	if allocs != 1 {
+		t.Errorf("GetOrRegister() with an empty name allocated")
+	}
+
+	// Measurements without fields are skipped
+	r.Register("empty", NewPoint("empty", nil, nil))
+
+	var names []string
+	r.Walk(func(name string, m Measurement) bool {
+		names = append(names, name)
+		return true
+	})
+
+	if want := []string{"empty", "process", "requests"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Walk() names = %q; want %q", names, want)
+	}
+
+	var buf bytes.Buffer
+	if _, err := WriteMeasurement(&buf, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := buf.String(); got != required {
+		t.Errorf("Expected ---\n%s\n---\n\nGot ---\n%s\n---", required, got)
+	}
+
+	if !r.Unregister("process") || r.Unregister("process") {
+		t.Error("Unregister(process) did not remove process exactly once")
+	}
+
+	if r.Get("process") != nil || r.Len() != 2 {
+		t.Errorf("Get(process) = %v, Len() = %d; want nil, 2", r.Get("process"), r.Len())
+	}
+}
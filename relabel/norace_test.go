@@ -0,0 +1,5 @@
+//+build !race
+
+package relabel
+
+const raceEnabled = false
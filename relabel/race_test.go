@@ -0,0 +1,7 @@
+//+build race
+
+package relabel
+
+// raceEnabled is true when tests are built with the race detector, which allocates on its own and breaks allocation
+// counts.
+const raceEnabled = true
@@ -0,0 +1,409 @@
+// Package relabel rewrites and filters dagr measurements before they're written, similar to Prometheus's relabeling.
+// A RuleSet is a compiled chain of rules that match a measurement's key, a tag's value, or tag or field names against
+// regular expressions, and then rename, drop, keep, or replace what they matched. A RuleSet can wrap measurements or be
+// placed in front of any dagr.MeasurementWriter, such as an outflux Proxy:
+//
+//	rules := relabel.MustCompile(
+//		// Drop the noisy pid tag
+//		relabel.Rule{Source: relabel.TagName, Regexp: "pid", Action: relabel.Drop},
+//		// Rename keys for a migration
+//		relabel.Rule{Source: relabel.Key, Regexp: "http_(.*)", Action: relabel.Replace, Replacement: "web_$1"},
+//		// Add a tag for the environment
+//		relabel.Rule{Source: relabel.Tag, Name: "env", Action: relabel.Replace, Replacement: "prod"},
+//	)
+//	w := relabel.NewWriter(rules, proxy)
+//	w.WriteMeasurements(points...)
+package relabel // import "go.spiff.io/dagr/relabel"
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.spiff.io/dagr"
+)
+
+// Source is what part of a measurement a Rule matches against.
+type Source int
+
+const (
+	// Key matches the measurement's key.
+	Key Source = iota
+	// Tag matches the value of the tag given by the Rule's Name. A missing tag has an empty value.
+	Tag
+	// TagName matches each of the measurement's tag names.
+	TagName
+	// FieldName matches each of the measurement's field names.
+	FieldName
+)
+
+func (s Source) String() string {
+	switch s {
+	case Key:
+		return "key"
+	case Tag:
+		return "tag"
+	case TagName:
+		return "tag name"
+	case FieldName:
+		return "field name"
+	}
+	return fmt.Sprintf("Source(%d)", int(s))
+}
+
+// Action is what a Rule does with what it matched.
+type Action int
+
+const (
+	// Replace replaces what was matched with the Rule's Replacement, expanded using the match (e.g., "$1" is the
+	// first submatch). For a Key source, this sets the key; if the result is empty, the measurement is dropped. For a
+	// Tag source, this sets the tag named by Target, or by Name if Target is empty; if the result is empty, the tag
+	// is removed. For TagName and FieldName sources, this renames each matching tag or field; if the result is empty,
+	// the tag or field is removed.
+	Replace Action = iota
+	// Keep drops the measurement unless its key or tag value matches. For TagName and FieldName sources, Keep
+	// removes all tags or fields that don't match.
+	Keep
+	// Drop drops the measurement if its key or tag value matches. For TagName and FieldName sources, Drop removes all
+	// tags or fields that match.
+	Drop
+)
+
+func (a Action) String() string {
+	switch a {
+	case Replace:
+		return "replace"
+	case Keep:
+		return "keep"
+	case Drop:
+		return "drop"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// Rule is a single relabeling rule. Rules are applied in order, with each rule seeing the result of the rules before
+// it. A measurement left with no fields is dropped.
+type Rule struct {
+	// Source is what the rule matches against.
+	Source Source
+
+	// Name is the name of the tag whose value is matched, when Source is Tag.
+	Name string
+
+	// Regexp is the regular expression matched against the source. It's anchored at both ends, so it must match the
+	// whole of the source. If Regexp is empty, it's "(.*)", which matches anything.
+	Regexp string
+
+	// Action is what to do when Regexp matches, or doesn't match in the case of Keep.
+	Action Action
+
+	// Target is the name of the tag set by Replace when Source is Tag. If Target is empty, it's the same as Name.
+	Target string
+
+	// Replacement is the template used by Replace. It may refer to submatches of Regexp as $1, ${name}, and so on. If
+	// Replacement is empty, it's "$1". To remove what was matched, use Drop.
+	Replacement string
+}
+
+// rule is a compiled Rule.
+type rule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// RuleSet is a compiled chain of Rules. A RuleSet is immutable and safe for use from concurrent goroutines.
+type RuleSet struct {
+	rules []rule
+}
+
+// Compile compiles rules into a RuleSet. It returns an error if a rule has an invalid regular expression, source, or
+// action, or if a Tag rule has no Name.
+func Compile(rules ...Rule) (*RuleSet, error) {
+	rs := &RuleSet{rules: make([]rule, len(rules))}
+	for i, r := range rules {
+		switch r.Source {
+		case Key, TagName, FieldName:
+		case Tag:
+			if r.Name == "" {
+				return nil, fmt.Errorf("relabel: rule %d: tag rule has no name", i)
+			}
+		default:
+			return nil, fmt.Errorf("relabel: rule %d: invalid source %v", i, r.Source)
+		}
+
+		switch r.Action {
+		case Replace, Keep, Drop:
+		default:
+			return nil, fmt.Errorf("relabel: rule %d: invalid action %v", i, r.Action)
+		}
+
+		expr := r.Regexp
+		if expr == "" {
+			expr = "(.*)"
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("relabel: rule %d: %v", i, err)
+		}
+
+		if r.Target == "" {
+			r.Target = r.Name
+		}
+		if r.Replacement == "" {
+			r.Replacement = "$1"
+		}
+		rs.rules[i] = rule{r, re}
+	}
+	return rs, nil
+}
+
+// MustCompile is the same as Compile, but panics if the rules can't be compiled.
+func MustCompile(rules ...Rule) *RuleSet {
+	rs, err := Compile(rules...)
+	if err != nil {
+		panic(err)
+	}
+	return rs
+}
+
+// relabeling is the state of a measurement being relabeled. Tags and fields are only copied once a rule modifies them.
+// names and expandBuffer are scratch space kept across rules and, in ApplyAll, across measurements.
+type relabeling struct {
+	key          string
+	tags         dagr.Tags
+	fields       dagr.Fields
+	ownTags      bool
+	ownFields    bool
+	changed      bool
+	dropped      bool
+	names        []string
+	expandBuffer []byte
+}
+
+// reset prepares the relabeling for m, keeping its scratch space.
+func (r *relabeling) reset(m dagr.Measurement) {
+	*r = relabeling{
+		key:          m.GetKey(),
+		tags:         m.GetTags(),
+		fields:       m.GetFields(),
+		names:        r.names[:0],
+		expandBuffer: r.expandBuffer[:0],
+	}
+}
+
+func (r *relabeling) setTag(name, value string) {
+	if old, ok := r.tags[name]; ok == (value != "") && old == value {
+		return
+	}
+	if !r.ownTags {
+		r.tags, r.ownTags = r.tags.Dup(), true
+		if r.tags == nil {
+			r.tags = make(dagr.Tags, 1)
+		}
+	}
+	if value == "" {
+		delete(r.tags, name)
+	} else {
+		r.tags[name] = value
+	}
+	r.changed = true
+}
+
+func (r *relabeling) setField(name string, f dagr.Field) {
+	if !r.ownFields {
+		r.fields, r.ownFields = r.fields.Dup(false), true
+	}
+	if f == nil {
+		delete(r.fields, name)
+	} else {
+		r.fields[name] = f
+	}
+	r.changed = true
+}
+
+// expand expands the rule's replacement for a match in src. The result is only valid until expand is next called, so
+// it's only converted to a string when it's kept.
+func (r *relabeling) expand(ru *rule, src string, match []int) []byte {
+	r.expandBuffer = ru.re.ExpandString(r.expandBuffer[:0], ru.Replacement, src, match)
+	return r.expandBuffer
+}
+
+// apply applies a single rule.
+func (r *relabeling) apply(ru *rule) {
+	switch ru.Source {
+	case Key, Tag:
+		src := r.key
+		if ru.Source == Tag {
+			src = r.tags[ru.Name]
+		}
+
+		match := ru.re.FindStringSubmatchIndex(src)
+		switch {
+		case ru.Action == Keep && match == nil, ru.Action == Drop && match != nil:
+			r.dropped = true
+		case ru.Action == Replace && match != nil:
+			value := r.expand(ru, src, match)
+			if ru.Source == Tag {
+				if old, ok := r.tags[ru.Target]; ok == (len(value) == 0) || old != string(value) {
+					r.setTag(ru.Target, string(value))
+				}
+			} else if len(value) == 0 {
+				r.dropped = true
+			} else if string(value) != r.key {
+				r.key, r.changed = string(value), true
+			}
+		}
+
+	case TagName:
+		// Names are collected first so that renamed tags aren't matched again.
+		r.names = r.names[:0]
+		for name := range r.tags {
+			r.names = append(r.names, name)
+		}
+		for _, name := range r.names {
+			value := r.tags[name]
+			match := ru.re.FindStringSubmatchIndex(name)
+			switch {
+			case ru.Action == Keep && match == nil, ru.Action == Drop && match != nil:
+				r.setTag(name, "")
+			case ru.Action == Replace && match != nil:
+				if newName := r.expand(ru, name, match); string(newName) != name {
+					r.setTag(name, "")
+					if len(newName) > 0 {
+						r.setTag(string(newName), value)
+					}
+				}
+			}
+		}
+
+	case FieldName:
+		r.names = r.names[:0]
+		for name := range r.fields {
+			r.names = append(r.names, name)
+		}
+		for _, name := range r.names {
+			f := r.fields[name]
+			match := ru.re.FindStringSubmatchIndex(name)
+			switch {
+			case ru.Action == Keep && match == nil, ru.Action == Drop && match != nil:
+				r.setField(name, nil)
+			case ru.Action == Replace && match != nil:
+				if newName := r.expand(ru, name, match); string(newName) != name {
+					r.setField(name, nil)
+					if len(newName) > 0 {
+						r.setField(string(newName), f)
+					}
+				}
+			}
+		}
+		if len(r.fields) == 0 {
+			r.dropped = true
+		}
+	}
+}
+
+// Apply applies the RuleSet to m and returns the relabeled measurement, or false if m was dropped. If no rule changes
+// m, m itself is returned, so that measurements such as compiled points keep their faster encoding. Otherwise, the
+// result is a dagr.RawPoint holding m's fields and, if m is a dagr.TimeMeasurement, its time.
+//
+// m must not be a dagr.MeasurementSet; use ApplyAll or Wrap for those.
+func (rs *RuleSet) Apply(m dagr.Measurement) (dagr.Measurement, bool) {
+	var r relabeling
+	return rs.apply(&r, m)
+}
+
+// apply applies the RuleSet to m using r, which is reset first.
+func (rs *RuleSet) apply(r *relabeling, m dagr.Measurement) (dagr.Measurement, bool) {
+	if len(rs.rules) == 0 {
+		return m, true
+	}
+
+	r.reset(m)
+	for i := range rs.rules {
+		if r.apply(&rs.rules[i]); r.dropped {
+			return nil, false
+		}
+	}
+
+	if !r.changed {
+		return m, true
+	}
+
+	var when time.Time
+	if tm, ok := m.(dagr.TimeMeasurement); ok {
+		when = tm.GetTime()
+	}
+	return dagr.RawPoint{Key: r.key, Tags: r.tags, Fields: r.fields, Time: when}, true
+}
+
+// ApplyAll applies the RuleSet to each of ms, after expanding any MeasurementSets in ms, and returns the measurements
+// that weren't dropped.
+func (rs *RuleSet) ApplyAll(ms ...dagr.Measurement) []dagr.Measurement {
+	ms = dagr.Flatten(ms...)
+	out := make([]dagr.Measurement, 0, len(ms))
+	var r relabeling
+	for _, m := range ms {
+		if m, ok := rs.apply(&r, m); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// Wrap returns a measurement that is m relabeled by the RuleSet. The result is a dagr.MeasurementSet that applies the
+// RuleSet to m, or each of m's measurements if m is a MeasurementSet, each time it's written. So, wrapping a Point
+// or PointSet once is enough to relabel it every time it's written. If m is dropped, the set is empty.
+func (rs *RuleSet) Wrap(m dagr.Measurement) dagr.Measurement {
+	return wrapped{rs, m}
+}
+
+type wrapped struct {
+	rules *RuleSet
+	m     dagr.Measurement
+}
+
+var _ = dagr.MeasurementSet(wrapped{})
+
+func (w wrapped) Measurements() []dagr.Measurement {
+	return w.rules.ApplyAll(w.m)
+}
+
+// As with a PointSet, a wrapped measurement has no key, tags, or fields of its own.
+
+func (w wrapped) GetKey() string         { return "" }
+func (w wrapped) GetTags() dagr.Tags     { return nil }
+func (w wrapped) GetFields() dagr.Fields { return nil }
+
+// Writer is a dagr.MeasurementWriter that relabels measurements before passing them on to another writer.
+type Writer struct {
+	rules *RuleSet
+	w     dagr.MeasurementWriter
+}
+
+var _ = dagr.MeasurementWriter((*Writer)(nil))
+
+// NewWriter allocates a new Writer that relabels measurements with rules and writes them to w. If rules or w is nil,
+// NewWriter panics.
+func NewWriter(rules *RuleSet, w dagr.MeasurementWriter) *Writer {
+	if rules == nil {
+		panic("relabel: NewWriter: rules are nil")
+	} else if w == nil {
+		panic("relabel: NewWriter: writer is nil")
+	}
+	return &Writer{rules, w}
+}
+
+// WriteMeasurements relabels ms and writes the measurements that weren't dropped. If all of ms are dropped, nothing is
+// written and it returns 0 and nil.
+func (w *Writer) WriteMeasurements(ms ...dagr.Measurement) (int64, error) {
+	out := w.rules.ApplyAll(ms...)
+	if len(out) == 0 {
+		return 0, nil
+	}
+	return w.w.WriteMeasurements(out...)
+}
+
+// WriteMeasurement relabels and writes a single measurement.
+func (w *Writer) WriteMeasurement(m dagr.Measurement) (int64, error) {
+	return w.WriteMeasurements(m)
+}
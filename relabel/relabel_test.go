@@ -0,0 +1,160 @@
+package relabel
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.spiff.io/dagr"
+)
+
+var testTime = time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+func TestRuleSet(t *testing.T) {
+	rules := MustCompile(
+		Rule{Source: TagName, Regexp: "pid", Action: Drop},
+		Rule{Source: Key, Regexp: "debug_.*", Action: Drop},
+		Rule{Source: Tag, Name: "path", Regexp: "/internal/.*", Action: Drop},
+		Rule{Source: Key, Regexp: "http_(.*)", Action: Replace, Replacement: "web_$1"},
+		Rule{Source: Tag, Name: "env", Action: Replace, Replacement: "prod"},
+		Rule{Source: Tag, Name: "path", Regexp: "/v(\\d+)/.*", Action: Replace, Target: "version", Replacement: "v$1"},
+		Rule{Source: TagName, Regexp: "host(name)?", Action: Replace, Replacement: "server"},
+		Rule{Source: FieldName, Regexp: "time_(.*)", Action: Replace, Replacement: "elapsed_$1"},
+		Rule{Source: FieldName, Regexp: "secret|(elapsed|count).*", Action: Keep},
+	)
+
+	point := func(key string, tags dagr.Tags) dagr.Measurement {
+		return dagr.RawPoint{
+			Key:    key,
+			Tags:   tags,
+			Fields: dagr.Fields{"count": dagr.RawInt(1), "time_ms": dagr.RawFloat(2.5), "other": dagr.RawBool(true)},
+			Time:   testTime,
+		}
+	}
+
+	ms := []dagr.Measurement{
+		point("http_request", dagr.Tags{"pid": "1234", "hostname": "example.local", "path": "/v1/parrots"}),
+		point("debug_request", nil),
+		point("http_request", dagr.Tags{"path": "/internal/health"}),
+		point("queue", dagr.Tags{"env": "dev"}),
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(rules, dagr.WriteMeasurementsFunc(func(ms ...dagr.Measurement) (int64, error) {
+		return dagr.WriteMeasurements(&buf, ms...)
+	}))
+	if _, err := w.WriteMeasurements(ms...); err != nil {
+		t.Fatalf("WriteMeasurements() error: %v", err)
+	}
+
+	want := `web_request,env=prod,path=/v1/parrots,server=example.local,version=v1 count=1i,elapsed_ms=2.5 1136214245000000000` + "\n" +
+		`queue,env=prod count=1i,elapsed_ms=2.5 1136214245000000000` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WriteMeasurements() =\n%s\nwant\n%s", got, want)
+	}
+
+	// The original measurements are left unmodified
+	if tags := ms[0].GetTags(); tags["pid"] != "1234" {
+		t.Errorf("original tags modified: %v", tags)
+	}
+}
+
+func TestRuleSetUnchanged(t *testing.T) {
+	rules := MustCompile(Rule{Source: Key, Regexp: "other", Action: Drop})
+	p := dagr.NewPoint("key", nil, dagr.Fields{"value": new(dagr.Int)})
+	c := p.Linked()
+
+	if m, ok := rules.Apply(c); !ok || m != c {
+		t.Errorf("Apply(linked) = %v, %t; want the linked point", m, ok)
+	}
+}
+
+func TestDefaultReplacement(t *testing.T) {
+	rules := MustCompile(
+		Rule{Source: Tag, Name: "host", Action: Replace, Target: "server"},
+		Rule{Source: TagName, Regexp: "host_(.*)", Action: Replace},
+	)
+	m := dagr.RawPoint{
+		Key:    "key",
+		Tags:   dagr.Tags{"host": "example.local", "host_dc": "east"},
+		Fields: dagr.Fields{"value": dagr.RawInt(1)},
+	}
+
+	got, ok := rules.Apply(m)
+	if !ok {
+		t.Fatal("Apply() dropped the measurement")
+	}
+	want := dagr.Tags{"host": "example.local", "server": "example.local", "dc": "east"}
+	if tags := got.GetTags(); !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %v; want %v", tags, want)
+	}
+}
+
+func TestApplyAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable with the race detector")
+	}
+	rules := MustCompile(
+		Rule{Source: TagName, Regexp: "pid", Action: Drop},
+		Rule{Source: FieldName, Regexp: "debug_.*", Action: Drop},
+		Rule{Source: Key, Regexp: "http_(.*)", Action: Replace, Replacement: "http_$1"},
+	)
+	var m dagr.Measurement = dagr.RawPoint{
+		Key:    "http_request",
+		Tags:   dagr.Tags{"host": "example.local", "path": "/"},
+		Fields: dagr.Fields{"count": dagr.RawInt(1), "time_taken": dagr.RawFloat(0.5)},
+	}
+
+	// Only the regexp's submatch indices are allocated
+	var r relabeling
+	if n := testing.AllocsPerRun(100, func() { rules.apply(&r, m) }); n > 1 {
+		t.Errorf("apply() allocs = %v; want at most 1", n)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	set := dagr.NewPointSet(dagr.StaticPointAllocator{
+		Key:           "http_request",
+		IdentifierTag: "path",
+		Fields:        dagr.Fields{"count": new(dagr.Int)},
+	})
+	set.FieldsForID("/a", nil)
+	set.FieldsForID("/b", nil)
+
+	rules := MustCompile(
+		Rule{Source: Tag, Name: "path", Regexp: "/b", Action: Drop},
+		Rule{Source: Key, Action: Replace, Replacement: "app.$1"},
+	)
+	wrapped := rules.Wrap(set)
+
+	var buf bytes.Buffer
+	if _, err := dagr.WriteMeasurement(&buf, wrapped); err != nil {
+		t.Fatalf("WriteMeasurement() error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	sort.Strings(lines)
+	for i, line := range lines {
+		lines[i] = line[:strings.LastIndexByte(line, ' ')]
+	}
+	if want := []string{`app.http_request,path=/a count=0i`}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("WriteMeasurement(wrapped) = %q; want %q", lines, want)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := map[string]Rule{
+		"bad regexp":  {Regexp: "("},
+		"no tag name": {Source: Tag},
+		"bad source":  {Source: Source(99)},
+		"bad action":  {Action: Action(99)},
+	}
+	for name, r := range cases {
+		if _, err := Compile(r); err == nil {
+			t.Errorf("%s: Compile() = nil error", name)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package dagr
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SampleRateField is the name of the field SampleWriter adds to each measurement it writes, holding the rate the
+// measurement was sampled at.
+const SampleRateField = "sample_rate"
+
+// SampleWriter is a MeasurementWriter that writes a random sample of the measurements passed to it, for high-volume
+// events (such as RawPoints) that would be too costly to write in full. Each measurement is kept with a probability
+// given by its sample rate, between 0 and 1, and the rate is added to it as a float field named SampleRateField, so
+// that counts can be re-weighted later by dividing by the rate.
+//
+// A measurement's rate is, in order of precedence:
+//
+//   - 1, if Keep is set and returns true for the measurement.
+//   - Its entry in KeyRates, if its key has one.
+//   - An adaptive rate, if Target is > 0. Adaptive rates are tracked per key and are adjusted every Window so that
+//     about Target measurements per second are kept for each key.
+//   - Rate.
+//
+// Rates are clamped to the range [0, 1]. MeasurementSets are expanded, and each of their measurements is sampled
+// individually. Measurements that are kept are written as RawPoints with their original fields and the sample rate
+// field.
+//
+// A SampleWriter's fields must not be modified once it's in use. It is safe to use a SampleWriter from concurrent
+// goroutines.
+type SampleWriter struct {
+	// Rate is the sample rate of measurements that don't have another rate.
+	Rate float64
+
+	// KeyRates maps measurement keys to their sample rates.
+	KeyRates map[string]float64
+
+	// Target, if > 0, is the number of measurements per second to keep for each key without an entry in KeyRates.
+	Target float64
+
+	// Window is how often adaptive rates are adjusted. If Window is <= 0, it's one second.
+	Window time.Duration
+
+	// Keep, if not nil, is called with each measurement. If it returns true, the measurement is always kept.
+	Keep func(Measurement) bool
+
+	// Rand, if not nil, returns random numbers in [0, 1) used for sampling. If Rand is nil, math/rand is used.
+	Rand func() float64
+
+	w MeasurementWriter
+
+	m        sync.Mutex // controls adaptive
+	adaptive map[string]*adaptiveRate
+}
+
+var _ = MeasurementWriter((*SampleWriter)(nil))
+
+// adaptiveRate is the adaptive sample rate of a single key.
+type adaptiveRate struct {
+	start time.Time // The start of the current window
+	seen  int       // The number of measurements seen in the current window
+	rate  float64
+}
+
+// NewSampleWriter allocates a new SampleWriter that writes measurements sampled at the given rate to w. If w is nil,
+// NewSampleWriter panics.
+func NewSampleWriter(w MeasurementWriter, rate float64) *SampleWriter {
+	if w == nil {
+		panic("dagr: NewSampleWriter: writer is nil")
+	}
+	return &SampleWriter{Rate: rate, w: w}
+}
+
+// IsTrue returns a function for SampleWriter's Keep that returns true for measurements with a bool field of the given
+// name that is true (e.g., IsTrue("fatal")).
+func IsTrue(field string) func(Measurement) bool {
+	return func(m Measurement) bool {
+		v, _ := FieldValue(m.GetFields()[field]).(bool)
+		return v
+	}
+}
+
+func clampRate(rate float64) float64 {
+	if rate < 0 || rate != rate {
+		return 0
+	} else if rate > 1 {
+		return 1
+	}
+	return rate
+}
+
+// rate returns the sample rate of m.
+func (s *SampleWriter) rate(m Measurement, now time.Time) float64 {
+	if s.Keep != nil && s.Keep(m) {
+		return 1
+	}
+
+	key := m.GetKey()
+	if rate, ok := s.KeyRates[key]; ok {
+		return clampRate(rate)
+	} else if s.Target > 0 {
+		return s.adaptiveRate(key, now)
+	}
+	return clampRate(s.Rate)
+}
+
+// adaptiveRate records a measurement for key and returns its current adaptive rate.
+func (s *SampleWriter) adaptiveRate(key string, now time.Time) float64 {
+	window := s.Window
+	if window <= 0 {
+		window = time.Second
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.adaptive == nil {
+		s.adaptive = make(map[string]*adaptiveRate)
+	}
+
+	a, ok := s.adaptive[key]
+	if !ok {
+		a = &adaptiveRate{start: now, rate: 1}
+		s.adaptive[key] = a
+	} else if elapsed := now.Sub(a.start); elapsed >= window {
+		if observed := float64(a.seen) / elapsed.Seconds(); observed > 0 {
+			a.rate = clampRate(s.Target / observed)
+		}
+		a.start, a.seen = now, 0
+	}
+
+	a.seen++
+	return a.rate
+}
+
+func (s *SampleWriter) random() float64 {
+	if s.Rand != nil {
+		return s.Rand()
+	}
+	return rand.Float64()
+}
+
+// Sample returns the measurements in ms that are kept, each with its sample rate field added. MeasurementSets in ms are
+// expanded.
+func (s *SampleWriter) Sample(ms ...Measurement) []Measurement {
+	ms = Flatten(ms...)
+	now := clock.Now()
+
+	out := make([]Measurement, 0, len(ms))
+	for _, m := range ms {
+		rate := s.rate(m, now)
+		if rate <= 0 || rate < 1 && s.random() >= rate {
+			continue
+		}
+
+		fields := m.GetFields().Dup(false)
+		if fields == nil {
+			continue
+		}
+		fields[SampleRateField] = RawFloat(rate)
+
+		var when time.Time
+		if tm, ok := m.(TimeMeasurement); ok {
+			when = tm.GetTime()
+		}
+		out = append(out, RawPoint{Key: m.GetKey(), Tags: m.GetTags(), Fields: fields, Time: when})
+	}
+	return out
+}
+
+// WriteMeasurements writes a sample of ms to the SampleWriter's underlying writer. If no measurements are kept,
+// nothing is written and it returns 0 and nil.
+func (s *SampleWriter) WriteMeasurements(ms ...Measurement) (int64, error) {
+	out := s.Sample(ms...)
+	if len(out) == 0 {
+		return 0, nil
+	}
+	return s.w.WriteMeasurements(out...)
+}
+
+// WriteMeasurement samples and writes a single measurement.
+func (s *SampleWriter) WriteMeasurement(m Measurement) (int64, error) {
+	return s.WriteMeasurements(m)
+}
@@ -0,0 +1,89 @@
+package dagr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSampleWriter(t *testing.T) {
+	defer prepareLogger(t)()
+	defer func(c timeSource) { clock = c }(clock)
+
+	var written []Measurement
+	w := NewSampleWriter(WriteMeasurementsFunc(func(ms ...Measurement) (int64, error) {
+		written = append(written, ms...)
+		return int64(len(ms)), nil
+	}), 0.25)
+
+	// Deterministic random numbers cycling through 0, 0.1, ..., 0.9
+	var n int
+	w.Rand = func() float64 {
+		n++
+		return float64(n%10) / 10
+	}
+	w.KeyRates = map[string]float64{"rare": 1, "dropped": 0}
+	w.Keep = IsTrue("fatal")
+
+	event := func(key string, fatal bool) Measurement {
+		return RawPoint{Key: key, Fields: Fields{"fatal": RawBool(fatal)}, Time: testTime}
+	}
+
+	count := func(key string) (n int, rate interface{}) {
+		for _, m := range written {
+			if m.GetKey() == key {
+				n++
+				rate = FieldValue(m.GetFields()[SampleRateField])
+			}
+		}
+		return n, rate
+	}
+
+	for i := 0; i < 100; i++ {
+		w.WriteMeasurements(event("common", false), event("rare", false), event("dropped", false))
+	}
+	w.WriteMeasurement(event("dropped", true))
+
+	if n, rate := count("common"); n != 30 || rate != 0.25 {
+		t.Errorf("common: kept %d at rate %v; want 30 at 0.25", n, rate)
+	}
+	if n, rate := count("rare"); n != 100 || rate != 1.0 {
+		t.Errorf("rare: kept %d at rate %v; want 100 at 1", n, rate)
+	}
+	if n, rate := count("dropped"); n != 1 || rate != 1.0 {
+		t.Errorf("dropped: kept %d at rate %v; want 1 (fatal) at 1", n, rate)
+	}
+	if m := written[0]; !m.(TimeMeasurement).GetTime().Equal(testTime) {
+		t.Errorf("time = %v; want %v", m.(TimeMeasurement).GetTime(), testTime)
+	}
+}
+
+func TestSampleWriterAdaptive(t *testing.T) {
+	defer prepareLogger(t)()
+	defer func(c timeSource) { clock = c }(clock)
+
+	now := testTime
+	w := NewSampleWriter(WriteMeasurementsFunc(func(ms ...Measurement) (int64, error) {
+		return 0, nil
+	}), 1)
+	w.Target = 10
+
+	event := RawPoint{Key: "event", Fields: Fields{"value": RawInt(1)}}
+	var rates []float64
+	for sec := 0; sec < 3; sec++ {
+		clock = testClock(now.Add(time.Duration(sec) * time.Second))
+		for i := 0; i < 100; i++ {
+			rates = append(rates, w.adaptiveRate(event.Key, clock.Now()))
+		}
+	}
+
+	// The first window is sampled at 1 until a rate is known, after which 100 events per second are sampled down to 10.
+	if rates[0] != 1 || rates[99] != 1 {
+		t.Errorf("first window rate = %v; want 1", rates[0])
+	}
+	if r := rates[100]; r != 0.1 {
+		t.Errorf("second window rate = %v; want 0.1", r)
+	}
+	if r := rates[299]; r != 0.1 {
+		t.Errorf("third window rate = %v; want 0.1", r)
+	}
+}
@@ -80,14 +80,16 @@ func Snapshot(m Measurement) TimeMeasurement {
 	}
 
 	for name, field := range srcFields {
-		switch f := field.(type) {
-		case SnapshotField:
-			field = f.Snapshot()
-		default:
-			field = f.Dup()
-		}
-		fields[name] = field
+		fields[name] = snapshotField(field)
 	}
 
 	return timePoint{key, when, tags, fields}
 }
+
+// snapshotField returns a snapshot of f if it implements SnapshotField, otherwise it returns a duplicate of f.
+func snapshotField(f Field) Field {
+	if sf, ok := f.(SnapshotField); ok {
+		return sf.Snapshot()
+	}
+	return f.Dup()
+}
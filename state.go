@@ -0,0 +1,383 @@
+package dagr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// stateVersion is the version of the state file format written by StateStore.
+const stateVersion = 1
+
+// StateStore saves the field values of a Registry's measurements to a file and restores them, so that accumulating
+// fields such as counters survive process restarts. Measurements are saved under their names in the Registry, and may
+// be any Measurement (such as a *Point), a *PointSet, or a *TagPointSet. The points of PointSets are saved by identifier
+// (or tuple, for a TagPointSet) and are allocated again when restored. A PointSet's other point is saved in an entry of
+// its own, along with its evictions and rejections fields, so that overflow totals also survive restarts.
+//
+// State files are written atomically: a StateStore writes to a temporary file in the same directory and renames it over
+// the state file, so an interrupted save leaves the previous state intact. Field values are stored with their types,
+// using the fields' JSON encodings.
+//
+// Restoring state tolerates changes to measurements between versions of a program: measurements, points, and fields in
+// the state file that are no longer registered are ignored, as are fields whose saved value can't be decoded into the
+// current field. Only fields that implement json.Unmarshaler, such as Int, Float, Bool, and String, are restored.
+//
+// A typical use is to register measurements, call Restore, and then call Start:
+//
+//	dagr.DefaultRegistry.Register("requests", requests)
+//	store := dagr.NewStateStore("/var/lib/myapp/metrics.json", nil)
+//	if err := store.Restore(); err != nil {
+//		log.Printf("Unable to restore metrics: %v", err)
+//	}
+//	store.Start(ctx, time.Minute)
+type StateStore struct {
+	path     string
+	registry *Registry
+
+	saveLock sync.Mutex // Serializes saves
+}
+
+// NewStateStore allocates a new StateStore that saves the measurements of registry to the file at path. If registry is
+// nil, DefaultRegistry is used. If path is empty, NewStateStore panics.
+func NewStateStore(path string, registry *Registry) *StateStore {
+	if path == "" {
+		panic("dagr.NewStateStore: path is empty")
+	}
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return &StateStore{path: path, registry: registry}
+}
+
+// Path returns the path of the StateStore's state file.
+func (s *StateStore) Path() string {
+	return s.path
+}
+
+// Registry returns the Registry whose measurements the StateStore saves.
+func (s *StateStore) Registry() *Registry {
+	return s.registry
+}
+
+// State file format
+
+type stateFile struct {
+	Version      int                     `json:"version"`
+	Time         int64                   `json:"time"`
+	Measurements map[string][]statePoint `json:"measurements"`
+}
+
+type statePoint struct {
+	ID     *string               `json:"id,omitempty"`     // Set for points of a PointSet
+	Other  bool                  `json:"other,omitempty"`  // Set for the other point of a PointSet
+	Values []string              `json:"values,omitempty"` // Set for points of a TagPointSet
+	Key    string                `json:"key"`
+	Tags   Tags                  `json:"tags,omitempty"`
+	Fields map[string]stateField `json:"fields"`
+}
+
+type stateField struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// newStatePoint returns the state of m, or false if m has no fields that can be saved.
+func newStatePoint(m Measurement) (statePoint, bool) {
+	snap := Snapshot(m)
+	if snap == nil {
+		return statePoint{}, false
+	}
+
+	sp := statePoint{
+		Key:    snap.GetKey(),
+		Tags:   snap.GetTags(),
+		Fields: make(map[string]stateField),
+	}
+
+	for name, f := range snap.GetFields() {
+		var typ string
+		switch v := FieldValue(f).(type) {
+		case int64:
+			typ = "int"
+		case uint64:
+			typ = "uint"
+		case float64:
+			typ = "float"
+		case bool:
+			typ = "bool"
+		case string:
+			typ = "string"
+			f = RawString(v) // RawString has no JSON encoding of its own
+		default:
+			continue
+		}
+
+		value, err := json.Marshal(f)
+		if err != nil {
+			continue
+		}
+		sp.Fields[name] = stateField{typ, value}
+	}
+
+	return sp, len(sp.Fields) > 0
+}
+
+// restore sets the fields of fields to their values in sp. Fields that don't exist, can't be decoded into, or whose
+// saved value has a different type are skipped. If sum is true, saved values are added to Int and Float fields instead
+// and other fields are skipped, for points restored into a PointSet's other point.
+func (sp statePoint) restore(fields Fields, sum bool) {
+	for name, sf := range sp.Fields {
+		f, ok := fields[name]
+		if !ok {
+			continue
+		} else if sum {
+			sf.add(f)
+			continue
+		}
+
+		u, ok := f.(json.Unmarshaler)
+		if !ok || !stateTypeMatches(sf.Type, FieldValue(f)) {
+			continue
+		}
+
+		if err := u.UnmarshalJSON(sf.Value); err != nil {
+			Log.Printf("dagr: unable to restore field %q of %q: %v", name, sp.Key, err)
+		}
+	}
+}
+
+// add adds the saved value to f if f is an Int or Float and the value's type matches it.
+func (sf stateField) add(f Field) {
+	switch f := f.(type) {
+	case *Int:
+		var n Int
+		if sf.Type == "int" && n.UnmarshalJSON(sf.Value) == nil {
+			f.Add(n.sample())
+		}
+	case *Float:
+		var n Float
+		if (sf.Type == "float" || sf.Type == "int") && n.UnmarshalJSON(sf.Value) == nil {
+			f.Add(n.sample())
+		}
+	}
+}
+
+// stateTypeMatches returns whether a saved value of type typ may be restored to a field whose current value is v. Ints
+// may be restored to floats, as changing a counter from an Int to a Float is a compatible change.
+func stateTypeMatches(typ string, v interface{}) bool {
+	switch v.(type) {
+	case int64:
+		return typ == "int"
+	case uint64:
+		return typ == "uint"
+	case float64:
+		return typ == "float" || typ == "int"
+	case bool:
+		return typ == "bool"
+	case string:
+		return typ == "string"
+	}
+	return false
+}
+
+// Save writes the current state of all registered measurements to the StateStore's file. The file is replaced
+// atomically, so if Save fails, the previous state file is left as it was.
+func (s *StateStore) Save() error {
+	s.saveLock.Lock()
+	defer s.saveLock.Unlock()
+
+	ms := make(map[string]Measurement, s.registry.Len())
+	s.registry.Walk(func(name string, m Measurement) bool {
+		ms[name] = m
+		return true
+	})
+
+	state := stateFile{
+		Version:      stateVersion,
+		Time:         clock.Now().UnixNano(),
+		Measurements: make(map[string][]statePoint, len(ms)),
+	}
+
+	for name, m := range ms {
+		var points []statePoint
+		switch m := m.(type) {
+		case *PointSet:
+			m.Each(func(id string, m Measurement) bool {
+				if sp, ok := newStatePoint(m); ok {
+					id := id
+					sp.ID = &id
+					points = append(points, sp)
+				}
+				return true
+			})
+			if other := m.otherPoint(); other != nil {
+				if sp, ok := newStatePoint(other); ok {
+					sp.Other = true
+					points = append(points, sp)
+				}
+			}
+		case *TagPointSet:
+			m.Each(nil, func(values []string, pt *Point) bool {
+				if sp, ok := newStatePoint(pt); ok {
+					sp.Values = append([]string(nil), values...)
+					points = append(points, sp)
+				}
+				return true
+			})
+			sort.Slice(points, func(i, j int) bool {
+				a, b := points[i].Values, points[j].Values
+				for k := range a {
+					if a[k] != b[k] {
+						return a[k] < b[k]
+					}
+				}
+				return false
+			})
+		default:
+			if sp, ok := newStatePoint(m); ok {
+				points = append(points, sp)
+			}
+		}
+
+		if len(points) > 0 {
+			state.Measurements[name] = points
+		}
+	}
+
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, append(data, '\n'))
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as path and renames it to path once it's been
+// written and synced.
+func writeFileAtomic(path string, data []byte) (err error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	} else if err = f.Sync(); err != nil {
+		return err
+	} else if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Restore reads the StateStore's file and restores the saved field values of registered measurements. If the file
+// doesn't exist, Restore does nothing and returns nil. Points of PointSets and TagPointSets that don't exist are
+// allocated, the same as if their fields had been requested, except that the opaque value passed to their allocators is
+// always nil. Allocators of PointSets and TagPointSets that are restored must handle a nil opaque value.
+//
+// Restore sets fields to their saved values, replacing their current values, so it should be called before the
+// measurements are used. A PointSet's saved other point is restored to its current other point, if it has one. Saved
+// points of a PointSet whose identifiers are redirected to its other point, such as when the PointSet's limit is lower
+// than when it was saved, are then added to the other point's Int and Float fields.
+func (s *StateStore) Restore() error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var state stateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("dagr: unable to decode state file %s: %w", s.path, err)
+	} else if state.Version > stateVersion {
+		return fmt.Errorf("dagr: state file %s has unsupported version %d", s.path, state.Version)
+	}
+
+	for name, points := range state.Measurements {
+		m := s.registry.Get(name)
+		if m == nil {
+			continue
+		}
+
+		// The other point is restored first, so that points redirected to it are added to its saved values
+		sort.SliceStable(points, func(i, j int) bool { return points[i].Other && !points[j].Other })
+
+		for _, sp := range points {
+			var (
+				fields Fields
+				other  bool
+			)
+			switch m := m.(type) {
+			case *PointSet:
+				if sp.Other {
+					if o := m.otherPoint(); o != nil {
+						fields = o.Point().GetFields()
+					}
+				} else if sp.ID != nil {
+					fields, other = m.fieldsForRestore(*sp.ID)
+				}
+			case *TagPointSet:
+				if sp.Values != nil {
+					fields = m.FieldsFor(sp.Values, nil)
+				}
+			default:
+				fields = m.GetFields()
+			}
+			sp.restore(fields, other)
+		}
+	}
+
+	return nil
+}
+
+// Start creates a goroutine that saves the StateStore's file at the given interval. When the context is done, the
+// state is saved one last time. Errors are logged. Since a process may exit before the final save completes, it's
+// a good idea to also call Save during shutdown.
+//
+// The context may not be nil.
+func (s *StateStore) Start(ctx context.Context, interval time.Duration) {
+	if ctx == nil {
+		panic("dagr: context is nil")
+	} else if interval <= 0 {
+		panic("dagr: interval must be > 0")
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				if err := s.Save(); err != nil {
+					Log.Printf("dagr: final save of state to %s failed: %v", s.path, err)
+				}
+				return
+			case <-ticker.C:
+				if err := s.Save(); err != nil {
+					Log.Printf("dagr: save of state to %s failed: %v", s.path, err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,182 @@
+package dagr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStateStore(t *testing.T) {
+	defer prepareLogger(t)()
+
+	dir, err := ioutil.TempDir("", "dagr-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	alloc := StaticPointAllocator{Key: "http_request", IdentifierTag: "path", Fields: Fields{"count": new(Int)}}
+	tagAlloc := StaticPointAllocator{Key: "http_status", Fields: Fields{"count": new(Int)}}
+
+	// First run
+	{
+		requests, latency, stage := new(Int), new(Int), new(String)
+		requests.Set(12)
+		latency.Set(3)
+		stage.Set(`running "fast"`)
+		pt := NewPoint("process", nil, Fields{"requests": requests, "latency": latency, "stage": stage, "removed": new(Int)})
+
+		ps := NewPointSet(alloc)
+		ps.FieldsForID("/a", nil)["count"].(*Int).Add(4)
+		ps.FieldsForID("/b", nil)["count"].(*Int).Add(5)
+
+		tps := NewTagPointSet(tagAlloc, "method", "status")
+		tps.FieldsFor([]string{"GET", "200"}, nil)["count"].(*Int).Add(6)
+
+		reg := new(Registry)
+		reg.Register("process", pt)
+		reg.Register("requests", ps)
+		reg.Register("statuses", tps)
+		store := NewStateStore(path, reg)
+		if err := store.Restore(); err != nil {
+			t.Fatalf("Restore() with no state file error: %v", err)
+		}
+		if err := store.Save(); err != nil {
+			t.Fatalf("Save() error: %v", err)
+		}
+	}
+
+	// Second run, with a changed schema: latency is now a Float, requests is a Bool, and removed is gone.
+	requests, latency, stage, added := new(Bool), new(Float), new(String), new(Int)
+	pt := NewPoint("process", nil, Fields{"requests": requests, "latency": latency, "stage": stage, "added": added})
+	ps := NewPointSet(alloc)
+	tps := NewTagPointSet(tagAlloc, "method", "status")
+
+	reg := new(Registry)
+	reg.Register("process", pt)
+	reg.Register("requests", ps)
+	reg.Register("statuses", tps)
+	if err := NewStateStore(path, reg).Restore(); err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+
+	checks := []struct {
+		name string
+		f    Field
+		want interface{}
+	}{
+		{"requests", requests, false},
+		{"latency", latency, 3.0},
+		{"stage", stage, `running "fast"`},
+		{"added", added, int64(0)},
+		{"/a count", ps.FieldsForID("/a", nil)["count"], int64(4)},
+		{"/b count", ps.FieldsForID("/b", nil)["count"], int64(5)},
+		{"GET 200 count", tps.FieldsFor([]string{"GET", "200"}, nil)["count"], int64(6)},
+	}
+	for _, c := range checks {
+		if got := FieldValue(c.f); got != c.want {
+			t.Errorf("%s = %#v; want %#v", c.name, got, c.want)
+		}
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".state.json.tmp*")); len(matches) > 0 {
+		t.Errorf("temporary files left behind: %q", matches)
+	}
+}
+
+func TestStateStoreRestoreOther(t *testing.T) {
+	defer prepareLogger(t)()
+
+	dir, err := ioutil.TempDir("", "dagr-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	newSet := func(max int) (*PointSet, *Int) {
+		count := new(Int)
+		ps := NewPointSet(StaticPointAllocator{Key: "http_request", IdentifierTag: "path", Fields: Fields{"count": new(Int)}})
+		if max > 0 {
+			ps.SetLimit(PointSetLimit{
+				Max:    max,
+				Policy: RejectNew,
+				Other:  NewPoint("http_request", Tags{"path": "other"}, Fields{"count": count}),
+			})
+		}
+		return ps, count
+	}
+
+	ps, _ := newSet(0)
+	for i, path := range []string{"/a", "/b", "/c"} {
+		ps.FieldsForID(path, nil)["count"].(*Int).Add(int64(i + 1))
+	}
+	reg := new(Registry)
+	reg.Register("requests", ps)
+	if err := NewStateStore(path, reg).Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	// Restored with a lower limit: /b and /c are redirected to other
+	ps, other := newSet(1)
+	reg = new(Registry)
+	reg.Register("requests", ps)
+	if err := NewStateStore(path, reg).Restore(); err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+
+	if got := ps.IDs(); !reflect.DeepEqual(got, []string{"/a"}) {
+		t.Errorf("IDs() = %q; want [/a]", got)
+	}
+	if n := other.sample(); n != 5 {
+		t.Errorf("other count = %d; want 5", n)
+	}
+
+	// The other point is saved along with its rejections and restored in place
+	if err := NewStateStore(path, reg).Save(); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	ps, other = newSet(1)
+	reg = new(Registry)
+	reg.Register("requests", ps)
+	if err := NewStateStore(path, reg).Restore(); err != nil {
+		t.Fatalf("Restore() error: %v", err)
+	}
+
+	if n := other.sample(); n != 5 {
+		t.Errorf("restored other count = %d; want 5", n)
+	}
+	if n := ps.Rejections().sample(); n != 2 {
+		t.Errorf("restored rejections = %d; want 2", n)
+	}
+}
+
+func TestStateStoreBadFile(t *testing.T) {
+	defer prepareLogger(t)()
+
+	f, err := ioutil.TempFile("", "dagr-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"version": 99, "measurements": {}}`)
+	f.Close()
+
+	if err := NewStateStore(f.Name(), new(Registry)).Restore(); err == nil {
+		t.Error("Restore() with unsupported version = nil; want error")
+	}
+}
+
+func TestIntUnmarshalJSONError(t *testing.T) {
+	n := new(Int)
+	n.Set(5)
+	if err := n.UnmarshalJSON([]byte(`1.5`)); err == nil {
+		t.Error("UnmarshalJSON(1.5) = nil; want error")
+	}
+	if v := n.sample(); v != 5 {
+		t.Errorf("Int = %d after failed UnmarshalJSON; want 5", v)
+	}
+}
@@ -0,0 +1,311 @@
+package statsd
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.spiff.io/dagr"
+)
+
+// DefaultMaxPacketSize is the default maximum size of a datagram sent by a Client. It's small enough to avoid
+// fragmentation on most networks with an MTU of 1500.
+const DefaultMaxPacketSize = 1432
+
+// TypeFunc returns the StatsD type to send a field as. If it returns an empty Type, the field is not sent.
+type TypeFunc func(key, field string, value dagr.Field) Type
+
+// DefaultType sends numeric fields whose names end in "_ms" or "_msec" as timers, since their values are durations in
+// milliseconds (e.g., "time_taken_ms"). Other Int fields are sent as counters and all other numeric and boolean fields
+// as gauges. String fields are not sent. Booleans are sent as 1 for true and 0 for false.
+func DefaultType(key, field string, value dagr.Field) Type {
+	switch dagr.FieldValue(value).(type) {
+	case int64:
+		if isDuration(field) {
+			return Timer
+		}
+		return Counter
+	case uint64, float64:
+		if isDuration(field) {
+			return Timer
+		}
+		return Gauge
+	case bool:
+		return Gauge
+	}
+	return ""
+}
+
+// isDuration returns whether a field's name says it's a duration in milliseconds.
+func isDuration(field string) bool {
+	return strings.HasSuffix(field, "_ms") || strings.HasSuffix(field, "_msec")
+}
+
+// Client sends dagr measurements as StatsD datagrams. Each field of a measurement is sent as a metric named after the
+// measurement's key and the field's name, separated by a period (e.g., "http_request.count"), and tags are sent as
+// DogStatsD tags.
+//
+// Counters are sent as deltas: the Client remembers the last value it sent for each counter, by name and tags, and
+// only sends the difference between that and the counter's current value. A counter's value is only remembered once
+// the datagram holding it has been sent, so a delta that fails to send is included in the next one. If a counter's
+// value is less than the last value sent, the counter is assumed to have been reset (e.g., by Int.Set(0)) and its
+// value is sent as the delta. Counters that haven't changed are not sent. Gauges are always sent, and timers are sent
+// as-is in milliseconds.
+//
+// Metrics are batched into datagrams no larger than MaxPacketSize. A Client's exported fields must not be modified
+// once it's in use. It is safe to use a Client from concurrent goroutines.
+type Client struct {
+	// Prefix is prepended to every metric name.
+	Prefix string
+
+	// MaxPacketSize is the largest datagram the Client will send. If <= 0, DefaultMaxPacketSize is used. Metrics that
+	// are larger than MaxPacketSize on their own are sent in a datagram by themselves.
+	MaxPacketSize int
+
+	// OmitTags disables sending DogStatsD tags, for servers that don't understand them.
+	OmitTags bool
+
+	// Type decides how each field is sent. If nil, DefaultType is used.
+	Type TypeFunc
+
+	w io.Writer
+
+	m       sync.Mutex // controls last, pending, and buf
+	last    map[string]float64
+	pending map[string]float64 // Counter values being sent, in case a counter is written twice
+	buf     bytes.Buffer
+}
+
+var _ = dagr.MeasurementWriter((*Client)(nil))
+
+// NewClient allocates a Client that sends datagrams to w. Each datagram is sent as a single call to w's Write method,
+// so w is typically a *net.UDPConn or other packet-oriented connection.
+func NewClient(w io.Writer) *Client {
+	return &Client{w: w, last: make(map[string]float64), pending: make(map[string]float64)}
+}
+
+// Dial connects to the StatsD server at addr over UDP and returns a Client for it.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// Close closes the Client's writer if it implements io.Closer.
+func (c *Client) Close() error {
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *Client) maxPacketSize() int {
+	if c.MaxPacketSize <= 0 {
+		return DefaultMaxPacketSize
+	}
+	return c.MaxPacketSize
+}
+
+func (c *Client) typeOf(key, field string, value dagr.Field) Type {
+	if c.Type == nil {
+		return DefaultType(key, field, value)
+	}
+	return c.Type(key, field, value)
+}
+
+// WriteMeasurement sends a single measurement. This is the same as calling WriteMeasurements with a single
+// measurement.
+func (c *Client) WriteMeasurement(m dagr.Measurement) (int64, error) {
+	return c.WriteMeasurements(m)
+}
+
+// metricLine is a single metric to send. Counters have the ID they're remembered by and the value to remember once
+// the line has been sent.
+type metricLine struct {
+	text  string
+	id    string
+	value float64
+}
+
+// WriteMeasurements sends all measurements in ms, batched into as few datagrams as possible. MeasurementSets are
+// expanded into their measurements. It returns the number of bytes sent and the first error that occurred sending
+// them. Counter values in datagrams that fail to send are not remembered, so their deltas are sent again next time.
+func (c *Client) WriteMeasurements(ms ...dagr.Measurement) (n int64, err error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	defer func() {
+		for id := range c.pending {
+			delete(c.pending, id)
+		}
+	}()
+
+	var (
+		lines []metricLine
+		names []string
+	)
+	for _, m := range dagr.Flatten(ms...) {
+		key, fields := m.GetKey(), m.GetFields()
+		if key == "" || len(fields) == 0 {
+			continue
+		}
+
+		var suffix string
+		if tags := m.GetTags(); len(tags) > 0 {
+			suffix = tagSuffix(tags)
+		}
+
+		names = names[:0]
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			lines = c.appendMetric(lines, key, name, fields[name], suffix)
+		}
+	}
+
+	return c.send(lines)
+}
+
+// appendMetric appends the metric lines for a single field to lines. Counters are remembered by their name and tags
+// suffix, even if tags are omitted, and their values are kept in pending until they're sent.
+func (c *Client) appendMetric(lines []metricLine, key, name string, field dagr.Field, tags string) []metricLine {
+	typ := c.typeOf(key, name, field)
+	if typ == "" {
+		return lines
+	}
+
+	var v float64
+	switch fv := dagr.FieldValue(field).(type) {
+	case int64:
+		v = float64(fv)
+	case uint64:
+		v = float64(fv)
+	case float64:
+		v = fv
+	case bool:
+		if fv {
+			v = 1
+		}
+	default:
+		return lines
+	}
+
+	metric := c.Prefix + nameReplacer.Replace(key) + "." + nameReplacer.Replace(name)
+	suffix := tags
+	if c.OmitTags {
+		suffix = ""
+	}
+
+	var (
+		id    string
+		value = v
+	)
+	switch typ {
+	case Counter:
+		id = metric + tags
+		last, ok := c.pending[id]
+		if !ok {
+			last = c.last[id]
+		}
+		c.pending[id] = value
+
+		switch {
+		case v == last:
+			return lines
+		case v < last:
+			// The counter was reset, so everything it's counted since is new.
+			if v == 0 {
+				c.last[id] = 0
+				return lines
+			}
+		default:
+			v -= last
+		}
+	case Gauge:
+		// A gauge value with a sign is relative, so negative gauges must first be set to zero.
+		if v < 0 {
+			lines = append(lines, metricLine{text: metric + ":0|g" + suffix})
+		}
+	}
+
+	text := metric + ":" + strconv.FormatFloat(v, 'f', -1, 64) + "|" + string(typ) + suffix
+	return append(lines, metricLine{text, id, value})
+}
+
+// send writes lines to the Client's writer, packing as many lines as possible into each datagram. The values of
+// counters in each datagram written in full are remembered.
+func (c *Client) send(lines []metricLine) (n int64, err error) {
+	max := c.maxPacketSize()
+	start := 0 // The first line in the buffer
+	flush := func(end int) {
+		if c.buf.Len() == 0 {
+			return
+		}
+		wn, werr := c.w.Write(c.buf.Bytes())
+		n += int64(wn)
+		if werr == nil && wn < c.buf.Len() {
+			werr = io.ErrShortWrite
+		}
+
+		if werr == nil {
+			for _, line := range lines[start:end] {
+				if line.id != "" {
+					c.last[line.id] = line.value
+				}
+			}
+		} else if err == nil {
+			err = werr
+		}
+		c.buf.Reset()
+		start = end
+	}
+
+	for i, line := range lines {
+		if c.buf.Len() > 0 && c.buf.Len()+1+len(line.text) > max {
+			flush(i)
+		}
+
+		if c.buf.Len() > 0 {
+			c.buf.WriteByte('\n')
+		}
+		c.buf.WriteString(line.text)
+	}
+	flush(len(lines))
+
+	return n, err
+}
+
+var (
+	nameReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_")
+	tagReplacer  = strings.NewReplacer("|", "_", "#", "_", ",", "_", "\n", "_")
+)
+
+// tagSuffix returns tags formatted as DogStatsD tags, in ascending order by name.
+func tagSuffix(tags dagr.Tags) string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString("|#")
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(tagReplacer.Replace(strings.Replace(name, ":", "_", -1)))
+		buf.WriteByte(':')
+		buf.WriteString(tagReplacer.Replace(tags[name]))
+	}
+	return buf.String()
+}
@@ -0,0 +1,228 @@
+package statsd
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.spiff.io/dagr"
+)
+
+// packetRecorder records each write as a separate datagram.
+type packetRecorder [][]byte
+
+func (p *packetRecorder) Write(b []byte) (int, error) {
+	*p = append(*p, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (p *packetRecorder) lines() []string {
+	var lines []string
+	for _, packet := range *p {
+		lines = append(lines, strings.Split(string(packet), "\n")...)
+	}
+	*p = nil
+	return lines
+}
+
+func TestClientDeltas(t *testing.T) {
+	var rec packetRecorder
+	c := NewClient(&rec)
+	c.Prefix = "app."
+	c.Type = func(key, field string, value dagr.Field) Type {
+		if field == "time_taken" {
+			return Timer
+		}
+		return DefaultType(key, field, value)
+	}
+
+	count, temp := new(dagr.Int), new(dagr.Float)
+	p := dagr.NewPoint("http", dagr.Tags{"host": "example.local", "path": "/kittens"}, dagr.Fields{
+		"count":      count,
+		"temp":       temp,
+		"time_taken": dagr.RawFloat(12.5),
+		"msg":        dagr.RawString("skipped"),
+	})
+
+	count.Add(5)
+	temp.Set(-2.5)
+	if _, err := c.WriteMeasurement(p); err != nil {
+		t.Fatal(err)
+	}
+
+	const tags = "|#host:example.local,path:/kittens"
+	want := []string{
+		"app.http.count:5|c" + tags,
+		"app.http.temp:0|g" + tags,
+		"app.http.temp:-2.5|g" + tags,
+		"app.http.time_taken:12.5|ms" + tags,
+	}
+	if got := rec.lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lines = %q; want %q", got, want)
+	}
+
+	// Only the change in count is sent, and unchanged counters aren't sent at all.
+	count.Add(3)
+	temp.Set(1)
+	c.OmitTags = true
+	c.WriteMeasurement(p)
+	c.WriteMeasurement(p)
+
+	want = []string{
+		"app.http.count:3|c",
+		"app.http.temp:1|g",
+		"app.http.time_taken:12.5|ms",
+		"app.http.temp:1|g",
+		"app.http.time_taken:12.5|ms",
+	}
+	if got := rec.lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lines = %q; want %q", got, want)
+	}
+}
+
+// failWriter fails every write while fail is set, and otherwise records datagrams.
+type failWriter struct {
+	packetRecorder
+	fail bool
+}
+
+func (w *failWriter) Write(b []byte) (int, error) {
+	if w.fail {
+		return 0, errors.New("unreachable")
+	}
+	return w.packetRecorder.Write(b)
+}
+
+func TestClientCounterFailuresAndResets(t *testing.T) {
+	var w failWriter
+	c := NewClient(&w)
+
+	count := new(dagr.Int)
+	p := dagr.NewPoint("jobs", nil, dagr.Fields{"count": count})
+
+	count.Add(5)
+	c.WriteMeasurement(p)
+
+	// A failed send isn't remembered, so its delta is included in the next send.
+	count.Add(2)
+	w.fail = true
+	if _, err := c.WriteMeasurement(p); err == nil {
+		t.Fatal("WriteMeasurement() = nil; want error")
+	}
+	w.fail = false
+	count.Add(1)
+	c.WriteMeasurement(p)
+
+	// A counter that goes backwards was reset, so its new value is sent.
+	count.Set(4)
+	c.WriteMeasurement(p)
+	count.Set(0)
+	c.WriteMeasurement(p)
+	count.Add(1)
+	c.WriteMeasurement(p)
+
+	want := []string{
+		"jobs.count:5|c",
+		"jobs.count:3|c",
+		"jobs.count:4|c",
+		"jobs.count:1|c",
+	}
+	if got := w.lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lines = %q; want %q", got, want)
+	}
+}
+
+func TestDefaultTypeTimer(t *testing.T) {
+	cases := []struct {
+		field string
+		value dagr.Field
+		want  Type
+	}{
+		{"time_taken_ms", dagr.RawFloat(1.5), Timer},
+		{"wait_msec", dagr.RawInt(10), Timer},
+		{"count", dagr.RawInt(10), Counter},
+		{"temp", dagr.RawFloat(1.5), Gauge},
+		{"up_ms", dagr.RawBool(true), Gauge},
+		{"msg_ms", dagr.RawString("x"), ""},
+	}
+	for _, c := range cases {
+		if got := DefaultType("key", c.field, c.value); got != c.want {
+			t.Errorf("DefaultType(%q, %v) = %q; want %q", c.field, c.value, got, c.want)
+		}
+	}
+}
+
+func TestClientMaxPacketSize(t *testing.T) {
+	var rec packetRecorder
+	c := NewClient(&rec)
+	c.MaxPacketSize = 32
+
+	fields := dagr.Fields{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		fields[name] = dagr.RawFloat(1)
+	}
+	c.WriteMeasurement(dagr.RawPoint{Key: "metric", Fields: fields})
+
+	// Each line is 12 bytes ("metric.a:1|g"), so two fit in each packet with a newline between them.
+	if len(rec) != 3 {
+		t.Fatalf("sent %d packets; want 3: %q", len(rec), rec)
+	}
+	for _, packet := range rec {
+		if len(packet) > c.MaxPacketSize {
+			t.Errorf("packet %q is larger than %d bytes", packet, c.MaxPacketSize)
+		}
+	}
+}
+
+func TestClientLoopback(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer()
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(conn) }()
+
+	c, err := Dial(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	hits := new(dagr.Int)
+	p := dagr.NewPoint("hits", dagr.Tags{"route": "/kittens"}, dagr.Fields{"value": hits})
+	for i := 0; i < 3; i++ {
+		hits.Add(2)
+		if _, err := c.WriteMeasurement(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for packets, _ := s.Packets(); packets < 3; packets, _ = s.Packets() {
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d packets; want 3", packets)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ms := s.Flush()
+	if len(ms) != 1 {
+		t.Fatalf("flushed %d points; want 1", len(ms))
+	}
+	if tags := ms[0].GetTags(); tags["route"] != "/kittens" {
+		t.Errorf("tags = %v", tags)
+	}
+	if ms[0].GetKey() != "hits.value" {
+		t.Errorf("key = %q; want hits.value", ms[0].GetKey())
+	}
+	if got := dagr.FieldValue(ms[0].GetFields()["value"]); got != 6.0 {
+		t.Errorf("value = %v; want 6", got)
+	}
+
+	s.Close()
+	<-done
+}
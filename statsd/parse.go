@@ -0,0 +1,128 @@
+// Package statsd bridges StatsD and DogStatsD with dagr. Server receives StatsD packets and aggregates them into
+// a dagr.PointSet, and Client sends dagr measurements to a StatsD server.
+package statsd // import "go.spiff.io/dagr/statsd"
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.spiff.io/dagr"
+)
+
+// Type is the type of a StatsD metric, as given after the first '|' of a metric line.
+type Type string
+
+// Metric types understood by Server. Histograms and distributions are aggregated the same as timers.
+const (
+	Counter      Type = "c"
+	Gauge        Type = "g"
+	Timer        Type = "ms"
+	Histogram    Type = "h"
+	Distribution Type = "d"
+	Set          Type = "s"
+)
+
+// metric is a single parsed StatsD metric line.
+type metric struct {
+	name  string
+	typ   Type
+	value string // Unparsed, since sets may hold any value and gauges may be relative
+	rate  float64
+	tags  dagr.Tags
+}
+
+var errMalformed = errors.New("statsd: malformed metric")
+
+// parseMetric parses a single line of the form "name:value|type[|@rate][|#tag:value,...]".
+func parseMetric(line []byte) (m metric, err error) {
+	colon := bytes.IndexByte(line, ':')
+	if colon < 1 {
+		return m, errMalformed
+	}
+	m.name = string(line[:colon])
+	m.rate = 1
+
+	parts := strings.Split(string(line[colon+1:]), "|")
+	if len(parts) < 2 || parts[0] == "" {
+		return m, errMalformed
+	}
+	m.value = parts[0]
+
+	switch m.typ = Type(parts[1]); m.typ {
+	case Counter, Gauge, Timer, Histogram, Distribution, Set:
+	default:
+		return m, errMalformed
+	}
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			if m.rate, err = strconv.ParseFloat(part[1:], 64); err != nil || m.rate <= 0 || m.rate > 1 {
+				return m, errMalformed
+			}
+		case strings.HasPrefix(part, "#"):
+			m.tags = parseTags(part[1:])
+		}
+	}
+
+	if m.typ != Set {
+		v := m.value
+		if m.typ == Gauge && (v[0] == '+' || v[0] == '-') {
+			v = v[1:]
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return m, errMalformed
+		}
+	}
+
+	return m, nil
+}
+
+// parseTags parses DogStatsD tags. Tags without a value, including tags with an empty value (e.g., "env:"), are given
+// the value "true", since InfluxDB doesn't allow empty tag values. Tags with an empty name are dropped.
+func parseTags(s string) dagr.Tags {
+	if s == "" {
+		return nil
+	}
+
+	tags := make(dagr.Tags)
+	for _, tag := range strings.Split(s, ",") {
+		name, value := tag, "true"
+		if i := strings.IndexByte(tag, ':'); i != -1 {
+			name = tag[:i]
+			if v := tag[i+1:]; v != "" {
+				value = v
+			}
+		}
+		if name != "" {
+			tags[name] = value
+		}
+	}
+	return tags
+}
+
+// identifier returns the PointSet identifier for a metric, which is unique for each combination of name, type, and
+// tags.
+func (m *metric) identifier() string {
+	var buf bytes.Buffer
+	buf.WriteString(string(m.typ))
+	buf.WriteByte(0)
+	buf.WriteString(m.name)
+
+	names := make([]string, 0, len(m.tags))
+	for name := range m.tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		buf.WriteByte(0)
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(m.tags[name])
+	}
+
+	return buf.String()
+}
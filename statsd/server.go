@@ -0,0 +1,348 @@
+package statsd
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"go.spiff.io/dagr"
+)
+
+// DefaultPercentiles are the percentiles computed for timers when a Server has no Percentiles.
+var DefaultPercentiles = []float64{90}
+
+// maxPacketSize is the largest UDP payload a Server will read.
+const maxPacketSize = 65535
+
+// Server receives StatsD and DogStatsD packets and aggregates their metrics into a dagr.PointSet. Each combination of
+// metric name, type, and tags is a point in the PointSet, keyed by the metric name and tagged with its DogStatsD tags,
+// the Server's Tags, and a metric_type tag of counter, gauge, timing, or set.
+//
+// Metrics are aggregated until Flush is called, with the same semantics as StatsD:
+//
+//   - Counters have a single float field, value, holding the sum of all increments since the last flush, scaled by
+//     their sample rates. Counters are reset to zero after each flush.
+//   - Gauges have a single float field, value, holding the last value set. Values prefixed with + or - are added to
+//     the gauge. Gauges keep their value across flushes.
+//   - Timers, histograms, and distributions are all treated as timers. Each flush computes float fields for the count
+//     (scaled by sample rates), lower, upper, mean, stddev, sum, median, and each of the Server's Percentiles (named
+//     p90, p99.9, etc.) of the values received since the last flush. Timers that received no values are removed.
+//   - Sets have a single integer field, value, holding the number of unique values received since the last flush.
+//     Sets are reset after each flush.
+type Server struct {
+	// Tags are added to every point. Tags sent with a metric take precedence over these.
+	Tags dagr.Tags
+
+	// Percentiles are the percentiles to compute for timers, from 0 to 100. If nil, DefaultPercentiles is used.
+	Percentiles []float64
+
+	m       sync.Mutex // controls entries and points
+	points  *dagr.PointSet
+	entries map[string]*entry
+
+	connlock sync.Mutex
+	conns    map[net.PacketConn]struct{}
+	closed   bool
+
+	packets int64 // Accessed atomically
+	bad     int64 // Accessed atomically
+}
+
+// entry holds the aggregation state of a single point in the Server's PointSet.
+type entry struct {
+	typ    Type
+	fields dagr.Fields
+
+	// Timers
+	samples []float64
+	count   float64
+
+	// Sets
+	values map[string]struct{}
+}
+
+// NewServer allocates a new Server. Its Tags and Percentiles may be set before it receives any packets.
+func NewServer() *Server {
+	s := &Server{
+		entries: make(map[string]*entry),
+		conns:   make(map[net.PacketConn]struct{}),
+	}
+	s.points = dagr.NewPointSet(dagr.PointAllocFunc(s.allocatePoint))
+	return s
+}
+
+func (s *Server) percentiles() []float64 {
+	if s.Percentiles == nil {
+		return DefaultPercentiles
+	}
+	return s.Percentiles
+}
+
+func percentileName(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+func (s *Server) allocatePoint(_ string, opaque interface{}) (key string, tags dagr.Tags, fields dagr.Fields) {
+	m := opaque.(*metric)
+
+	tags = s.Tags.Dup()
+	if tags == nil {
+		tags = make(dagr.Tags, len(m.tags)+1)
+	}
+	for name, value := range m.tags {
+		tags[name] = value
+	}
+
+	switch m.typ {
+	case Counter:
+		tags["metric_type"] = "counter"
+		fields = dagr.Fields{"value": new(dagr.Float)}
+	case Gauge:
+		tags["metric_type"] = "gauge"
+		fields = dagr.Fields{"value": new(dagr.Float)}
+	case Timer:
+		tags["metric_type"] = "timing"
+		fields = dagr.Fields{
+			"count":  new(dagr.Float),
+			"lower":  new(dagr.Float),
+			"upper":  new(dagr.Float),
+			"mean":   new(dagr.Float),
+			"stddev": new(dagr.Float),
+			"sum":    new(dagr.Float),
+			"median": new(dagr.Float),
+		}
+		for _, p := range s.percentiles() {
+			fields[percentileName(p)] = new(dagr.Float)
+		}
+	case Set:
+		tags["metric_type"] = "set"
+		fields = dagr.Fields{"value": new(dagr.Int)}
+	}
+
+	return m.name, tags, fields
+}
+
+// HandlePacket parses and aggregates all metrics in a single StatsD packet. Metrics are separated by newlines.
+// Malformed metrics are logged to dagr.Log and skipped.
+func (s *Server) HandlePacket(packet []byte) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for len(packet) > 0 {
+		line := packet
+		if i := bytes.IndexByte(packet, '\n'); i != -1 {
+			line, packet = packet[:i], packet[i+1:]
+		} else {
+			packet = nil
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		m, err := parseMetric(line)
+		if err != nil {
+			atomic.AddInt64(&s.bad, 1)
+			dagr.Log.Printf("statsd: %v: %q", err, line)
+			continue
+		}
+		s.handle(&m)
+	}
+	atomic.AddInt64(&s.packets, 1)
+}
+
+func (s *Server) handle(m *metric) {
+	if m.typ == Histogram || m.typ == Distribution {
+		m.typ = Timer
+	}
+
+	id := m.identifier()
+	e, ok := s.entries[id]
+	if !ok {
+		fields := s.points.FieldsForID(id, m)
+		if fields == nil {
+			return
+		}
+		e = &entry{typ: m.typ, fields: fields}
+		if m.typ == Set {
+			e.values = make(map[string]struct{})
+		}
+		s.entries[id] = e
+	}
+
+	if m.typ == Set {
+		e.values[m.value] = struct{}{}
+		return
+	}
+
+	v, _ := strconv.ParseFloat(m.value, 64)
+	switch m.typ {
+	case Counter:
+		e.fields["value"].(*dagr.Float).Add(v / m.rate)
+	case Gauge:
+		if c := m.value[0]; c == '+' || c == '-' {
+			e.fields["value"].(*dagr.Float).Add(v)
+		} else {
+			e.fields["value"].(*dagr.Float).Set(v)
+		}
+	case Timer:
+		e.samples = append(e.samples, v)
+		e.count += 1 / m.rate
+	}
+}
+
+// Flush computes timer and set fields, returns a snapshot of every point in the Server's PointSet, and then resets
+// counters, timers, and sets. The snapshots returned are dagr.TimeMeasurements fixed to the time of the flush, and can
+// be passed directly to an outflux Proxy's WriteMeasurements method or dagr.WriteMeasurements.
+func (s *Server) Flush() []dagr.Measurement {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for id, e := range s.entries {
+		switch e.typ {
+		case Timer:
+			if len(e.samples) == 0 {
+				delete(s.entries, id)
+				s.points.Remove(id)
+				continue
+			}
+			e.computeStats(s.percentiles())
+		case Set:
+			e.fields["value"].(*dagr.Int).Set(int64(len(e.values)))
+		}
+	}
+
+	ms := s.points.Measurements()
+	for i, m := range ms {
+		ms[i] = dagr.Snapshot(m)
+	}
+
+	for _, e := range s.entries {
+		switch e.typ {
+		case Counter:
+			e.fields["value"].(*dagr.Float).Set(0)
+		case Timer:
+			e.samples, e.count = e.samples[:0], 0
+		case Set:
+			e.values = make(map[string]struct{}, len(e.values))
+		}
+	}
+
+	return ms
+}
+
+func (e *entry) computeStats(percentiles []float64) {
+	samples := e.samples
+	sort.Float64s(samples)
+
+	n := len(samples)
+	sum := 0.0
+	for _, v := range samples {
+		sum += v
+	}
+	mean := sum / float64(n)
+
+	variance := 0.0
+	for _, v := range samples {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(n)
+
+	median := samples[n/2]
+	if n%2 == 0 {
+		median = (samples[n/2-1] + samples[n/2]) / 2
+	}
+
+	set := func(name string, v float64) { e.fields[name].(*dagr.Float).Set(v) }
+	set("count", e.count)
+	set("lower", samples[0])
+	set("upper", samples[n-1])
+	set("mean", mean)
+	set("stddev", math.Sqrt(variance))
+	set("sum", sum)
+	set("median", median)
+
+	for _, p := range percentiles {
+		// Nearest-rank percentile
+		rank := int(math.Ceil(p / 100 * float64(n)))
+		if rank < 1 {
+			rank = 1
+		} else if rank > n {
+			rank = n
+		}
+		set(percentileName(p), samples[rank-1])
+	}
+}
+
+// Packets returns the number of packets the Server has handled and the number of malformed metrics it has received.
+func (s *Server) Packets() (packets, malformed int64) {
+	return atomic.LoadInt64(&s.packets), atomic.LoadInt64(&s.bad)
+}
+
+// ListenAndServe listens for UDP packets on addr and serves them. See Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve reads packets from conn and handles them until conn is closed or returns an error. If the Server is closed,
+// Serve returns nil, otherwise it returns the error that stopped it.
+func (s *Server) Serve(conn net.PacketConn) error {
+	s.connlock.Lock()
+	if s.closed {
+		s.connlock.Unlock()
+		conn.Close()
+		return nil
+	}
+	s.conns[conn] = struct{}{}
+	s.connlock.Unlock()
+
+	defer func() {
+		s.connlock.Lock()
+		delete(s.conns, conn)
+		s.connlock.Unlock()
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if n > 0 {
+			s.HandlePacket(buf[:n])
+		}
+
+		if err != nil {
+			s.connlock.Lock()
+			closed := s.closed
+			s.connlock.Unlock()
+
+			if closed {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Close closes all connections being served by the Server. Aggregated metrics are kept and may still be flushed.
+func (s *Server) Close() error {
+	s.connlock.Lock()
+	defer s.connlock.Unlock()
+
+	s.closed = true
+
+	var err error
+	for conn := range s.conns {
+		if cerr := conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
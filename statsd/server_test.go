@@ -0,0 +1,155 @@
+package statsd
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.spiff.io/dagr"
+)
+
+// flushed returns the field values of every point flushed by s, indexed by key and metric type.
+func flushed(s *Server) map[string]map[string]interface{} {
+	out := make(map[string]map[string]interface{})
+	for _, m := range s.Flush() {
+		values := make(map[string]interface{})
+		for name, f := range m.GetFields() {
+			values[name] = dagr.FieldValue(f)
+		}
+		out[m.GetKey()+"/"+m.GetTags()["metric_type"]] = values
+	}
+	return out
+}
+
+func TestServerAggregate(t *testing.T) {
+	s := NewServer()
+	s.Tags = dagr.Tags{"host": "example.local"}
+	s.Percentiles = []float64{50, 90}
+
+	s.HandlePacket([]byte("requests:1|c\nrequests:2|c|@0.5\nqueue:10|g\nqueue:-3|g\n" +
+		"latency:10|ms\nlatency:30|ms\nlatency:20|h|@0.5\nusers:alice|s\nusers:bob|s\nusers:alice|s\n" +
+		"bad line\nrequests:x|c\n"))
+
+	got := flushed(s)
+	want := map[string]map[string]interface{}{
+		"requests/counter": {"value": 5.0},
+		"queue/gauge":      {"value": 7.0},
+		"latency/timing": {
+			"count": 4.0, "lower": 10.0, "upper": 30.0, "mean": 20.0, "sum": 60.0,
+			"median": 20.0, "p50": 20.0, "p90": 30.0, "stddev": 8.16496580927726,
+		},
+		"users/set": {"value": int64(2)},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("flushed %d points; want %d: %v", len(got), len(want), got)
+	}
+	for key, fields := range want {
+		for name, v := range fields {
+			if got[key][name] != v {
+				t.Errorf("%s.%s = %v; want %v", key, name, got[key][name], v)
+			}
+		}
+	}
+
+	if _, bad := s.Packets(); bad != 2 {
+		t.Errorf("malformed = %d; want 2", bad)
+	}
+
+	// Counters and sets reset, gauges persist, and idle timers are dropped.
+	got = flushed(s)
+	want = map[string]map[string]interface{}{
+		"requests/counter": {"value": 0.0},
+		"queue/gauge":      {"value": 7.0},
+		"users/set":        {"value": int64(0)},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("flushed %d points; want %d: %v", len(got), len(want), got)
+	}
+	for key, fields := range want {
+		for name, v := range fields {
+			if got[key][name] != v {
+				t.Errorf("%s.%s = %v; want %v", key, name, got[key][name], v)
+			}
+		}
+	}
+}
+
+func TestServerDogStatsDTags(t *testing.T) {
+	s := NewServer()
+	s.Tags = dagr.Tags{"host": "example.local", "env": "dev"}
+
+	s.HandlePacket([]byte("requests:1|c|#route:/kittens,env:prod,canary\n" +
+		"requests:1|c|#route:/puppies\n" +
+		"requests:1|c|#canary,route:/kittens,env:prod\n"))
+
+	ms := s.Flush()
+	if len(ms) != 2 {
+		t.Fatalf("flushed %d points; want 2", len(ms))
+	}
+
+	for _, m := range ms {
+		tags := m.GetTags()
+		want := 1.0
+		if tags["route"] == "/kittens" {
+			want = 2
+			if tags["env"] != "prod" || tags["canary"] != "true" || tags["host"] != "example.local" {
+				t.Errorf("tags = %v", tags)
+			}
+		}
+
+		if v := dagr.FieldValue(m.GetFields()["value"]); v != want {
+			t.Errorf("%v: value = %v; want %v", tags, v, want)
+		}
+	}
+}
+
+func TestParseTagsEmpty(t *testing.T) {
+	got := parseTags("env:,canary,:orphan,,route:/kittens")
+	want := dagr.Tags{"env": "true", "canary": "true", "route": "/kittens"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTags() = %v; want %v", got, want)
+	}
+}
+
+func TestServerLoopback(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer()
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Write([]byte("hits:1|c")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for packets, _ := s.Packets(); packets < 3; packets, _ = s.Packets() {
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d packets; want 3", packets)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := flushed(s)["hits/counter"]["value"]; got != 3.0 {
+		t.Errorf("hits = %v; want 3", got)
+	}
+
+	s.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Serve() = %v; want nil", err)
+	}
+}
@@ -0,0 +1,313 @@
+package dagr
+
+import (
+	"io"
+	"sync"
+)
+
+// MaxTagDimensions is the maximum number of tags a TagPointSet may be keyed by.
+const MaxTagDimensions = 8
+
+// TagPointAllocator is used to prepare a point for a TagPointSet from a tuple of tag values. values holds one value per
+// tag name of the TagPointSet, in the same order. Aside from receiving a tuple of values, it behaves the same as
+// a PointAllocator: returning an empty key or no fields signals that the tuple should not receive a point, and the
+// tags and fields returned are copied.
+//
+// The TagPointSet sets the tags named by its tag names to the values in the tuple, so the allocator does not need to
+// return them. Empty values are omitted from the point's tags.
+type TagPointAllocator interface {
+	AllocateTagPoint(values []string, opaque interface{}) (key string, tags Tags, fields Fields)
+}
+
+// TagPointAllocFunc is a function that implements TagPointAllocator.
+type TagPointAllocFunc func(values []string, opaque interface{}) (key string, tags Tags, fields Fields)
+
+func (fn TagPointAllocFunc) AllocateTagPoint(values []string, opaque interface{}) (key string, tags Tags, fields Fields) {
+	return fn(values, opaque)
+}
+
+// AllocateTagPoint returns the StaticPointAllocator's key, tags, and a duplicate of its fields. Its identifier tag and
+// field are not set, since a TagPointSet sets tags from the tuple itself.
+func (s StaticPointAllocator) AllocateTagPoint(_ []string, _ interface{}) (key string, tags Tags, fields Fields) {
+	return s.Key, s.Tags, s.Fields.Dup(true)
+}
+
+// AnyTagValue is a value in a partial tuple that matches any value in its position, including an empty one. It's a
+// newline, which line protocol can't represent in a tag value, so it never stands in for a real value.
+const AnyTagValue = "\n"
+
+// tagTuple is a tuple of tag values. It's used as a map key, so that looking up a point by its tuple doesn't require
+// building a string out of the tuple.
+type tagTuple [MaxTagDimensions]string
+
+// TagPointSet is a collection of points keyed by a tuple of tag values, such as a request's method, route, and status.
+// It's a PointSet for points that vary across several tags. Tuples are looked up without joining their values into
+// a single identifier, so no two tuples can collide.
+//
+// Methods that take a partial tuple match any point whose tuple begins with the values given. Values are matched
+// exactly, so an empty value only matches an empty value, and AnyTagValue matches any value. For example, given the
+// tag names method, route, and status, the partial tuple ("GET") matches all GET requests, (AnyTagValue,
+// "/v1/parrots") matches all requests to /v1/parrots, and ("GET", "/v1/parrots", "") matches only GET requests to
+// /v1/parrots without a status.
+type TagPointSet struct {
+	names     []string
+	allocator TagPointAllocator
+	m         sync.RWMutex // controls metrics
+	metrics   map[tagTuple]*CompiledPoint
+}
+
+var _ = MeasurementSet((*TagPointSet)(nil))
+var _ = measurementAppender((*TagPointSet)(nil))
+
+// NewTagPointSet allocates a new TagPointSet keyed by the given tag names. If allocator is nil, the function panics with
+// ErrNoAllocator. It also panics if there are no names, more than MaxTagDimensions names, or if any name is empty or
+// repeated.
+func NewTagPointSet(allocator TagPointAllocator, names ...string) *TagPointSet {
+	if allocator == nil {
+		panic(ErrNoAllocator)
+	} else if len(names) == 0 {
+		panic("dagr.NewTagPointSet: no tag names")
+	} else if len(names) > MaxTagDimensions {
+		panic("dagr.NewTagPointSet: too many tag names")
+	}
+
+	for i, name := range names {
+		if name == "" {
+			panic("dagr.NewTagPointSet: tag name is empty")
+		}
+		for _, prev := range names[:i] {
+			if prev == name {
+				panic("dagr.NewTagPointSet: tag name " + name + " is repeated")
+			}
+		}
+	}
+
+	return &TagPointSet{
+		names:     append([]string(nil), names...),
+		allocator: allocator,
+		metrics:   make(map[tagTuple]*CompiledPoint),
+	}
+}
+
+// Names returns the tag names the TagPointSet is keyed by.
+func (p *TagPointSet) Names() []string {
+	return append([]string(nil), p.names...)
+}
+
+// tuple returns values as a tagTuple. Values beyond the number of tag names are ignored.
+func (p *TagPointSet) tuple(values []string) (t tagTuple) {
+	if len(values) > len(p.names) {
+		values = values[:len(p.names)]
+	}
+	copy(t[:], values)
+	return t
+}
+
+// tagsTuple returns the values of tags named by the TagPointSet's tag names as a tagTuple.
+func (p *TagPointSet) tagsTuple(tags Tags) (t tagTuple) {
+	for i, name := range p.names {
+		t[i] = tags[name]
+	}
+	return t
+}
+
+// matches returns whether t begins with the values of partial, other than those that are AnyTagValue.
+func (p *TagPointSet) matches(t tagTuple, partial []string) bool {
+	for i, v := range partial {
+		if i >= len(p.names) {
+			break
+		} else if v != AnyTagValue && t[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *TagPointSet) lookup(t tagTuple) (m *CompiledPoint, ok bool) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	m, ok = p.metrics[t]
+	return m, ok
+}
+
+// alloc allocates a new point for t and stores it in the TagPointSet, returning the point and whether the allocation
+// was successful. As with PointSet, it first checks whether the point was allocated while waiting for the lock.
+func (p *TagPointSet) alloc(t tagTuple, opaque interface{}) (m *CompiledPoint, ok bool) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if m, ok := p.metrics[t]; ok {
+		return m, true
+	}
+
+	values := append([]string(nil), t[:len(p.names)]...)
+	key, tags, fields := p.allocator.AllocateTagPoint(values, opaque)
+	if key == "" || len(fields) == 0 {
+		return nil, false
+	}
+
+	pt := NewPoint(key, tags, fields)
+	for i, name := range p.names {
+		if values[i] != "" {
+			pt.SetTag(name, values[i])
+		}
+	}
+
+	m = pt.Linked()
+	p.metrics[t] = m
+	return m, true
+}
+
+func (p *TagPointSet) get(t tagTuple, opaque interface{}) (m *CompiledPoint, ok bool) {
+	if m, ok := p.lookup(t); ok {
+		return m, true
+	}
+	return p.alloc(t, opaque)
+}
+
+// FieldsFor returns the fields for a tuple of tag values, allocating a point for the tuple if necessary. If no point is
+// found and no point can be allocated for the tuple, it returns nil. values must hold one value per tag name; missing
+// values are treated as empty and extra values are ignored.
+func (p *TagPointSet) FieldsFor(values []string, opaque interface{}) Fields {
+	if m, ok := p.get(p.tuple(values), opaque); ok {
+		return m.Point().GetFields()
+	}
+	return nil
+}
+
+// FieldsForTags is the same as FieldsFor, but takes the tuple's values from tags. Tags not named by the TagPointSet are
+// ignored.
+func (p *TagPointSet) FieldsForTags(tags Tags, opaque interface{}) Fields {
+	if m, ok := p.get(p.tagsTuple(tags), opaque); ok {
+		return m.Point().GetFields()
+	}
+	return nil
+}
+
+// PointFor returns the point for a tuple of tag values, allocating it the same as FieldsFor if necessary. If no point
+// is found and no point can be allocated for the tuple, it returns nil.
+func (p *TagPointSet) PointFor(values []string, opaque interface{}) *Point {
+	if m, ok := p.get(p.tuple(values), opaque); ok {
+		return m.Point()
+	}
+	return nil
+}
+
+// PointForTags is the same as PointFor, but takes the tuple's values from tags.
+func (p *TagPointSet) PointForTags(tags Tags, opaque interface{}) *Point {
+	if m, ok := p.get(p.tagsTuple(tags), opaque); ok {
+		return m.Point()
+	}
+	return nil
+}
+
+// Lookup returns the point for a tuple of tag values if the TagPointSet holds one. Unlike PointFor, it never allocates
+// a point.
+func (p *TagPointSet) Lookup(values ...string) *Point {
+	if m, ok := p.lookup(p.tuple(values)); ok {
+		return m.Point()
+	}
+	return nil
+}
+
+// Each calls fn with the tuple and point of each point matching the partial tuple, until fn returns false. Points are
+// visited in no particular order. values is only valid until fn returns. fn must not modify the TagPointSet, though it
+// may modify the points it's given.
+func (p *TagPointSet) Each(partial []string, fn func(values []string, pt *Point) bool) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	n := len(p.names)
+	for t, m := range p.metrics {
+		if p.matches(t, partial) && !fn(t[:n:n], m.Point()) {
+			return
+		}
+	}
+}
+
+// Match returns the points matching the partial tuple, in no particular order.
+func (p *TagPointSet) Match(partial ...string) []*Point {
+	var pts []*Point
+	p.Each(partial, func(_ []string, pt *Point) bool {
+		pts = append(pts, pt)
+		return true
+	})
+	return pts
+}
+
+// Remove removes all points matching the partial tuple and returns the number of points removed. Calling Remove with
+// no values removes all points.
+func (p *TagPointSet) Remove(partial ...string) int {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	n := 0
+	for t := range p.metrics {
+		if p.matches(t, partial) {
+			delete(p.metrics, t)
+			n++
+		}
+	}
+	return n
+}
+
+// Clear erases all points held by the TagPointSet.
+func (p *TagPointSet) Clear() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	capacity := len(p.metrics)
+	p.metrics = make(map[tagTuple]*CompiledPoint, capacity)
+}
+
+// Measurements returns the points currently held by the TagPointSet as CompiledPoints.
+func (p *TagPointSet) Measurements() []Measurement {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	ms := make([]Measurement, 0, len(p.metrics))
+	for _, m := range p.metrics {
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+func (p *TagPointSet) WriteTo(w io.Writer) (int64, error) {
+	bp := getScratch()
+	b, err := p.appendTagged(*bp, nil, nil)
+	defer putScratch(bp, b)
+	return writeScratch(w, b, err)
+}
+
+// appendTagged appends each of the TagPointSet's points with fields to dst.
+func (p *TagPointSet) appendTagged(dst []byte, defaults Tags, defaultOrder []string) ([]byte, error) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	for _, m := range p.metrics {
+		b, err := m.appendTagged(dst, defaults, defaultOrder)
+		if err == ErrNoFields {
+			continue
+		} else if err != nil {
+			return b, err
+		}
+		dst = b
+	}
+
+	return dst, nil
+}
+
+// As with PointSet, a TagPointSet has no key, tags, or fields of its own.
+
+func (p *TagPointSet) GetKey() string {
+	return ""
+}
+
+func (p *TagPointSet) GetFields() Fields {
+	return nil
+}
+
+func (p *TagPointSet) GetTags() Tags {
+	return nil
+}
@@ -0,0 +1,110 @@
+package dagr
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTagPointSet(t *testing.T) {
+	defer prepareLogger(t)()
+
+	p := NewTagPointSet(
+		StaticPointAllocator{
+			Key:    "http_request",
+			Tags:   Tags{"host": "example.local"},
+			Fields: Fields{"count": new(Int)},
+		},
+		"method", "route", "status",
+	)
+
+	record := func(method, route, status string) {
+		p.FieldsFor([]string{method, route, status}, nil)["count"].(*Int).Add(1)
+	}
+
+	record("GET", "/v1/parrots", "200")
+	record("GET", "/v1/parrots", "200")
+	record("GET", "/v1/parrots", "404")
+	record("POST", "/v1/parrots", "201")
+	record("GET", "/v1/kittens", "200")
+	// Values that would collide if joined with a separator
+	record("GET", "/a,b", "200")
+	record("GET,/a", "b", "200")
+	p.FieldsForTags(Tags{"method": "DELETE", "route": "/v1/parrots", "other": "ignored"}, nil)["count"].(*Int).Add(1)
+
+	var buf bytes.Buffer
+	if _, err := WriteMeasurement(&buf, p); err != nil {
+		t.Fatalf("WriteMeasurement() error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	sort.Strings(lines)
+	want := []string{
+		`http_request,host=example.local,method=DELETE,route=/v1/parrots count=1i 1136214245000000000`,
+		`http_request,host=example.local,method=GET,route=/a\,b,status=200 count=1i 1136214245000000000`,
+		`http_request,host=example.local,method=GET,route=/v1/kittens,status=200 count=1i 1136214245000000000`,
+		`http_request,host=example.local,method=GET,route=/v1/parrots,status=200 count=2i 1136214245000000000`,
+		`http_request,host=example.local,method=GET,route=/v1/parrots,status=404 count=1i 1136214245000000000`,
+		`http_request,host=example.local,method=GET\,/a,route=b,status=200 count=1i 1136214245000000000`,
+		`http_request,host=example.local,method=POST,route=/v1/parrots,status=201 count=1i 1136214245000000000`,
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("WriteMeasurement() =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	if pt := p.Lookup("GET", "/v1/parrots", "404"); pt == nil {
+		t.Error("Lookup(GET, /v1/parrots, 404) = nil")
+	}
+	if pt := p.Lookup("GET", "/v1/turtles", "200"); pt != nil {
+		t.Errorf("Lookup(GET, /v1/turtles, 200) = %v; want nil", pt)
+	}
+
+	if n := len(p.Match("GET")); n != 4 {
+		t.Errorf("len(Match(GET)) = %d; want 4", n)
+	}
+
+	var routes []string
+	p.Each([]string{AnyTagValue, "/v1/parrots"}, func(values []string, pt *Point) bool {
+		routes = append(routes, strings.Join(values, " "))
+		return true
+	})
+	sort.Strings(routes)
+	if want := []string{"DELETE /v1/parrots ", "GET /v1/parrots 200", "GET /v1/parrots 404", "POST /v1/parrots 201"}; !reflect.DeepEqual(routes, want) {
+		t.Errorf("Each(_, /v1/parrots) = %q; want %q", routes, want)
+	}
+
+	if n := p.Remove("GET", AnyTagValue, "200"); n != 3 {
+		t.Errorf("Remove(GET, *, 200) = %d; want 3", n)
+	}
+	if n := len(p.Measurements()); n != 4 {
+		t.Errorf("len(Measurements()) = %d; want 4", n)
+	}
+
+	// Empty values are matched exactly
+	if n := p.Remove("", "/v1/parrots"); n != 0 {
+		t.Errorf("Remove(\"\", /v1/parrots) = %d; want 0", n)
+	}
+	if n := p.Remove(AnyTagValue, "/v1/parrots", ""); n != 1 {
+		t.Errorf("Remove(*, /v1/parrots, \"\") = %d; want 1", n)
+	}
+	if n := p.Remove(); n != 3 {
+		t.Errorf("Remove() = %d; want 3", n)
+	}
+}
+
+func TestTagPointSetLookupAllocs(t *testing.T) {
+	defer prepareLogger(t)()
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable with the race detector")
+	}
+
+	p := NewTagPointSet(StaticPointAllocator{Key: "http_request", Fields: Fields{"count": new(Int)}}, "method", "route")
+	p.PointFor([]string{"GET", "/v1/parrots"}, nil)
+
+	method, route := "GET", "/v1/parrots"
+	if n := testing.AllocsPerRun(100, func() { p.PointFor([]string{method, route}, nil) }); n != 0 {
+		t.Errorf("PointFor allocs = %v; want 0", n)
+	}
+}
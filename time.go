@@ -8,9 +8,33 @@ type timeSource interface {
 	Now() time.Time
 }
 
+// ticker is the part of a time.Ticker used by background goroutines, such as StartExpiring's.
+type ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// tickerSource may be implemented by a timeSource to provide its own tickers, so that tests can tick background
+// goroutines by hand instead of waiting on real time.
+type tickerSource interface {
+	NewTicker(d time.Duration) ticker
+}
+
 type defaultClock struct{}
 
 func (defaultClock) Now() time.Time { return time.Now() }
 
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) Chan() <-chan time.Time { return t.C }
+
+// newTicker returns a ticker from the package clock if it provides them, or a time.Ticker otherwise.
+func newTicker(d time.Duration) ticker {
+	if ts, ok := clock.(tickerSource); ok {
+		return ts.NewTicker(d)
+	}
+	return realTicker{time.NewTicker(d)}
+}
+
 // This is set to a fixed time in time_test.go
 var clock timeSource = defaultClock{}
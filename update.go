@@ -0,0 +1,74 @@
+package dagr
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// seqlock is a sequence lock used to make updates to multiple fields of a point appear atomic to readers. Writers hold
+// the lock while updating fields and the sequence is odd while they do. Readers sample fields without locking and retry
+// if the sequence changed while they were reading, so reading a point costs two atomic loads unless it's being updated.
+//
+// A nil *seqlock is never locked.
+type seqlock struct {
+	seq uint64 // Accessed atomically
+	m   sync.Mutex
+}
+
+func (s *seqlock) lock() {
+	s.m.Lock()
+	atomic.AddUint64(&s.seq, 1)
+}
+
+func (s *seqlock) unlock() {
+	atomic.AddUint64(&s.seq, 1)
+	s.m.Unlock()
+}
+
+// begin returns the current sequence number to pass to valid once reading is done. If an update is in progress, begin
+// waits for it to finish.
+func (s *seqlock) begin() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	for {
+		seq := atomic.LoadUint64(&s.seq)
+		if seq&1 == 0 {
+			return seq
+		}
+		// Wait for the writer to finish
+		s.m.Lock()
+		s.m.Unlock()
+	}
+}
+
+// valid returns whether no update occurred since begin returned seq. If it returns false, the reader must retry.
+func (s *seqlock) valid(seq uint64) bool {
+	return s == nil || atomic.LoadUint64(&s.seq) == seq
+}
+
+// Update calls fn with the point's fields and ensures that writes and snapshots of the point see either all of the
+// changes fn makes to its fields or none of them. This is useful when fields are related, such as a count of requests
+// and a count of errors, and a write should never see one updated without the other. For example:
+//
+//	p.Update(func(fs Fields) {
+//		fs["requests"].(*Int).Add(1)
+//		fs["errors"].(*Int).Add(1)
+//	})
+//
+// Updating fields outside of Update is still safe, but those updates may be seen partway through. Updates are
+// serialized with each other, and readers of the point wait for an Update to finish before they sample its fields, so
+// fn should be brief.
+//
+// fs is the point's own map of fields and must not be modified. fn must not call methods on p that modify it, such as
+// SetField or SetTag, as these will deadlock. Compiled and linked forms of the point also honor Update.
+func (p *Point) Update(fn func(fs Fields)) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	p.seq.lock()
+	defer p.seq.unlock()
+
+	fn(p.fields)
+}
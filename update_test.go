@@ -0,0 +1,62 @@
+package dagr
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestPointUpdate(t *testing.T) {
+	defer prepareLogger(t)()
+
+	requests, errors := new(Int), new(Int)
+	p := NewPoint("service", nil, Fields{"requests": requests, "errors": errors})
+
+	var (
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				p.Update(func(fs Fields) {
+					fs["requests"].(*Int).Add(1)
+					fs["errors"].(*Int).Add(1)
+				})
+			}
+		}()
+	}
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	check := func(name string, m Measurement) {
+		line := AppendMeasurement(nil, m)
+		rp, err := ParseLine(line)
+		if err != nil {
+			t.Fatalf("%s: ParseLine(%q) error: %v", name, line, err)
+		}
+		if r, e := rp.Fields["requests"], rp.Fields["errors"]; r != e {
+			t.Fatalf("%s: requests = %v, errors = %v; want equal", name, r, e)
+		}
+
+		snap := Snapshot(m).GetFields()
+		if r, e := FieldValue(snap["requests"]), FieldValue(snap["errors"]); r != e {
+			t.Fatalf("%s: snapshot requests = %v, errors = %v; want equal", name, r, e)
+		}
+	}
+
+	compiled, linked := p.Compiled(), p.Linked()
+	for i := 0; i < 1000; i++ {
+		check("Point", p)
+		check("Compiled", compiled)
+		check("Linked", linked)
+	}
+}
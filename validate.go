@@ -0,0 +1,109 @@
+package dagr
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultMaxLineLength is the default maximum length, in bytes, of a single line of line protocol, excluding its
+// trailing newline. Lines longer than this are considered invalid by Validate and validating Encoders.
+const DefaultMaxLineLength = 64 * 1024
+
+// ValidationError describes a measurement that would be rejected by InfluxDB. Code identifies the rule the measurement
+// broke and is one of the validation Error codes (e.g., ErrReservedTag), ErrEmptyKey, or ErrNoFields. If the rule
+// concerns a specific tag or field, its name is given by Tag or Field.
+type ValidationError struct {
+	Code  Error
+	Key   string
+	Tag   string
+	Field string
+}
+
+func (e *ValidationError) Error() string {
+	msg := fmt.Sprintf("dagr: invalid measurement %q: %v", e.Key, e.Code)
+	if e.Tag != "" {
+		msg += fmt.Sprintf(" (tag %q)", e.Tag)
+	} else if e.Field != "" {
+		msg += fmt.Sprintf(" (field %q)", e.Field)
+	}
+	return msg
+}
+
+// Unwrap returns the ValidationError's Code, allowing it to be compared using errors.Is.
+func (e *ValidationError) Unwrap() error {
+	return e.Code
+}
+
+// Validate checks that m can be written and accepted by InfluxDB. If m is a MeasurementSet, each of its measurements is
+// validated and the first error encountered is returned, except that measurements of the set without fields are
+// skipped, since they're skipped when the set is written. If m is invalid, the error returned is a *ValidationError.
+//
+// A measurement is invalid if it has an empty key, no fields, an empty field name, a field named "time", an empty tag
+// name or value, a tag name beginning with an underscore, or if its encoded form is longer than DefaultMaxLineLength.
+func Validate(m Measurement) error {
+	bp := getScratch()
+	enc := Encoder{Validate: true}
+	b, err := enc.AppendMeasurement(*bp, m)
+	putScratch(bp, b)
+	return err
+}
+
+// validate checks m's key, tags, and fields, in that order. Default tags are checked along with m's tags, unless m has
+// a tag of the same name, since they're written with it. Tags and fields are checked in ascending order by name so that
+// the same error is always returned for the same measurement. It does not check the length of m's line.
+func validate(m Measurement, defaults Tags) *ValidationError {
+	key := m.GetKey()
+	if key == "" {
+		return &ValidationError{Code: ErrEmptyKey}
+	}
+
+	tags := m.GetTags()
+	names := make([]string, 0, len(tags)+len(defaults))
+	for name := range tags {
+		names = append(names, name)
+	}
+	for name := range defaults {
+		if _, ok := tags[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, ok := tags[name]
+		if !ok {
+			value = defaults[name]
+		}
+
+		switch {
+		case name == "":
+			return &ValidationError{Code: ErrEmptyTagName, Key: key}
+		case name[0] == '_':
+			return &ValidationError{Code: ErrReservedTag, Key: key, Tag: name}
+		case value == "":
+			return &ValidationError{Code: ErrEmptyTagValue, Key: key, Tag: name}
+		}
+	}
+
+	fields := m.GetFields()
+	if len(fields) == 0 {
+		return &ValidationError{Code: ErrNoFields, Key: key}
+	}
+
+	names = names[:0]
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		switch name {
+		case "":
+			return &ValidationError{Code: ErrEmptyFieldName, Key: key}
+		case "time":
+			return &ValidationError{Code: ErrReservedField, Key: key, Field: name}
+		}
+	}
+
+	return nil
+}
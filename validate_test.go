@@ -0,0 +1,163 @@
+package dagr
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	defer prepareLogger(t)()
+
+	fields := Fields{"value": RawInt(1)}
+	cases := []struct {
+		m    Measurement
+		want *ValidationError
+	}{
+		{RawPoint{Key: "ok", Tags: Tags{"host": "example.local"}, Fields: fields}, nil},
+		{NewPoint("ok", Tags{"host": "example.local"}, Fields{"value": new(Int)}).Compiled(), nil},
+		{RawPoint{Fields: fields}, &ValidationError{Code: ErrEmptyKey}},
+		{RawPoint{Key: "k"}, &ValidationError{Code: ErrNoFields, Key: "k"}},
+		{RawPoint{Key: "k", Fields: Fields{"": RawInt(1)}}, &ValidationError{Code: ErrEmptyFieldName, Key: "k"}},
+		{RawPoint{Key: "k", Fields: Fields{"time": RawInt(1)}}, &ValidationError{Code: ErrReservedField, Key: "k", Field: "time"}},
+		{RawPoint{Key: "k", Tags: Tags{"": "v"}, Fields: fields}, &ValidationError{Code: ErrEmptyTagName, Key: "k"}},
+		{RawPoint{Key: "k", Tags: Tags{"_id": "v"}, Fields: fields}, &ValidationError{Code: ErrReservedTag, Key: "k", Tag: "_id"}},
+		{RawPoint{Key: "k", Tags: Tags{"a": "v", "b": ""}, Fields: fields}, &ValidationError{Code: ErrEmptyTagValue, Key: "k", Tag: "b"}},
+		{
+			RawPoint{Key: "k", Fields: Fields{"msg": RawString(strings.Repeat("x", DefaultMaxLineLength))}},
+			&ValidationError{Code: ErrLineTooLong, Key: "k"},
+		},
+	}
+
+	for i, c := range cases {
+		err := Validate(c.m)
+		if c.want == nil {
+			if err != nil {
+				t.Errorf("%d: Validate() = %v; want nil", i, err)
+			}
+			continue
+		}
+
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("%d: Validate() = %#v; want %v", i, err, c.want)
+		} else if *verr != *c.want {
+			t.Errorf("%d: Validate() = %v; want %v", i, verr, c.want)
+		} else if !errors.Is(err, c.want.Code) {
+			t.Errorf("%d: errors.Is(%v, %v) = false; want true", i, err, c.want.Code)
+		}
+	}
+}
+
+func TestValidatePointSet(t *testing.T) {
+	defer prepareLogger(t)()
+
+	p := NewPointSet(StaticPointAllocator{
+		Key:           "http_request",
+		IdentifierTag: "path",
+		Fields:        Fields{"count": new(Int)},
+	})
+
+	p.FieldsForID("/api/v1/kittens", nil)
+	if err := Validate(p); err != nil {
+		t.Fatalf("Validate() = %v; want nil", err)
+	}
+
+	p.FieldsForID("", nil)
+	want := ValidationError{Code: ErrEmptyTagValue, Key: "http_request", Tag: "path"}
+	if verr, ok := Validate(p).(*ValidationError); !ok || *verr != want {
+		t.Fatalf("Validate() = %v; want %v", verr, &want)
+	}
+}
+
+func TestValidateSetSkipsEmpty(t *testing.T) {
+	empty := RawPoint{Key: "empty"}
+	if verr, ok := Validate(empty).(*ValidationError); !ok || verr.Code != ErrNoFields {
+		t.Errorf("Validate(empty) = %v; want %v", verr, ErrNoFields)
+	}
+
+	reg := new(Registry)
+	reg.Register("empty", empty)
+	reg.Register("point", RawPoint{Key: "point", Fields: Fields{"value": RawInt(1)}})
+	if err := Validate(reg); err != nil {
+		t.Errorf("Validate(registry) = %v; want nil", err)
+	}
+}
+
+func TestEncoderInvalid(t *testing.T) {
+	const required = `good value=1i 1136214245000000000` + "\n"
+
+	defer prepareLogger(t)()
+
+	ms := []Measurement{
+		RawPoint{Key: "bad", Tags: Tags{"_reserved": "tag"}, Fields: Fields{"value": RawInt(1)}},
+		RawPoint{Key: "good", Fields: Fields{"value": RawInt(1)}},
+		RawPoint{Key: "empty"},
+		RawPoint{Key: "bad", Fields: Fields{"time": RawInt(1)}},
+	}
+
+	var buf bytes.Buffer
+	enc := Encoder{Validate: true}
+	if _, err := enc.WriteMeasurements(&buf, ms...); !errors.Is(err, ErrReservedTag) {
+		t.Errorf("WriteMeasurements() = %v; want %v", err, ErrReservedTag)
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("buf = %q; want empty", buf.String())
+	}
+
+	var invalid []Error
+	enc.Invalid = func(m Measurement, err *ValidationError) {
+		invalid = append(invalid, err.Code)
+	}
+
+	if _, err := enc.WriteMeasurements(&buf, ms...); err != nil {
+		t.Fatalf("WriteMeasurements() = %v; want nil", err)
+	}
+
+	if got := buf.String(); got != required {
+		t.Errorf("Expected %q\nGot %q", required, got)
+	}
+
+	if len(invalid) != 2 || invalid[0] != ErrReservedTag || invalid[1] != ErrReservedField {
+		t.Errorf("invalid = %v; want [%v %v]", invalid, ErrReservedTag, ErrReservedField)
+	}
+}
+
+func TestEncoderInvalidDefaultTags(t *testing.T) {
+	defer prepareLogger(t)()
+
+	m := RawPoint{Key: "point", Tags: Tags{"host": "example.local"}, Fields: Fields{"value": RawInt(1)}, Time: testTime}
+	cases := []struct {
+		defaults Tags
+		code     Error
+		tag      string
+	}{
+		{Tags{"": "value"}, ErrEmptyTagName, ""},
+		{Tags{"_foo": "value"}, ErrReservedTag, "_foo"},
+		{Tags{"env": ""}, ErrEmptyTagValue, "env"},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		enc := Encoder{DefaultTags: c.defaults, Validate: true}
+		_, err := enc.WriteMeasurements(&buf, m)
+		if verr, ok := err.(*ValidationError); !ok || verr.Code != c.code || verr.Tag != c.tag {
+			t.Errorf("WriteMeasurements() with default tags %v = %v; want %v (tag %q)", c.defaults, err, c.code, c.tag)
+		}
+		if _, err := enc.WriteMeasurementsJSON(&buf, m); !errors.Is(err, c.code) {
+			t.Errorf("WriteMeasurementsJSON() with default tags %v = %v; want %v", c.defaults, err, c.code)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("buf = %q; want empty", buf.String())
+		}
+	}
+
+	// A measurement's own tag replaces an invalid default tag, so it's valid.
+	var buf bytes.Buffer
+	enc := Encoder{DefaultTags: Tags{"host": ""}, Validate: true}
+	if _, err := enc.WriteMeasurements(&buf, m); err != nil {
+		t.Errorf("WriteMeasurements() = %v; want nil", err)
+	}
+}
@@ -0,0 +1,387 @@
+package dagr
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultWatchEventKey is the key of the events written by a Watcher if its EventKey is empty.
+const DefaultWatchEventKey = "dagr_watch"
+
+// Condition is the condition under which a WatchRule fires.
+type Condition int
+
+const (
+	// Above fires when a field's value is greater than the threshold.
+	Above Condition = iota
+	// Below fires when a field's value is less than the threshold.
+	Below
+	// RateAbove fires when a field's rate of change, per second, since the previous evaluation is greater than the
+	// threshold.
+	RateAbove
+	// RateBelow fires when a field's rate of change, per second, since the previous evaluation is less than the
+	// threshold. For example, a RateBelow rule with a threshold of -100 fires when a field drops by more than 100 a
+	// second.
+	RateBelow
+)
+
+func (c Condition) String() string {
+	switch c {
+	case Above:
+		return "above"
+	case Below:
+		return "below"
+	case RateAbove:
+		return "rate above"
+	case RateBelow:
+		return "rate below"
+	}
+	return fmt.Sprintf("Condition(%d)", int(c))
+}
+
+// WatchRule is a threshold on a field of a watched measurement.
+type WatchRule struct {
+	// Name identifies the rule in events and when unwatching it. It may not be empty.
+	Name string
+
+	// Field is the name of the field watched. Only numeric fields are watched; series where the field is missing or
+	// isn't numeric are skipped.
+	Field string
+
+	// Condition is when the rule fires.
+	Condition Condition
+
+	// Threshold is the value (or rate, for RateAbove and RateBelow) the field is compared against.
+	Threshold float64
+
+	// Hysteresis is how far back past the threshold the field must go before a firing rule clears. For example, an
+	// Above rule with a threshold of 10000 and hysteresis of 1000 fires when the field goes over 10000 and only clears
+	// once it's back down to 9000 or less. This keeps a field hovering around the threshold from flapping.
+	Hysteresis float64
+
+	// For is the number of consecutive evaluations the condition must hold for before the rule fires. If For is <= 1,
+	// the rule fires as soon as the condition holds. Clearing is not delayed.
+	For int
+
+	// Func, if not nil, is called with an event each time the rule starts or stops firing for a series.
+	Func func(WatchEvent)
+}
+
+// firing returns whether v is past the rule's threshold. If the rule is already firing, the threshold is moved back by
+// the rule's hysteresis.
+func (r *WatchRule) firing(v float64, firing bool) bool {
+	var h float64
+	if firing {
+		h = r.Hysteresis
+	}
+
+	switch r.Condition {
+	case Above, RateAbove:
+		return v > r.Threshold-h
+	case Below, RateBelow:
+		return v < r.Threshold+h
+	}
+	return false
+}
+
+// WatchEvent describes a WatchRule starting or stopping firing for a series.
+type WatchEvent struct {
+	Rule      string // The name of the rule
+	Key       string // The key of the series
+	Tags      Tags   // The tags of the series
+	Field     string // The name of the field
+	Value     float64
+	Threshold float64
+	Firing    bool // Whether the rule started (true) or stopped (false) firing
+	Time      time.Time
+}
+
+// Measurement returns the event as a RawPoint with the given key. Its tags are the series' tags plus rule, key, and
+// field tags (replacing any of the series' tags with the same names), and its fields are value, threshold, and firing.
+func (e WatchEvent) Measurement(key string) RawPoint {
+	tags := make(Tags, len(e.Tags)+3)
+	for name, value := range e.Tags {
+		tags[name] = value
+	}
+	tags["rule"], tags["key"], tags["field"] = e.Rule, e.Key, e.Field
+
+	return RawPoint{
+		Key:  key,
+		Tags: tags,
+		Fields: Fields{
+			"value":     RawFloat(e.Value),
+			"threshold": RawFloat(e.Threshold),
+			"firing":    RawBool(e.Firing),
+		},
+		Time: e.Time,
+	}
+}
+
+// Watcher evaluates WatchRules against the fields of watched measurements, so that a program can react to a field
+// crossing a threshold (e.g., logging when a queue gets too deep) without waiting on a time-series database. Each
+// measurement may be a Point, a PointSet, or any other Measurement; MeasurementSets are expanded, and each of their
+// measurements is a separate series with its own state. A series is a combination of a measurement's key and tags.
+//
+// Rules are evaluated each time Evaluate is called, which Start does at an interval. When a rule starts or stops firing
+// for a series, the rule's Func is called and, if the Watcher has a writer, an event is written to it (see
+// WatchEvent.Measurement):
+//
+//	depth := new(dagr.Int)
+//	queue := dagr.NewPoint("queue", nil, dagr.Fields{"depth": depth})
+//
+//	watcher := dagr.NewWatcher(proxy)
+//	watcher.Watch(queue, dagr.WatchRule{
+//		Name:       "queue_depth",
+//		Field:      "depth",
+//		Threshold:  10000,
+//		Hysteresis: 1000,
+//		For:        3,
+//		Func: func(e dagr.WatchEvent) {
+//			log.Printf("queue depth alert firing=%t: %v", e.Firing, e.Value)
+//		},
+//	})
+//	watcher.Start(ctx, 10*time.Second)
+//
+// State for series that disappear (e.g., points removed from a PointSet) is dropped without an event. It is safe to use
+// a Watcher from concurrent goroutines, and rule Funcs may use the Watcher.
+type Watcher struct {
+	// EventKey is the key of events written by the Watcher. If EventKey is empty, it's DefaultWatchEventKey.
+	EventKey string
+
+	w MeasurementWriter
+
+	m       sync.Mutex // controls watches
+	watches []*watch
+}
+
+// watch is a measurement watched by a single rule.
+type watch struct {
+	m      Measurement
+	rule   WatchRule
+	series map[string]*watchSeries
+}
+
+// watchSeries is the state of a rule for a single series.
+type watchSeries struct {
+	key      string
+	tags     Tags
+	value    float64 // The last value compared against the threshold
+	last     float64 // The field's last value
+	lastTime time.Time
+	pending  int // The number of consecutive evaluations the condition has held for while not firing
+	firing   bool
+}
+
+// NewWatcher allocates a new Watcher that writes events to w. If w is nil, events are not written.
+func NewWatcher(w MeasurementWriter) *Watcher {
+	return &Watcher{w: w}
+}
+
+// Watch evaluates rule against m each time the Watcher is evaluated. The same measurement may be watched by more than
+// one rule. If m is nil, or rule has no name or field, Watch panics.
+func (w *Watcher) Watch(m Measurement, rule WatchRule) {
+	if m == nil {
+		panic("dagr: Watcher.Watch: measurement is nil")
+	} else if rule.Name == "" {
+		panic("dagr: Watcher.Watch: rule name is empty")
+	} else if rule.Field == "" {
+		panic("dagr: Watcher.Watch: rule field is empty")
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.watches = append(w.watches, &watch{m: m, rule: rule, series: make(map[string]*watchSeries)})
+}
+
+// Unwatch removes all rules with the given name. It returns the number of rules removed.
+func (w *Watcher) Unwatch(name string) int {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	kept := w.watches[:0]
+	for _, wa := range w.watches {
+		if wa.rule.Name != name {
+			kept = append(kept, wa)
+		}
+	}
+	for i := len(kept); i < len(w.watches); i++ {
+		w.watches[i] = nil
+	}
+	n := len(w.watches) - len(kept)
+	w.watches = kept
+	return n
+}
+
+// Firing returns events for all series that rules are currently firing for, as of their last evaluation, sorted by rule
+// name and series.
+func (w *Watcher) Firing() []WatchEvent {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	var events []WatchEvent
+	for _, wa := range w.watches {
+		for _, s := range wa.series {
+			if s.firing {
+				events = append(events, wa.event(s.key, s.tags, s.value, true, s.lastTime))
+			}
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		a, b := &events[i], &events[j]
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		return seriesID(a.Key, a.Tags) < seriesID(b.Key, b.Tags)
+	})
+	return events
+}
+
+func (wa *watch) event(key string, tags Tags, value float64, firing bool, when time.Time) WatchEvent {
+	return WatchEvent{
+		Rule:      wa.rule.Name,
+		Key:       key,
+		Tags:      tags,
+		Field:     wa.rule.Field,
+		Value:     value,
+		Threshold: wa.rule.Threshold,
+		Firing:    firing,
+		Time:      when,
+	}
+}
+
+// Evaluate evaluates all rules once and returns events for each series whose state changed. Rule Funcs are called, and
+// events are written, before Evaluate returns. Errors writing events are logged.
+func (w *Watcher) Evaluate() []WatchEvent {
+	type change struct {
+		event WatchEvent
+		fn    func(WatchEvent)
+	}
+
+	var changes []change
+
+	w.m.Lock()
+	now := clock.Now()
+	for _, wa := range w.watches {
+		seen := make(map[string]struct{}, len(wa.series))
+		for _, m := range Flatten(wa.m) {
+			key, tags := m.GetKey(), m.GetTags()
+			f, ok := m.GetFields()[wa.rule.Field]
+			if !ok {
+				continue
+			}
+			v, ok := numericValue(snapshotField(f))
+			if !ok {
+				continue
+			}
+
+			id := seriesID(key, tags)
+			seen[id] = struct{}{}
+			if e, ok := wa.evaluate(id, key, tags, v, now); ok {
+				changes = append(changes, change{e, wa.rule.Func})
+			}
+		}
+
+		for id := range wa.series {
+			if _, ok := seen[id]; !ok {
+				delete(wa.series, id)
+			}
+		}
+	}
+	w.m.Unlock()
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	events := make([]WatchEvent, len(changes))
+	for i, c := range changes {
+		events[i] = c.event
+		if c.fn != nil {
+			c.fn(c.event)
+		}
+	}
+
+	if w.w != nil {
+		key := w.EventKey
+		if key == "" {
+			key = DefaultWatchEventKey
+		}
+
+		ms := make([]Measurement, len(events))
+		for i, e := range events {
+			ms[i] = e.Measurement(key)
+		}
+		if _, err := w.w.WriteMeasurements(ms...); err != nil {
+			Log.Printf("dagr: unable to write watch events: %v", err)
+		}
+	}
+
+	return events
+}
+
+// evaluate updates the state of the series with the given ID and returns an event if it started or stopped firing.
+func (wa *watch) evaluate(id, key string, tags Tags, v float64, now time.Time) (WatchEvent, bool) {
+	s := wa.series[id]
+	if s == nil {
+		s = &watchSeries{key: key, tags: tags, last: v, lastTime: now}
+		wa.series[id] = s
+		if wa.rule.Condition == RateAbove || wa.rule.Condition == RateBelow {
+			// Rates need a previous value
+			return WatchEvent{}, false
+		}
+	}
+
+	value := v
+	if wa.rule.Condition == RateAbove || wa.rule.Condition == RateBelow {
+		elapsed := now.Sub(s.lastTime).Seconds()
+		if elapsed <= 0 {
+			return WatchEvent{}, false
+		}
+		value = (v - s.last) / elapsed
+	}
+	s.value, s.last, s.lastTime = value, v, now
+
+	firing := wa.rule.firing(value, s.firing)
+	switch {
+	case s.firing && !firing:
+		s.firing = false
+		return wa.event(key, tags, value, false, now), true
+	case !s.firing && firing:
+		if s.pending++; s.pending < wa.rule.For {
+			return WatchEvent{}, false
+		}
+		s.firing, s.pending = true, 0
+		return wa.event(key, tags, value, true, now), true
+	case !firing:
+		s.pending = 0
+	}
+	return WatchEvent{}, false
+}
+
+// Start creates a goroutine that evaluates the Watcher's rules at the given interval until the context is done.
+//
+// The context may not be nil.
+func (w *Watcher) Start(ctx context.Context, interval time.Duration) {
+	if ctx == nil {
+		panic("dagr: context is nil")
+	} else if interval <= 0 {
+		panic("dagr: interval must be > 0")
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.Evaluate()
+			}
+		}
+	}()
+}
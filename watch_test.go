@@ -0,0 +1,106 @@
+package dagr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	defer prepareLogger(t)()
+	defer func(c timeSource) { clock = c }(clock)
+
+	var written []Measurement
+	w := NewWatcher(WriteMeasurementsFunc(func(ms ...Measurement) (int64, error) {
+		written = append(written, ms...)
+		return int64(len(ms)), nil
+	}))
+
+	depth := new(Int)
+	queue := NewPoint("queue", Tags{"name": "jobs"}, Fields{"depth": depth})
+
+	var called []WatchEvent
+	w.Watch(queue, WatchRule{
+		Name:       "deep",
+		Field:      "depth",
+		Threshold:  100,
+		Hysteresis: 10,
+		For:        2,
+		Func:       func(e WatchEvent) { called = append(called, e) },
+	})
+
+	set := NewPointSet(StaticPointAllocator{Key: "http", IdentifierTag: "path", Fields: Fields{"errors": new(Int)}})
+	set.FieldsForID("/a", nil)
+	set.FieldsForID("/b", nil)
+	w.Watch(set, WatchRule{Name: "errors", Field: "errors", Condition: RateAbove, Threshold: 5})
+
+	tick := 0
+	eval := func(want ...string) {
+		t.Helper()
+		tick++
+		clock = testClock(testTime.Add(time.Duration(tick) * time.Second))
+		events := w.Evaluate()
+		var got []string
+		for _, e := range events {
+			state := "clear"
+			if e.Firing {
+				state = "firing"
+			}
+			got = append(got, e.Rule+" "+seriesID(e.Key, e.Tags)+" "+state)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("tick %d: events = %q; want %q", tick, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("tick %d: event %d = %q; want %q", tick, i, got[i], want[i])
+			}
+		}
+	}
+
+	eval()
+
+	// Sustained for two evaluations before firing.
+	depth.Set(150)
+	eval()
+	eval("deep queue\x00name=jobs firing")
+	if len(called) != 1 || called[0].Value != 150 || !called[0].Firing {
+		t.Errorf("called = %+v; want one firing event with value 150", called)
+	}
+
+	// Hysteresis keeps it firing until the depth is 90 or less.
+	depth.Set(95)
+	eval()
+	depth.Set(90)
+	eval("deep queue\x00name=jobs clear")
+
+	// Dipping below the threshold resets the sustained count.
+	depth.Set(150)
+	eval()
+	depth.Set(50)
+	eval()
+	depth.Set(150)
+	eval()
+
+	// Rate of change per point of a PointSet.
+	set.FieldsForID("/a", nil)["errors"].(*Int).Add(10)
+	eval("deep queue\x00name=jobs firing", "errors http\x00path=/a firing")
+	if firing := w.Firing(); len(firing) != 2 || firing[1].Rule != "errors" || firing[1].Value != 10 {
+		t.Errorf("Firing() = %+v; want deep and errors firing", firing)
+	}
+	eval("errors http\x00path=/a clear")
+
+	if len(written) != 5 {
+		t.Fatalf("len(written) = %d; want 5", len(written))
+	}
+	ev := written[0]
+	if ev.GetKey() != DefaultWatchEventKey || ev.GetTags()["rule"] != "deep" || ev.GetTags()["name"] != "jobs" ||
+		FieldValue(ev.GetFields()["firing"]) != true || FieldValue(ev.GetFields()["threshold"]) != 100.0 {
+		t.Errorf("event = %#v", ev)
+	}
+
+	if n := w.Unwatch("errors"); n != 1 {
+		t.Errorf("Unwatch() = %d; want 1", n)
+	}
+	set.FieldsForID("/b", nil)["errors"].(*Int).Add(100)
+	eval()
+}
@@ -3,16 +3,7 @@ package dagr
 import (
 	"bytes"
 	"io"
-	"sort"
-	"strconv"
-	"strings"
 	"sync"
-	"time"
-)
-
-const (
-	minBufferCapacity = 128
-	maxBufferCapacity = 65000
 )
 
 func allocMinimumBuffer() *tempBuffer {
@@ -99,49 +90,9 @@ func putBuffer(b *tempBuffer) {
 	tempBuffers.Put(b)
 }
 
-var tagEscaper = strings.NewReplacer(
-	` `, `\ `,
-	`=`, `\=`,
-	`,`, `\,`,
-)
-
-func writeFields(buf *tempBuffer, fields Fields, names []string) error {
-	if len(names) == 0 {
-		return ErrNoFields
-	}
-
-	for i, name := range names {
-		field := fields[name]
-		if i > 0 {
-			buf.WriteByte(',')
-		}
-		buf.WriteString(tagEscaper.Replace(name))
-		buf.WriteByte('=')
-
-		// On the off chance that the field reports an error writing, we have to be careful with it and truncate
-		// the buffer we got back to where the write started so we can leave the buffer sort of intact.
-		if _, err := field.WriteTo(buf); err != nil {
-			// This has the potential to panic IFF the buffer is being messed with from multiple goroutines.
-			return err
-		}
-	}
-
-	return nil
-}
-
-func writeTags(buf *tempBuffer, tags Tags, names []string) {
-	for _, name := range names {
-		tag := tags[name]
-		buf.WriteByte(',') // Because tags must necessarily follow a key or tag, always include the comma
-		buf.WriteString(tagEscaper.Replace(name))
-		buf.WriteByte('=')
-		buf.WriteString(tagEscaper.Replace(tag))
-	}
-}
-
 // WriteMeasurements writes all measurements with fields to w. Like WriteMeasurement, this will buffer the measurements
-// before writing them in their entirety to w. This is effectively the same as iterating over ms and writing each
-// measurement to a temporary buffer before writing to w.
+// before writing them in their entirety to w. This is effectively the same as calling AppendMeasurement for each of ms
+// and writing the result to w.
 //
 // Unlike WriteMeasurement, this will not return an error if a measurement has no fields. Measurements without fields
 // are silently ignored. If no measurements are written, WriteMeasurements returns 0 and nil.
@@ -150,117 +101,37 @@ func WriteMeasurements(w io.Writer, ms ...Measurement) (n int64, err error) {
 		return 0, nil
 	}
 
-	buf := getBuffer(w)
-	defer putBuffer(buf)
+	bp := getScratch()
+	b := *bp
+	defer func() { putScratch(bp, b) }()
 
 	for _, m := range ms {
-		head := buf.Len()
-		if _, err := WriteMeasurement(buf, m); err == ErrNoFields {
-			// Disregard
-			buf.Truncate(head)
+		next, err := appendMeasurement(b, m, nil, nil)
+		if err == ErrNoFields {
+			continue
 		} else if err != nil {
-			buf.Truncate(int(buf.head))
 			return 0, err
 		}
+		b = next
 	}
 
-	if buf.Len() == int(buf.head) {
-		return 0, nil
-	}
-
-	return buf.WriteTo(w)
+	return writeScratch(w, b, nil)
 }
 
 // WriteMeasurement writes a single measurement, m, to w. It returns the number of bytes written and any error that
-// occurred when writing the measurement.
+// occurred when writing the measurement. The measurement is encoded with AppendMeasurement and written to w all at
+// once.
 //
 // When writing tags and fields, both are sorted by name in ascending order. So, a tag named "pid" will precede a tag
 // named "version", and a field name "depth" will precede a field named "value".
 //
 // If the measurement has no fields, it returns 0 and ErrNoFields.
 //
-// If the measurement implements io.WriterTo, this simply calls that instead of WriteMeasurement.
+// If the measurement implements io.WriterTo and isn't one of dagr's measurement types, its WriteTo method is used to
+// encode it.
 func WriteMeasurement(w io.Writer, m Measurement) (n int64, err error) {
-	buf := getBuffer(w)
-	defer putBuffer(buf)
-
-	if mw, ok := m.(io.WriterTo); ok {
-		n, err := mw.WriteTo(buf)
-		if err != nil {
-			return n, err
-		}
-		return buf.WriteTo(w)
-	}
-
-	var when time.Time
-	if m, ok := m.(TimeMeasurement); ok {
-		when = m.GetTime()
-	} else {
-		when = clock.Now()
-	}
-
-	tags := m.GetTags()
-	fields := m.GetFields()
-
-	if len(fields) == 0 {
-		return 0, ErrNoFields
-	}
-
-	// Write key
-	buf.WriteString(tagEscaper.Replace(m.GetKey()))
-
-	nameLen := len(tags)
-	if l := len(fields); l > nameLen {
-		nameLen = l
-	}
-	names := make([]string, 0, nameLen)
-
-	// Write tags
-	if len(tags) > 0 {
-		for name := range tags {
-			names = append(names, name)
-		}
-		sort.Strings(names)
-		writeTags(buf, tags, names)
-
-		// Reset names slice so we can sort field names
-		names = names[0:0]
-	}
-
-	// Write fields
-	for name := range fields {
-		names = append(names, name)
-	}
-	sort.Strings(names)
-	buf.WriteByte(' ')
-	if err := writeFields(buf, fields, names); err != nil {
-		buf.Truncate(int(buf.head))
-		return 0, err
-	}
-
-	buf.WriteByte(' ')
-	writeTimestamp(buf, when)
-	buf.WriteByte('\n')
-
-	return buf.WriteTo(w)
-}
-
-func writeTimestamp(w io.Writer, ts time.Time) (n int64, err error) {
-	var buf [20]byte
-	tsb := strconv.AppendInt(buf[0:0], ts.UnixNano(), 10)
-	in, err := w.Write(tsb)
-	return int64(in), err
-}
-
-func writeByte(w io.Writer, b byte) error {
-	if bw, ok := w.(io.ByteWriter); ok {
-		return bw.WriteByte(b)
-	}
-
-	buf := [1]byte{b}
-	n, err := w.Write(buf[0:1])
-	if err == nil && n == 0 {
-		err = io.ErrShortWrite
-	}
-	return err
+	bp := getScratch()
+	b, err := appendMeasurement(*bp, m, nil, nil)
+	defer putScratch(bp, b)
+	return writeScratch(w, b, err)
 }